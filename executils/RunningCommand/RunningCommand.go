@@ -18,6 +18,9 @@ type RunningCommand struct {
 	OnFinishAction   actionOnFinish
 	AdditionalObject interface{}
 	WorkingDirectory string
+	Environment      []string
+	//NoStdin starts the command without input, otherwise it shares the stdin of this process
+	NoStdin          bool
 	CommandExePath   string
 	CommandArguments []string
 	CommandObj       *exec.Cmd
@@ -124,7 +127,14 @@ func (r *RunningCommand) Start(timeoutDuration time.Duration) *RunningCommand {
 		cmd.Dir = r.WorkingDirectory
 	}
 
-	cmd.Stdin = os.Stdin
+	if len(r.Environment) > 0 {
+		//Additional "KEY=value" pairs on top of the current process environment
+		cmd.Env = append(os.Environ(), r.Environment...)
+	}
+
+	if !r.NoStdin {
+		cmd.Stdin = os.Stdin
+	}
 
 	reader, err := cmd.StdoutPipe()
 	CheckError(err)
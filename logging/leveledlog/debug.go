@@ -0,0 +1,32 @@
+package leveledlog
+
+// DebugLogger only has the Debug method of a Logger, like the loggers passed to this repo before it had leveled logging
+type DebugLogger interface {
+	Debug(msg string, args ...interface{})
+}
+
+type debugSink struct {
+	logger DebugLogger
+}
+
+func (s debugSink) Write(level Level, message string, fields []Field) {
+	if level != DebugLevel {
+		message = "[" + level.String() + "] " + message
+	}
+	s.logger.Debug("%s", formatMessage(message, fields))
+}
+
+/*
+FromDebugLogger returns l itself if it already is a Logger, otherwise a Logger writing all levels to its Debug method.
+The messages of the other levels are tagged with their level like "[WARN] message" and the fields are appended to
+the messages. A nil l returns Discard().
+*/
+func FromDebugLogger(l DebugLogger) Logger {
+	if l == nil {
+		return Discard()
+	}
+	if logger, ok := l.(Logger); ok {
+		return logger
+	}
+	return New(debugSink{logger: l})
+}
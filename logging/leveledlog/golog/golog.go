@@ -0,0 +1,58 @@
+package golog
+
+import (
+	"github.com/ian-kent/go-log/appenders"
+	"github.com/ian-kent/go-log/levels"
+	"github.com/ian-kent/go-log/logger"
+
+	"github.com/francoishill/golang-web-dry/logging/leveledlog"
+)
+
+func toGoLogLevel(level leveledlog.Level) levels.LogLevel {
+	switch level {
+	case leveledlog.DebugLevel:
+		return levels.DEBUG
+	case leveledlog.InfoLevel:
+		return levels.INFO
+	case leveledlog.WarnLevel:
+		return levels.WARN
+	}
+	return levels.ERROR
+}
+
+type loggerSink struct {
+	l logger.Logger
+}
+
+func (s *loggerSink) Write(level leveledlog.Level, message string, fields []leveledlog.Field) {
+	if len(fields) > 0 {
+		message += " " + leveledlog.FormatFields(fields)
+	}
+	s.l.Log(toGoLogLevel(level), message)
+}
+
+// New logs to the go-log logger, which filters the levels and writes to its appender
+func New(l logger.Logger) leveledlog.Logger {
+	return leveledlog.New(&loggerSink{l})
+}
+
+type appenderSink struct {
+	appender appenders.Appender
+}
+
+func (s *appenderSink) Write(level leveledlog.Level, message string, fields []leveledlog.Field) {
+	if len(fields) > 0 {
+		message += " " + leveledlog.FormatFields(fields)
+	}
+	s.appender.Write(toGoLogLevel(level), message)
+}
+
+/*
+NewFromAppender writes all levels directly to the go-log appender, formatted by its layout. Combine several with
+leveledlog.Multi, for example to log to the console and a rolling file:
+
+	logger := leveledlog.Multi(golog.NewFromAppender(consoleAppender), golog.NewFromAppender(rollingFileAppender))
+*/
+func NewFromAppender(appender appenders.Appender) leveledlog.Logger {
+	return leveledlog.New(&appenderSink{appender})
+}
@@ -0,0 +1,163 @@
+package leveledlog
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "DEBUG"
+	case InfoLevel:
+		return "INFO"
+	case WarnLevel:
+		return "WARN"
+	case ErrorLevel:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+// Field is a key and value logged with a message, like the path or the bytes of a transfer
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F creates a Field
+func F(key string, value interface{}) Field {
+	return Field{key, value}
+}
+
+/*
+Logger is the leveled, structured logger used by all packages of this repo that log. The messages are formatted with
+fmt.Sprintf, the fields of With are added to all messages of the returned Logger. For example:
+
+	logger.With(leveledlog.F("path", remotePath), leveledlog.F("bytes", size)).Debug("Uploading %s", localPath)
+
+Create one with an adapter: NewStd (standard library log), NewSlog (log/slog), golog.New (go-log) or New with an own Sink.
+*/
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+	With(fields ...Field) Logger
+}
+
+// Sink writes the messages of a Logger created with New, the message is already formatted
+type Sink interface {
+	Write(level Level, message string, fields []Field)
+}
+
+type logger struct {
+	sink   Sink
+	fields []Field
+}
+
+// New creates a Logger writing to the sink
+func New(sink Sink) Logger {
+	return &logger{sink: sink}
+}
+
+// log must be called directly by the level methods, the standard library adapter relies on the call depth
+func (l *logger) log(level Level, msg string, args []interface{}) {
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+	l.sink.Write(level, msg, l.fields)
+}
+
+func (l *logger) Debug(msg string, args ...interface{}) {
+	l.log(DebugLevel, msg, args)
+}
+
+func (l *logger) Info(msg string, args ...interface{}) {
+	l.log(InfoLevel, msg, args)
+}
+
+func (l *logger) Warn(msg string, args ...interface{}) {
+	l.log(WarnLevel, msg, args)
+}
+
+func (l *logger) Error(msg string, args ...interface{}) {
+	l.log(ErrorLevel, msg, args)
+}
+
+func (l *logger) With(fields ...Field) Logger {
+	allFields := make([]Field, 0, len(l.fields)+len(fields))
+	allFields = append(allFields, l.fields...)
+	allFields = append(allFields, fields...)
+	return &logger{sink: l.sink, fields: allFields}
+}
+
+type discardSink struct{}
+
+func (d discardSink) Write(level Level, message string, fields []Field) {}
+
+// Discard returns a Logger dropping all messages
+func Discard() Logger {
+	return New(discardSink{})
+}
+
+type multiSink []Logger
+
+func (m multiSink) Write(level Level, message string, fields []Field) {
+	for _, l := range m {
+		l = l.With(fields...)
+		switch level {
+		case DebugLevel:
+			l.Debug("%s", message)
+			break
+		case InfoLevel:
+			l.Info("%s", message)
+			break
+		case WarnLevel:
+			l.Warn("%s", message)
+			break
+		default:
+			l.Error("%s", message)
+			break
+		}
+	}
+}
+
+// Multi returns a Logger writing every message to all of the loggers, like to the console and a log file
+func Multi(loggers ...Logger) Logger {
+	return New(multiSink(loggers))
+}
+
+/*
+FormatFields formats the fields as space separated key=value pairs, values with spaces or quotes are quoted. For example:
+
+	path=/srv/builds/app.zip bytes=1024 error="no such file"
+*/
+func FormatFields(fields []Field) string {
+	pairs := make([]string, 0, len(fields))
+	for _, field := range fields {
+		value := fmt.Sprintf("%v", field.Value)
+		if value == "" || strings.ContainsAny(value, " \t\r\n\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		pairs = append(pairs, field.Key+"="+value)
+	}
+	return strings.Join(pairs, " ")
+}
+
+// formatMessage appends the formatted fields to the message
+func formatMessage(message string, fields []Field) string {
+	if len(fields) == 0 {
+		return message
+	}
+	return message + " " + FormatFields(fields)
+}
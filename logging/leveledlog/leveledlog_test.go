@@ -0,0 +1,92 @@
+package leveledlog
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"log/slog"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLeveledLog(t *testing.T) {
+	Convey("Testing FormatFields", t, func() {
+		So(FormatFields([]Field{F("path", "/srv/a.zip"), F("bytes", 12)}), ShouldEqual, "path=/srv/a.zip bytes=12")
+		So(FormatFields([]Field{F("error", `no "such" file`), F("empty", "")}), ShouldEqual, `error="no \"such\" file" empty=""`)
+	})
+
+	Convey("Testing the standard library adapter", t, func() {
+		buffer := &bytes.Buffer{}
+		logger := NewStd(log.New(buffer, "", 0))
+
+		logger.With(F("path", "/srv/a.zip")).With(F("bytes", 12)).Info("Uploaded %d files", 3)
+		So(buffer.String(), ShouldEqual, "[INFO] Uploaded 3 files path=/srv/a.zip bytes=12\n")
+
+		buffer.Reset()
+		logger.Debug("100% done")
+		So(buffer.String(), ShouldEqual, "[DEBUG] 100% done\n")
+
+		Convey("The levels without a logger should not be logged", func() {
+			errors := &bytes.Buffer{}
+			logger := NewStdByLevel(map[Level]*log.Logger{ErrorLevel: log.New(errors, "E ", 0)})
+			logger.Info("info")
+			logger.Error("failed")
+			So(errors.String(), ShouldEqual, "E failed\n")
+		})
+
+		Convey("The file of the caller should be logged", func() {
+			buffer := &bytes.Buffer{}
+			NewStd(log.New(buffer, "", log.Lshortfile)).Warn("careful")
+			So(buffer.String(), ShouldStartWith, "leveledlog_test.go:")
+		})
+	})
+
+	Convey("Testing the slog adapter", t, func() {
+		buffer := &bytes.Buffer{}
+		handler := slog.NewTextHandler(buffer, &slog.HandlerOptions{
+			Level: slog.LevelInfo,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				return a
+			},
+		})
+		logger := NewSlog(slog.New(handler))
+
+		logger.Debug("hidden")
+		logger.With(F("path", "/srv/a.zip")).Warn("Retrying %s", "upload")
+		So(buffer.String(), ShouldEqual, "level=WARN msg=\"Retrying upload\" path=/srv/a.zip\n")
+	})
+
+	Convey("Testing Multi", t, func() {
+		first, second := &bytes.Buffer{}, &bytes.Buffer{}
+		logger := Multi(NewStd(log.New(first, "", 0)), NewStd(log.New(second, "", 0)), Discard())
+		logger.With(F("bytes", 1)).Error("failed")
+		So(first.String(), ShouldEqual, "[ERROR] failed bytes=1\n")
+		So(second.String(), ShouldEqual, first.String())
+	})
+
+	Convey("Testing FromDebugLogger", t, func() {
+		debugLogger := &debugOnlyLogger{}
+		logger := FromDebugLogger(debugLogger)
+
+		logger.Debug("Saving %d files", 2)
+		logger.With(F("path", "/srv/a.zip")).Warn("Retrying")
+		So(debugLogger.messages, ShouldResemble, []string{"Saving 2 files", "[WARN] Retrying path=/srv/a.zip"})
+
+		discard := Discard()
+		So(FromDebugLogger(discard), ShouldEqual, discard)
+		So(FromDebugLogger(nil), ShouldNotBeNil)
+	})
+}
+
+// debugOnlyLogger is a logger like the ones passed to this repo before it had leveled logging
+type debugOnlyLogger struct {
+	messages []string
+}
+
+func (l *debugOnlyLogger) Debug(msg string, args ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(msg, args...))
+}
@@ -0,0 +1,40 @@
+package leveledlog
+
+import (
+	"context"
+	"log/slog"
+)
+
+type slogSink struct {
+	l *slog.Logger
+}
+
+func toSlogLevel(level Level) slog.Level {
+	switch level {
+	case DebugLevel:
+		return slog.LevelDebug
+	case InfoLevel:
+		return slog.LevelInfo
+	case WarnLevel:
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
+
+func (s *slogSink) Write(level Level, message string, fields []Field) {
+	slogLevel := toSlogLevel(level)
+	if !s.l.Enabled(context.Background(), slogLevel) {
+		return
+	}
+
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, field := range fields {
+		attrs = append(attrs, slog.Any(field.Key, field.Value))
+	}
+	s.l.LogAttrs(context.Background(), slogLevel, message, attrs...)
+}
+
+// NewSlog logs to the log/slog logger, the fields become its attributes
+func NewSlog(l *slog.Logger) Logger {
+	return New(&slogSink{l})
+}
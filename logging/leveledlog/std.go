@@ -0,0 +1,47 @@
+package leveledlog
+
+import (
+	"log"
+)
+
+// The call depth from the log.Logger Output call to the caller of the Logger: stdSink.Write, logger.log and the level method
+const stdCallDepth = 4
+
+type stdSink struct {
+	loggers map[Level]*log.Logger
+	tags    bool
+}
+
+func (s *stdSink) Write(level Level, message string, fields []Field) {
+	l, ok := s.loggers[level]
+	if !ok || l == nil {
+		return
+	}
+
+	if s.tags {
+		message = "[" + level.String() + "] " + message
+	}
+	l.Output(stdCallDepth, formatMessage(message, fields))
+}
+
+// NewStd logs all levels to the standard library logger, the messages are tagged with their level like "[INFO] message"
+func NewStd(l *log.Logger) Logger {
+	return New(&stdSink{
+		loggers: map[Level]*log.Logger{DebugLevel: l, InfoLevel: l, WarnLevel: l, ErrorLevel: l},
+		tags:    true,
+	})
+}
+
+/*
+NewStdByLevel logs every level to its own standard library logger, the levels without a logger are not logged. For
+example to keep stdout for the output and write the warnings and errors to stderr:
+
+	logger := leveledlog.NewStdByLevel(map[leveledlog.Level]*log.Logger{
+		leveledlog.InfoLevel:  log.New(os.Stdout, "[I] ", log.LstdFlags),
+		leveledlog.WarnLevel:  log.New(os.Stderr, "[W] ", log.LstdFlags),
+		leveledlog.ErrorLevel: log.New(os.Stderr, "[E] ", log.LstdFlags),
+	})
+*/
+func NewStdByLevel(loggers map[Level]*log.Logger) Logger {
+	return New(&stdSink{loggers: loggers})
+}
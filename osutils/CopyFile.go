@@ -0,0 +1,42 @@
+package osutils
+
+import (
+	"io"
+	"os"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+)
+
+/*
+CopyFile copies the contents, mode and modification time of srcFile to destFile, overwriting destFile if it exists.
+
+The copy is a ReplacingFile, so an existing destFile is replaced (never truncated in place) and other hard links
+to it keep their content.
+*/
+func CopyFile(srcFile, destFile string) {
+	src, err := os.Open(srcFile)
+	CheckError(err)
+	defer src.Close()
+
+	info, err := src.Stat()
+	CheckError(err)
+
+	dest, err := CreateReplacingFile(destFile, info.Mode())
+	CheckError(err)
+
+	defer func() {
+		if r := recover(); r != nil {
+			dest.Abort()
+			panic(r)
+		}
+	}()
+
+	_, err = io.Copy(dest, src)
+	CheckError(err)
+
+	err = dest.Close()
+	CheckError(err)
+
+	err = os.Chtimes(destFile, info.ModTime(), info.ModTime())
+	CheckError(err)
+}
@@ -0,0 +1,6 @@
+package osutils
+
+type DiskSpace struct {
+	FreeBytes  uint64
+	TotalBytes uint64
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package osutils
+
+import (
+	"syscall"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+)
+
+// GetDiskSpace returns the space of the file system containing path, FreeBytes is what is available to unprivileged users
+func GetDiskSpace(path string) *DiskSpace {
+	stat := &syscall.Statfs_t{}
+	err := syscall.Statfs(path, stat)
+	CheckError(err)
+
+	return &DiskSpace{
+		FreeBytes:  uint64(stat.Bavail) * uint64(stat.Bsize),
+		TotalBytes: uint64(stat.Blocks) * uint64(stat.Bsize),
+	}
+}
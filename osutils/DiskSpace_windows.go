@@ -0,0 +1,31 @@
+package osutils
+
+import (
+	"syscall"
+	"unsafe"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+)
+
+var getDiskFreeSpaceExW = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// GetDiskSpace returns the space of the volume containing path, FreeBytes is what is available to the current user
+func GetDiskSpace(path string) *DiskSpace {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	CheckError(err)
+
+	var freeBytesAvailable, totalBytes, totalFreeBytes uint64
+	ret, _, err := getDiskFreeSpaceExW.Call(
+		uintptr(unsafe.Pointer(pathPtr)),
+		uintptr(unsafe.Pointer(&freeBytesAvailable)),
+		uintptr(unsafe.Pointer(&totalBytes)),
+		uintptr(unsafe.Pointer(&totalFreeBytes)))
+	if ret == 0 {
+		panic(err)
+	}
+
+	return &DiskSpace{
+		FreeBytes:  freeBytesAvailable,
+		TotalBytes: totalBytes,
+	}
+}
@@ -0,0 +1,7 @@
+package osutils
+
+// FileId identifies a file on its device, hard links to the same content have the same FileId (see GetFileId)
+type FileId struct {
+	Device uint64
+	Index  uint64
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package osutils
+
+import (
+	"os"
+	"syscall"
+)
+
+// GetFileId returns an id that is the same for all hard links to the file of info (as returned by os.Stat/Lstat of path), ok is false if it is unknown
+func GetFileId(path string, info os.FileInfo) (id FileId, ok bool) {
+	if stat, isStat := info.Sys().(*syscall.Stat_t); isStat {
+		return FileId{Device: uint64(stat.Dev), Index: uint64(stat.Ino)}, true
+	}
+	return FileId{}, false
+}
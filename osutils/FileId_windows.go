@@ -0,0 +1,21 @@
+package osutils
+
+import (
+	"os"
+	"syscall"
+)
+
+// GetFileId returns an id that is the same for all hard links to the file at path, info is not used on windows
+func GetFileId(path string, info os.FileInfo) (id FileId, ok bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return FileId{}, false
+	}
+	defer file.Close()
+
+	fileInfo := &syscall.ByHandleFileInformation{}
+	if err = syscall.GetFileInformationByHandle(syscall.Handle(file.Fd()), fileInfo); err != nil {
+		return FileId{}, false
+	}
+	return FileId{Device: uint64(fileInfo.VolumeSerialNumber), Index: uint64(fileInfo.FileIndexHigh)<<32 | uint64(fileInfo.FileIndexLow)}, true
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package osutils
+
+import (
+	"os"
+	"syscall"
+)
+
+// GetHardLinkCount returns the number of hard links to the file of info (as returned by os.Stat/Lstat of path)
+func GetHardLinkCount(path string, info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Nlink)
+	}
+	return 1
+}
@@ -0,0 +1,21 @@
+package osutils
+
+import (
+	"os"
+	"syscall"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+)
+
+// GetHardLinkCount returns the number of hard links to the file at path, info is not used on windows
+func GetHardLinkCount(path string, info os.FileInfo) uint64 {
+	file, err := os.Open(path)
+	CheckError(err)
+	defer file.Close()
+
+	fileInfo := &syscall.ByHandleFileInformation{}
+	err = syscall.GetFileInformationByHandle(syscall.Handle(file.Fd()), fileInfo)
+	CheckError(err)
+
+	return uint64(fileInfo.NumberOfLinks)
+}
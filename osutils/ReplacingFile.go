@@ -0,0 +1,60 @@
+package osutils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+/*
+ReplacingFile is written to a temporary file next to its path, which only replaces the file at path once it is
+closed. An existing file is never truncated in place (other hard links to it keep their content) and the new content
+is only visible once it is complete.
+*/
+type ReplacingFile struct {
+	*os.File
+	path   string
+	mode   os.FileMode
+	closed bool
+}
+
+// CreateReplacingFile creates a ReplacingFile for path, the parent directory must exist. The file gets mode once closed (not reduced by the umask).
+func CreateReplacingFile(path string, mode os.FileMode) (*ReplacingFile, error) {
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return nil, err
+	}
+	return &ReplacingFile{File: file, path: path, mode: mode}, nil
+}
+
+// Close replaces the file at path with the written content, closing it again does nothing
+func (f *ReplacingFile) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+
+	tempPath := f.File.Name()
+	err := f.File.Close()
+	if err == nil {
+		err = os.Chmod(tempPath, f.mode)
+	}
+	if err == nil {
+		err = os.Rename(tempPath, f.path)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+	}
+	return err
+}
+
+// Abort removes the temporary file without replacing the file at path
+func (f *ReplacingFile) Abort() {
+	if f.closed {
+		return
+	}
+	f.closed = true
+
+	f.File.Close()
+	os.Remove(f.File.Name())
+}
@@ -0,0 +1,212 @@
+/*
+Package cliconfig lets the fileserver and fileclient-cli flags also be set with environment variables and a YAML
+config file. The value of a flag is taken from (in this order):
+
+	the command line                   --serverurl http://files:60878
+	the environment variable           FILECLIENT_SERVERURL=http://files:60878 (prefix + flag name, '-' becomes '_')
+	the config file                    serverurl: http://files:60878
+	the default of the flag
+
+Environment variables in the config file are expanded, so secrets do not have to be stored in it:
+
+	token: ${FILES_TOKEN}
+*/
+package cliconfig
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+	"gopkg.in/yaml.v2"
+)
+
+// Values are flag values by (long) flag name, a value is a scalar or (for slice flags) a list of scalars
+type Values map[string]interface{}
+
+// LoadFile reads the YAML file at path into out after expanding the environment variables in it, it returns false if the file does not exist
+func LoadFile(path string, out interface{}) bool {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false
+	}
+	CheckError(err)
+
+	err = yaml.Unmarshal([]byte(os.ExpandEnv(string(data))), out)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid config file '%s': %s", path, err.Error()))
+	}
+	return true
+}
+
+// FlagError is panicked by Lookup when a flag has no value (see RequireString) or a value of the wrong type
+type FlagError struct {
+	FlagName string
+	Message  string
+}
+
+func (e *FlagError) Error() string {
+	return e.Message
+}
+
+func panicFlagError(flagName, format string, args ...interface{}) {
+	panic(&FlagError{FlagName: flagName, Message: fmt.Sprintf(format, args...)})
+}
+
+/*
+Lookup resolves the flags of Context, see the package documentation for the order. When Context is the context of a
+command, a flag may be given after the command name (a flag of the command) or before it (a flag of the app):
+
+	fileclient-cli --serverurl http://files:60878 rm --dry-run /srv/logs
+*/
+type Lookup struct {
+	Context *cli.Context
+	//EnvPrefix is prepended to the upper case flag name, for example FILESERVER_
+	EnvPrefix string
+	//Config are the values from the config file, can be nil
+	Config Values
+}
+
+// EnvVarName is the environment variable of flagName, for example FILESERVER_LOCK_TIMEOUT for lock-timeout
+func (l *Lookup) EnvVarName(flagName string) string {
+	return l.EnvPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// lookup returns the value of the environment or config file, found is false if the flag default applies
+func (l *Lookup) lookup(flagName string) (value string, found bool) {
+	if env := os.Getenv(l.EnvVarName(flagName)); env != "" {
+		return env, true
+	}
+
+	if configValue, ok := l.Config[flagName]; ok && configValue != nil {
+		if _, isList := configValue.([]interface{}); isList {
+			panicFlagError(flagName, "Config value '%s' must be a single value, not a list", flagName)
+		}
+		return fmt.Sprint(configValue), true
+	}
+	return "", false
+}
+
+func (l *Lookup) String(flagName string) string {
+	c := l.Context
+	if c.IsSet(flagName) {
+		return c.String(flagName)
+	}
+	if c.GlobalIsSet(flagName) {
+		return c.GlobalString(flagName)
+	}
+	if value, found := l.lookup(flagName); found {
+		return value
+	}
+	if value := c.String(flagName); value != "" {
+		return value
+	}
+	return c.GlobalString(flagName)
+}
+
+// RequireString panics with a *FlagError explaining all the ways to set flagName if it has no value
+func (l *Lookup) RequireString(flagName string) string {
+	val := l.String(flagName)
+	if strings.TrimSpace(val) == "" {
+		panicFlagError(flagName, "Flag '%s' is empty, set it with --%s, the %s environment variable or in the config file", flagName, flagName, l.EnvVarName(flagName))
+	}
+	return val
+}
+
+func (l *Lookup) Bool(flagName string) bool {
+	c := l.Context
+	if c.IsSet(flagName) {
+		return c.Bool(flagName)
+	}
+	if c.GlobalIsSet(flagName) {
+		return c.GlobalBool(flagName)
+	}
+	if value, found := l.lookup(flagName); found {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			panicFlagError(flagName, "Flag '%s' must be true or false, got '%s'", flagName, value)
+		}
+		return b
+	}
+	return c.Bool(flagName) || c.GlobalBool(flagName)
+}
+
+func (l *Lookup) Int(flagName string) int {
+	c := l.Context
+	if c.IsSet(flagName) {
+		return c.Int(flagName)
+	}
+	if c.GlobalIsSet(flagName) {
+		return c.GlobalInt(flagName)
+	}
+	if value, found := l.lookup(flagName); found {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			panicFlagError(flagName, "Flag '%s' must be a whole number, got '%s'", flagName, value)
+		}
+		return i
+	}
+	if i := c.Int(flagName); i != 0 {
+		return i
+	}
+	return c.GlobalInt(flagName)
+}
+
+func (l *Lookup) Duration(flagName string) time.Duration {
+	c := l.Context
+	if c.IsSet(flagName) {
+		return c.Duration(flagName)
+	}
+	if c.GlobalIsSet(flagName) {
+		return c.GlobalDuration(flagName)
+	}
+	if value, found := l.lookup(flagName); found {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			panicFlagError(flagName, "Flag '%s' must be a duration like 30s, got '%s'", flagName, value)
+		}
+		return d
+	}
+	if d := c.Duration(flagName); d != 0 {
+		return d
+	}
+	return c.GlobalDuration(flagName)
+}
+
+// StringSlice is the list of values of a repeatable flag, the environment variable separates them with ';'
+func (l *Lookup) StringSlice(flagName string) []string {
+	c := l.Context
+	if c.IsSet(flagName) {
+		return c.StringSlice(flagName)
+	}
+	if c.GlobalIsSet(flagName) {
+		return c.GlobalStringSlice(flagName)
+	}
+
+	if env := os.Getenv(l.EnvVarName(flagName)); env != "" {
+		return strings.Split(env, ";")
+	}
+
+	switch configValue := l.Config[flagName].(type) {
+	case nil:
+		break
+	case []interface{}:
+		values := []string{}
+		for _, item := range configValue {
+			values = append(values, fmt.Sprint(item))
+		}
+		return values
+	default:
+		return []string{fmt.Sprint(configValue)}
+	}
+
+	if values := c.StringSlice(flagName); len(values) > 0 {
+		return values
+	}
+	return c.GlobalStringSlice(flagName)
+}
@@ -0,0 +1,163 @@
+package cliconfig
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/cli"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLoadFile(t *testing.T) {
+	Convey("Testing LoadFile", t, func() {
+		dir, err := ioutil.TempDir("", "cliconfig")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		configPath := filepath.Join(dir, "config.yml")
+		So(ioutil.WriteFile(configPath, []byte("port: 5003\ntoken: ${CLICONFIG_TEST_TOKEN}\nroot:\n  - a=/srv/a\n  - b=/srv/b\n"), 0600), ShouldBeNil)
+		os.Setenv("CLICONFIG_TEST_TOKEN", "secret")
+		defer os.Unsetenv("CLICONFIG_TEST_TOKEN")
+
+		Convey("Environment variables should be expanded", func() {
+			values := Values{}
+			So(LoadFile(configPath, &values), ShouldBeTrue)
+			So(values["port"], ShouldEqual, 5003)
+			So(values["token"], ShouldEqual, "secret")
+			So(values["root"], ShouldResemble, []interface{}{"a=/srv/a", "b=/srv/b"})
+		})
+
+		Convey("A missing file should not be an error", func() {
+			values := Values{}
+			So(LoadFile(filepath.Join(dir, "missing.yml"), &values), ShouldBeFalse)
+		})
+
+		Convey("Invalid YAML should panic", func() {
+			So(ioutil.WriteFile(configPath, []byte("port: [5003"), 0600), ShouldBeNil)
+			So(func() { LoadFile(configPath, &Values{}) }, ShouldPanic)
+		})
+	})
+
+	Convey("Testing EnvVarName", t, func() {
+		l := &Lookup{EnvPrefix: "FILESERVER_"}
+		So(l.EnvVarName("lock-timeout"), ShouldEqual, "FILESERVER_LOCK_TIMEOUT")
+	})
+}
+
+// newTestFlagSet has the flags of the Lookup tests, as the flags of an app or of a command
+func newTestFlagSet(args []string) *flag.FlagSet {
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	set.String("name", "default", "")
+	set.Bool("verbose", false, "")
+	set.Int("retries", 3, "")
+	set.Duration("timeout", 30*time.Second, "")
+	set.Var(&cli.StringSlice{}, "root", "")
+	So(set.Parse(args), ShouldBeNil)
+	return set
+}
+
+// lookupCase is the value expected from a flag given on the command line (of the command or of the app), in the environment and in the config file
+type lookupCase struct {
+	description string
+	args        []string
+	globalArgs  []string
+	env         string
+	config      interface{}
+	expected    interface{}
+}
+
+func testLookupCases(flagName string, get func(l *Lookup) interface{}, cases []lookupCase) {
+	for _, tc := range cases {
+		Convey(tc.description, func() {
+			l := &Lookup{
+				Context:   cli.NewContext(nil, newTestFlagSet(tc.args), newTestFlagSet(tc.globalArgs)),
+				EnvPrefix: "CLICONFIG_TEST_",
+				Config:    Values{},
+			}
+			if tc.config != nil {
+				l.Config[flagName] = tc.config
+			}
+			if tc.env != "" {
+				os.Setenv(l.EnvVarName(flagName), tc.env)
+				defer os.Unsetenv(l.EnvVarName(flagName))
+			}
+
+			So(get(l), ShouldResemble, tc.expected)
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	Convey("Testing the order of flag, environment, config file and default in Lookup", t, func() {
+		Convey("String", func() {
+			testLookupCases("name", func(l *Lookup) interface{} { return l.String("name") }, []lookupCase{
+				{description: "The default applies if nothing is set", expected: "default"},
+				{description: "The config file overrides the default", config: "config", expected: "config"},
+				{description: "The environment overrides the config file", env: "env", config: "config", expected: "env"},
+				{description: "The flag of the app overrides the environment", globalArgs: []string{"--name", "app"}, env: "env", config: "config", expected: "app"},
+				{description: "The flag of the command overrides all", args: []string{"--name", "command"}, globalArgs: []string{"--name", "app"}, env: "env", config: "config", expected: "command"},
+			})
+		})
+
+		Convey("Bool", func() {
+			testLookupCases("verbose", func(l *Lookup) interface{} { return l.Bool("verbose") }, []lookupCase{
+				{description: "The default applies if nothing is set", expected: false},
+				{description: "The config file overrides the default", config: true, expected: true},
+				{description: "The environment overrides the config file", env: "false", config: true, expected: false},
+				{description: "The flag of the app overrides the environment", globalArgs: []string{"--verbose"}, env: "false", expected: true},
+				{description: "The flag of the command overrides all", args: []string{"--verbose=false"}, globalArgs: []string{"--verbose"}, env: "true", config: true, expected: false},
+			})
+		})
+
+		Convey("Int", func() {
+			testLookupCases("retries", func(l *Lookup) interface{} { return l.Int("retries") }, []lookupCase{
+				{description: "The default applies if nothing is set", expected: 3},
+				{description: "The config file overrides the default", config: 5, expected: 5},
+				{description: "The environment overrides the config file", env: "7", config: 5, expected: 7},
+				{description: "The flag of the app overrides the environment", globalArgs: []string{"--retries", "8"}, env: "7", expected: 8},
+				{description: "The flag of the command overrides all", args: []string{"--retries", "9"}, globalArgs: []string{"--retries", "8"}, env: "7", config: 5, expected: 9},
+			})
+		})
+
+		Convey("Duration", func() {
+			testLookupCases("timeout", func(l *Lookup) interface{} { return l.Duration("timeout") }, []lookupCase{
+				{description: "The default applies if nothing is set", expected: 30 * time.Second},
+				{description: "The config file overrides the default", config: "1m", expected: time.Minute},
+				{description: "The environment overrides the config file", env: "2m", config: "1m", expected: 2 * time.Minute},
+				{description: "The flag of the app overrides the environment", globalArgs: []string{"--timeout", "3m"}, env: "2m", expected: 3 * time.Minute},
+				{description: "The flag of the command overrides all", args: []string{"--timeout", "4m"}, globalArgs: []string{"--timeout", "3m"}, env: "2m", config: "1m", expected: 4 * time.Minute},
+			})
+		})
+
+		Convey("StringSlice", func() {
+			testLookupCases("root", func(l *Lookup) interface{} { return append([]string{}, l.StringSlice("root")...) }, []lookupCase{
+				{description: "Nothing is set", expected: []string{}},
+				{description: "A list in the config file", config: []interface{}{"a=/srv/a", "b=/srv/b"}, expected: []string{"a=/srv/a", "b=/srv/b"}},
+				{description: "A single value in the config file", config: "a=/srv/a", expected: []string{"a=/srv/a"}},
+				{description: "The environment is split on ';' and overrides the config file", env: "c=/srv/c;d=/srv/d", config: "a=/srv/a", expected: []string{"c=/srv/c", "d=/srv/d"}},
+				{description: "The repeated flag of the app overrides the environment", globalArgs: []string{"--root", "e=/srv/e", "--root", "f=/srv/f"}, env: "c=/srv/c", expected: []string{"e=/srv/e", "f=/srv/f"}},
+				{description: "The flag of the command overrides all", args: []string{"--root", "g=/srv/g"}, globalArgs: []string{"--root", "e=/srv/e"}, env: "c=/srv/c", config: "a=/srv/a", expected: []string{"g=/srv/g"}},
+			})
+		})
+
+		Convey("Invalid values in the environment or config file should panic with a *FlagError", func() {
+			l := &Lookup{Context: cli.NewContext(nil, newTestFlagSet(nil), newTestFlagSet(nil)), EnvPrefix: "CLICONFIG_TEST_", Config: Values{"timeout": "soon", "name": []interface{}{"a", "b"}}}
+			os.Setenv("CLICONFIG_TEST_RETRIES", "many")
+			defer os.Unsetenv("CLICONFIG_TEST_RETRIES")
+
+			for _, get := range []func(){func() { l.Int("retries") }, func() { l.Duration("timeout") }, func() { l.String("name") }} {
+				var recovered interface{}
+				func() {
+					defer func() { recovered = recover() }()
+					get()
+				}()
+				_, isFlagError := recovered.(*FlagError)
+				So(isFlagError, ShouldBeTrue)
+			}
+		})
+	})
+}
@@ -0,0 +1,95 @@
+package fileclient
+
+import (
+	"fmt"
+	"os"
+)
+
+// Batch is a list of operations that the server executes in order within a single request.
+type Batch struct {
+	StopOnError bool
+	Operations  []*BatchOperation
+}
+
+type BatchOperation struct {
+	Op         string
+	Path       string
+	NewPath    string `json:",omitempty"`
+	FileFilter string `json:",omitempty"`
+	Mode       string `json:",omitempty"`
+	Parents    bool   `json:",omitempty"`
+	Data       []byte `json:",omitempty"`
+}
+
+type BatchResult struct {
+	Op      string
+	Path    string
+	Success bool
+	Skipped bool
+	Error   string
+	Code    ErrorCode
+	Stats   *Stats
+}
+
+/*
+Creates a new empty Batch. If stopOnError is true the server skips all operations after the first failure.
+
+For example:
+
+	results, err := client.Batch(serverUrl, fileclient.NewBatch(true).
+		Stats("/builds/latest").
+		Delete("/builds/old").
+		Move("/builds/latest", "/builds/old"))
+*/
+func NewBatch(stopOnError bool) *Batch {
+	return &Batch{
+		StopOnError: stopOnError,
+		Operations:  []*BatchOperation{},
+	}
+}
+
+func (b *Batch) add(op *BatchOperation) *Batch {
+	b.Operations = append(b.Operations, op)
+	return b
+}
+
+func (b *Batch) Stats(remotePath string) *Batch {
+	return b.add(&BatchOperation{Op: "stats", Path: remotePath})
+}
+
+func (b *Batch) Delete(remotePath string) *Batch {
+	return b.add(&BatchOperation{Op: "delete", Path: remotePath})
+}
+
+func (b *Batch) DeleteDirFiltered(remotePath, dirFileFilterPattern string) *Batch {
+	return b.add(&BatchOperation{Op: "delete", Path: remotePath, FileFilter: dirFileFilterPattern})
+}
+
+func (b *Batch) Move(oldRemotePath, newRemotePath string) *Batch {
+	return b.add(&BatchOperation{Op: "move", Path: oldRemotePath, NewPath: newRemotePath})
+}
+
+func (b *Batch) Copy(remotePath, newRemotePath string) *Batch {
+	return b.add(&BatchOperation{Op: "copy", Path: remotePath, NewPath: newRemotePath})
+}
+
+func (b *Batch) CopyDirFiltered(remotePath, newRemotePath, dirFileFilterPattern string) *Batch {
+	return b.add(&BatchOperation{Op: "copy", Path: remotePath, NewPath: newRemotePath, FileFilter: dirFileFilterPattern})
+}
+
+func (b *Batch) Mkdir(remotePath string, parents bool, mode os.FileMode) *Batch {
+	return b.add(&BatchOperation{Op: "mkdir", Path: remotePath, Parents: parents, Mode: fmt.Sprintf("%o", mode.Perm())})
+}
+
+func (b *Batch) Chmod(remotePath string, mode os.FileMode) *Batch {
+	return b.add(&BatchOperation{Op: "chmod", Path: remotePath, Mode: fmt.Sprintf("%o", mode.Perm())})
+}
+
+func (b *Batch) Touch(remotePath string) *Batch {
+	return b.add(&BatchOperation{Op: "touch", Path: remotePath})
+}
+
+// Upload writes data to remotePath (creating parent directories), it is meant for small files only since the content is sent inline.
+func (b *Batch) Upload(remotePath string, data []byte, mode os.FileMode) *Batch {
+	return b.add(&BatchOperation{Op: "upload", Path: remotePath, Data: data, Mode: fmt.Sprintf("%o", mode.Perm())})
+}
@@ -1,14 +1,19 @@
 package fileclient
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"time"
 
 	"github.com/dustin/go-humanize"
 
+	"github.com/francoishill/golang-web-dry/logging/leveledlog"
 	"github.com/francoishill/golang-web-dry/zip/ziputils"
 )
 
@@ -17,20 +22,63 @@ type Client interface {
 	DownloadDirFiltered(serverUrl, localPath, remotePath, dirFileFilterPattern string) error
 	Upload(serverUrl, localPath, remotePath string) error
 	UploadDirFiltered(serverUrl, localPath, remotePath, dirFileFilterPattern string) error
+	UploadDedup(serverUrl, localPath, remotePath, dirFileFilterPattern string) (*DedupUploadResult, error)
+	UploadPaths(serverUrl string, localPaths []string, remoteDir, dirFileFilterPattern string) error
+	DownloadPaths(serverUrl string, remotePaths []string, localDir, dirFileFilterPattern string) error
+	ReadRange(serverUrl, remotePath string, offset, length int64, writer io.Writer) error
 	Delete(serverUrl, remotePath string) error
 	DeleteDirFiltered(serverUrl, remotePath, dirFileFilterPattern string) error
+	DeleteWithOptions(serverUrl, remotePath string, options *DeleteOptions) (*DeleteResult, error)
 	Move(serverUrl, oldRemotePath, newRemotePath string) error
+	Copy(serverUrl, remotePath, newRemotePath string) error
+	CopyDirFiltered(serverUrl, remotePath, newRemotePath, dirFileFilterPattern string) error
+	Mkdir(serverUrl, remotePath string, parents bool, mode os.FileMode) error
+	Chmod(serverUrl, remotePath string, mode os.FileMode) error
+	Touch(serverUrl, remotePath string) error
 	Stats(serverUrl, remotePath string) (*Stats, error)
+	List(serverUrl, remotePath string) ([]*FileEntry, error)
+	Glob(serverUrl, pattern string) ([]string, error)
+	Find(serverUrl, remoteDir string, options *FindOptions) (*FindResult, error)
+	Batch(serverUrl string, batch *Batch) ([]*BatchResult, error)
+	Watch(serverUrl, remotePath string, stop <-chan struct{}) (<-chan *WatchEvent, error)
+	Tail(serverUrl, remotePath string, offset int64, writer io.Writer, stop <-chan struct{}) error
+	DiskUsage(serverUrl string) ([]*RootDiskUsage, error)
+	ListTrash(serverUrl, rootName string) ([]*TrashItem, error)
+	RestoreTrash(serverUrl, rootName, id, newRemotePath string) error
 }
 
-func New(simpleLogger ziputils.SimpleLogger) Client {
-	return &client{
-		simpleLogger: simpleLogger,
+/*
+Creates a new Client, by default it uses http.DefaultClient without timeouts or retries. The logger can be a
+leveledlog.Logger or any logger with a Debug method (see leveledlog.FromDebugLogger).
+
+For example to retry idempotent requests and limit each request to 10 minutes:
+
+	client := fileclient.New(logger,
+		fileclient.WithRetryPolicy(fileclient.DefaultRetryPolicy()),
+		fileclient.WithRequestTimeout(10*time.Minute))
+*/
+func New(logger ziputils.SimpleLogger, options ...Option) Client {
+	c := &client{
+		logger:     leveledlog.FromDebugLogger(logger),
+		httpClient: http.DefaultClient,
+		headers:    http.Header{},
 	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
 }
 
 type client struct {
-	simpleLogger ziputils.SimpleLogger
+	logger         leveledlog.Logger
+	httpClient     *http.Client
+	requestTimeout time.Duration
+	retryPolicy    *RetryPolicy
+	headers        http.Header
+	userAgent      string
+
+	rateLimitBytesPerSecond int64
+	rateLimitBurstBytes     int64
 }
 
 func (c *client) Download(serverUrl, localPath, remotePath string) (returnErr error) {
@@ -40,6 +88,16 @@ func (c *client) DownloadDirFiltered(serverUrl, localPath, remotePath, dirFileFi
 	return c.download(serverUrl, localPath, remotePath, dirFileFilterPattern)
 }
 
+/*
+ReadRange writes length bytes of the remote file starting at offset to writer, as is instead of in a tar (length 0
+reads to the end). Only the range is sent, for example to read the last kilobyte of a log:
+
+	err := client.ReadRange(serverUrl, "/srv/logs/app.log", size-1024, 1024, os.Stdout)
+*/
+func (c *client) ReadRange(serverUrl, remotePath string, offset, length int64, writer io.Writer) error {
+	return c.readRange(serverUrl, remotePath, offset, length, writer)
+}
+
 func (c *client) Upload(serverUrl, localPath, remotePath string) error {
 	return c.upload(serverUrl, localPath, remotePath, "")
 }
@@ -47,32 +105,145 @@ func (c *client) UploadDirFiltered(serverUrl, localPath, remotePath, dirFileFilt
 	return c.upload(serverUrl, localPath, remotePath, dirFileFilterPattern)
 }
 
+/*
+UploadDedup uploads to a server root with dedup enabled. It first sends the hashes of the files, the server links
+the files it already has the content of, and then only the missing files are uploaded.
+*/
+func (c *client) UploadDedup(serverUrl, localPath, remotePath, dirFileFilterPattern string) (*DedupUploadResult, error) {
+	return c.uploadDedup(serverUrl, localPath, remotePath, dirFileFilterPattern)
+}
+
 func (c *client) Delete(serverUrl, remotePath string) error {
-	return c.delete(serverUrl, remotePath, "")
+	return c.DeleteDirFiltered(serverUrl, remotePath, "")
 }
 func (c *client) DeleteDirFiltered(serverUrl, remotePath, dirFileFilterPattern string) error {
-	return c.delete(serverUrl, remotePath, dirFileFilterPattern)
+	result, err := c.delete(serverUrl, remotePath, &DeleteOptions{FileFilter: dirFileFilterPattern})
+	if err != nil {
+		return err
+	}
+	return result.err()
+}
+
+// DeleteWithOptions returns the paths that were deleted, per-path failures are in the result and do not return an error
+func (c *client) DeleteWithOptions(serverUrl, remotePath string, options *DeleteOptions) (*DeleteResult, error) {
+	if options == nil {
+		options = &DeleteOptions{}
+	}
+	return c.delete(serverUrl, remotePath, options)
 }
 
 func (c *client) Move(serverUrl, oldRemotePath, newRemotePath string) error {
 	return c.move(serverUrl, oldRemotePath, newRemotePath)
 }
 
+func (c *client) Copy(serverUrl, remotePath, newRemotePath string) error {
+	return c.copy(serverUrl, remotePath, newRemotePath, "")
+}
+func (c *client) CopyDirFiltered(serverUrl, remotePath, newRemotePath, dirFileFilterPattern string) error {
+	return c.copy(serverUrl, remotePath, newRemotePath, dirFileFilterPattern)
+}
+
+func (c *client) Mkdir(serverUrl, remotePath string, parents bool, mode os.FileMode) error {
+	params := url.Values{}
+	params.Set("path", remotePath)
+	params.Set("mode", fmt.Sprintf("%o", mode.Perm()))
+	//Without parents a retry after a lost response would fail because the directory already exists
+	var flags requestFlags = 0
+	if parents {
+		params.Set("parents", "1")
+		flags = requestRetryable
+	}
+	return c.putAction(serverUrl, "mkdir", params, flags)
+}
+
+func (c *client) Chmod(serverUrl, remotePath string, mode os.FileMode) error {
+	params := url.Values{}
+	params.Set("path", remotePath)
+	params.Set("mode", fmt.Sprintf("%o", mode.Perm()))
+	return c.putAction(serverUrl, "chmod", params, requestRetryable)
+}
+
+func (c *client) Touch(serverUrl, remotePath string) error {
+	params := url.Values{}
+	params.Set("path", remotePath)
+	return c.putAction(serverUrl, "touch", params, requestRetryable)
+}
+
 func (c *client) Stats(serverUrl, remotePath string) (*Stats, error) {
 	return c.getStats(serverUrl, remotePath)
 }
 
+/*
+Find searches remoteDir on the server for the paths matching the options, without downloading anything. For example
+the zip files of the last day containing a version:
+
+	result, err := client.Find(serverUrl, "/srv/builds", &fileclient.FindOptions{
+		Names:     []string{"*.zip"},
+		NewerThan: time.Now().Add(-24 * time.Hour),
+		Contains:  "1.2.3",
+	})
+*/
+func (c *client) Find(serverUrl, remoteDir string, options *FindOptions) (*FindResult, error) {
+	if options == nil {
+		options = &FindOptions{}
+	}
+	return c.find(serverUrl, remoteDir, options)
+}
+
+func (c *client) Batch(serverUrl string, batch *Batch) ([]*BatchResult, error) {
+	return c.runBatch(serverUrl, batch)
+}
+
+// Watch streams change events under remotePath until stop is closed or the connection ends, after which the channel is closed.
+func (c *client) Watch(serverUrl, remotePath string, stop <-chan struct{}) (<-chan *WatchEvent, error) {
+	return c.watch(serverUrl, remotePath, stop)
+}
+
+// Tail writes the content of remotePath to writer as it grows, starting at offset (negative is relative to the end), until stop is closed.
+func (c *client) Tail(serverUrl, remotePath string, offset int64, writer io.Writer, stop <-chan struct{}) error {
+	return c.tail(serverUrl, remotePath, offset, writer, stop)
+}
+
+// DiskUsage returns the used and available space of every root the server is configured with
+func (c *client) DiskUsage(serverUrl string) ([]*RootDiskUsage, error) {
+	return c.getDiskUsage(serverUrl)
+}
+
+// ListTrash returns the deleted items in the trash of the named root, oldest first
+func (c *client) ListTrash(serverUrl, rootName string) ([]*TrashItem, error) {
+	return c.listTrash(serverUrl, rootName)
+}
+
+// RestoreTrash moves a trash item back to its original path, or to newRemotePath (in any root on the local disk of the server) if it is not empty
+func (c *client) RestoreTrash(serverUrl, rootName, id, newRemotePath string) error {
+	params := url.Values{}
+	params.Set("root", rootName)
+	params.Set("id", id)
+	if newRemotePath != "" {
+		params.Set("newpath", newRemotePath)
+	}
+	return c.putAction(serverUrl, "restore", params, 0)
+}
+
 func (c *client) checkServerResponse(resp *http.Response) error {
 	if resp.StatusCode != http.StatusOK {
 		if b, e := ioutil.ReadAll(resp.Body); e != nil {
 			return fmt.Errorf("The server returned status code %d but could not read response body. Error: %s", resp.StatusCode, e.Error())
 		} else {
-			return fmt.Errorf("Server status code %d with response %s", resp.StatusCode, string(b))
+			return newServerError(resp.StatusCode, b)
 		}
 	}
 	return nil
 }
 
+// rateLimitTransfer wraps body with a new RateLimiter (if configured), each transfer is limited separately
+func (c *client) rateLimitTransfer(body io.ReadCloser) io.ReadCloser {
+	if c.rateLimitBytesPerSecond <= 0 || body == nil {
+		return body
+	}
+	return ziputils.NewRateLimitedReadCloser(body, ziputils.NewRateLimiter(c.rateLimitBytesPerSecond, c.rateLimitBurstBytes))
+}
+
 func (c *client) isDir(path string) (bool, error) {
 	p, err := os.Open(path)
 	if err != nil {
@@ -102,7 +273,12 @@ func (c *client) download(serverUrl, localPath, remotePath, dirFileFilterPattern
 		fileFilterQueryPart = "&filefilter=" + url.QueryEscape(dirFileFilterPattern)
 	}
 
-	resp, err := http.Get(serverUrl + "?path=" + url.QueryEscape(remotePath) + fileFilterQueryPart)
+	req, err := http.NewRequest("GET", serverUrl+"?path="+url.QueryEscape(remotePath)+fileFilterQueryPart, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req, requestRetryable)
 	if err != nil {
 		return err
 	}
@@ -112,7 +288,7 @@ func (c *client) download(serverUrl, localPath, remotePath, dirFileFilterPattern
 		return err
 	}
 
-	ziputils.SaveTarReaderToPath(c.simpleLogger, resp.Body, localPath)
+	ziputils.SaveTarReaderToPath(c.logger, c.rateLimitTransfer(resp.Body), localPath)
 	return nil
 }
 
@@ -130,19 +306,19 @@ func (c *client) uploadFile(serverUrl, localPath, remotePath string) (returnErr
 		return fmt.Errorf("Unable to get size of file '%s', error: %s", localPath, err.Error())
 	}
 
-	c.simpleLogger.Debug("Now starting to upload local file '%s' of size %s to remote path '%s'", localPath, humanize.IBytes(uint64(fileSize)), remotePath)
+	c.logger.With(leveledlog.F("path", remotePath), leveledlog.F("bytes", fileSize)).Debug("Now starting to upload local file '%s' of size %s", localPath, humanize.IBytes(uint64(fileSize)))
 	url := serverUrl + "?path=" + url.QueryEscape(remotePath)
-	ziputils.UploadFileToUrl(c.simpleLogger, url, "application/octet-stream", localPath, c.checkServerResponse)
+	ziputils.UploadFileToUrlWithDoer(c.logger, &requestDoer{c}, url, "application/octet-stream", localPath, c.checkServerResponse)
 	return nil
 }
 
 func (c *client) uploadDirectory(serverUrl, localPath, remotePath, dirFileFilterPattern string) (returnErr error) {
 	defer CatchPanicAsError(&returnErr)
 
-	c.simpleLogger.Debug("Now starting to upload local directory '%s' to remote '%s", localPath, remotePath)
+	c.logger.With(leveledlog.F("path", remotePath)).Debug("Now starting to upload local directory '%s'", localPath)
 	checkResponseFunc := c.checkServerResponse
 	walkContext := ziputils.NewDirWalkContext(dirFileFilterPattern)
-	ziputils.UploadDirectoryToUrl(c.simpleLogger, serverUrl+"?dir="+url.QueryEscape(remotePath), "application/octet-stream", localPath, walkContext, checkResponseFunc)
+	ziputils.UploadDirectoryToUrlWithDoer(c.logger, &requestDoer{c}, serverUrl+"?dir="+url.QueryEscape(remotePath), "application/octet-stream", localPath, walkContext, checkResponseFunc)
 	return nil
 }
 
@@ -156,49 +332,131 @@ func (c *client) upload(serverUrl, localPath, remotePath, dirFileFilterPattern s
 	}
 }
 
-func (c *client) delete(serverUrl, remotePath, dirFileFilterPattern string) (returnErr error) {
+func (c *client) delete(serverUrl, remotePath string, options *DeleteOptions) (result *DeleteResult, returnErr error) {
 	defer CatchPanicAsError(&returnErr)
 
-	var fileFilterQueryPart = ""
-	if dirFileFilterPattern != "" {
-		fileFilterQueryPart = "&filefilter=" + url.QueryEscape(dirFileFilterPattern)
+	params := url.Values{}
+	params.Set("path", remotePath)
+	if options.FileFilter != "" {
+		params.Set("filefilter", options.FileFilter)
+	}
+	if options.DryRun {
+		//An older server ignores dryrun=1 and would really delete the files, so only send it to servers supporting it
+		if err := c.checkDeleteDryRunSupported(serverUrl, remotePath); err != nil {
+			return nil, err
+		}
+		params.Set("dryrun", "1")
+	}
+	if options.RemoveEmptyDirs {
+		params.Set("removeemptydirs", "1")
+	}
+
+	req, err := http.NewRequest("DELETE", serverUrl+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	//Only a dry run is retried, a retry after a lost response of a real delete would fail with not found
+	var flags requestFlags = 0
+	if options.DryRun {
+		flags = requestRetryable
+	}
+	resp, err := c.do(req, flags)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err = c.checkServerResponse(resp); err != nil {
+		return nil, err
 	}
 
-	req, err := http.NewRequest("DELETE", serverUrl+"?path="+url.QueryEscape(remotePath)+fileFilterQueryPart, nil)
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.DryRun && resp.Header.Get("DELETE_DRY_RUN") != "1" {
+		return nil, fmt.Errorf("The server did not confirm the dry run of deleting '%s', it may have deleted it", remotePath)
+	}
+
+	result = &DeleteResult{}
+	if len(body) == 0 {
+		//Older servers do not return the deleted paths
+		return result, nil
+	}
+	if err = json.Unmarshal(body, result); err != nil {
+		return nil, fmt.Errorf("Unable to decode delete result, error: %s", err.Error())
+	}
+	return result, nil
+}
+
+// checkDeleteDryRunSupported returns an error if the server does not send the DELETE_DRY_RUN header along with the stats of remotePath
+func (c *client) checkDeleteDryRunSupported(serverUrl, remotePath string) error {
+	req, err := http.NewRequest("HEAD", serverUrl+"?path="+url.QueryEscape(remotePath), nil)
 	if err != nil {
 		return err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.do(req, requestRetryable)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 
-	return c.checkServerResponse(resp)
+	if err = c.checkServerResponse(resp); err != nil {
+		return err
+	}
+	if resp.Header.Get("DELETE_DRY_RUN") != "1" {
+		return fmt.Errorf("The server does not support dry runs of deletes, '%s' would really be deleted", remotePath)
+	}
+	return nil
 }
 
-func (c *client) move(serverUrl, oldRemotePath, newRemotePath string) (returnErr error) {
+func (c *client) putAction(serverUrl, action string, params url.Values, flags requestFlags) (returnErr error) {
 	defer CatchPanicAsError(&returnErr)
 
-	escapedOldPath := url.QueryEscape(oldRemotePath)
-	escapedNewPath := url.QueryEscape(newRemotePath)
-	req, err := http.NewRequest("PUT", serverUrl+"?action=move&path="+escapedOldPath+"&newpath="+escapedNewPath, nil)
+	params.Set("action", action)
+	req, err := http.NewRequest("PUT", serverUrl+"?"+params.Encode(), nil)
 	if err != nil {
 		return err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.do(req, flags)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 
 	return c.checkServerResponse(resp)
 }
 
+func (c *client) move(serverUrl, oldRemotePath, newRemotePath string) error {
+	params := url.Values{}
+	params.Set("path", oldRemotePath)
+	params.Set("newpath", newRemotePath)
+	return c.putAction(serverUrl, "move", params, 0)
+}
+
+func (c *client) copy(serverUrl, remotePath, newRemotePath, dirFileFilterPattern string) error {
+	params := url.Values{}
+	params.Set("path", remotePath)
+	params.Set("newpath", newRemotePath)
+	if dirFileFilterPattern != "" {
+		params.Set("filefilter", dirFileFilterPattern)
+	}
+	return c.putAction(serverUrl, "copy", params, 0)
+}
+
 func (c *client) getStats(serverUrl, remotePath string) (stats *Stats, returnErr error) {
 	defer CatchPanicAsError(&returnErr)
 
-	resp, err := http.Head(serverUrl + "?path=" + url.QueryEscape(remotePath))
+	req, err := http.NewRequest("HEAD", serverUrl+"?path="+url.QueryEscape(remotePath), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req, requestRetryable)
 	if err != nil {
 		return nil, err
 	}
@@ -224,3 +482,87 @@ func (c *client) getStats(serverUrl, remotePath string) (stats *Stats, returnErr
 		}, nil
 	}
 }
+
+func (c *client) runBatch(serverUrl string, batch *Batch) (results []*BatchResult, returnErr error) {
+	defer CatchPanicAsError(&returnErr)
+
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", serverUrl+"?action=batch", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.do(req, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err = c.checkServerResponse(resp); err != nil {
+		return nil, err
+	}
+
+	results = []*BatchResult{}
+	if err = json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, fmt.Errorf("Unable to decode batch results, error: %s", err.Error())
+	}
+	return results, nil
+}
+
+func (c *client) getDiskUsage(serverUrl string) (usages []*RootDiskUsage, returnErr error) {
+	defer CatchPanicAsError(&returnErr)
+
+	req, err := http.NewRequest("GET", serverUrl+"?action=diskusage", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req, requestRetryable)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err = c.checkServerResponse(resp); err != nil {
+		return nil, err
+	}
+
+	usages = []*RootDiskUsage{}
+	if err = json.NewDecoder(resp.Body).Decode(&usages); err != nil {
+		return nil, fmt.Errorf("Unable to decode disk usage, error: %s", err.Error())
+	}
+	return usages, nil
+}
+
+func (c *client) listTrash(serverUrl, rootName string) (items []*TrashItem, returnErr error) {
+	defer CatchPanicAsError(&returnErr)
+
+	params := url.Values{}
+	params.Set("action", "trash")
+	params.Set("root", rootName)
+	req, err := http.NewRequest("GET", serverUrl+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req, requestRetryable)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err = c.checkServerResponse(resp); err != nil {
+		return nil, err
+	}
+
+	items = []*TrashItem{}
+	if err = json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		return nil, fmt.Errorf("Unable to decode trash items, error: %s", err.Error())
+	}
+	return items, nil
+}
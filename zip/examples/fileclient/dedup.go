@@ -0,0 +1,187 @@
+package fileclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/francoishill/golang-web-dry/zip/ziputils"
+)
+
+type dedupManifestFile struct {
+	Path string
+	Hash string
+	Mode os.FileMode
+	Size int64
+}
+
+type dedupManifest struct {
+	Files []*dedupManifestFile
+}
+
+type dedupResponse struct {
+	Linked  []string
+	Missing []string
+}
+
+// DedupUploadResult says how many files of an UploadDedup were already on the server and how many had to be sent
+type DedupUploadResult struct {
+	LinkedFiles   int
+	LinkedBytes   int64
+	UploadedFiles int
+	UploadedBytes int64
+}
+
+func hashLocalFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err = io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// getDedupManifest hashes localPath (a file) or the files inside localPath (a directory) matching dirFileFilterPattern
+func getDedupManifest(localPath string, isDir bool, dirFileFilterPattern string) (*dedupManifest, error) {
+	manifest := &dedupManifest{Files: []*dedupManifestFile{}}
+
+	err := filepath.Walk(localPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		relPath := ""
+		if isDir {
+			if dirFileFilterPattern != "" {
+				if matched, err := filepath.Match(dirFileFilterPattern, info.Name()); err != nil || !matched {
+					return err
+				}
+			}
+			relPath = filepath.ToSlash(path[len(localPath)+1:])
+		}
+
+		hash, err := hashLocalFile(path)
+		if err != nil {
+			return fmt.Errorf("Unable to hash local file '%s', error: %s", path, err.Error())
+		}
+
+		manifest.Files = append(manifest.Files, &dedupManifestFile{
+			Path: relPath,
+			Hash: hash,
+			Mode: info.Mode().Perm(),
+			Size: info.Size(),
+		})
+		return nil
+	})
+	return manifest, err
+}
+
+func (c *client) postDedupManifest(serverUrl, pathParamName, remotePath string, manifest *dedupManifest) (*dedupResponse, error) {
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Set("action", "dedup")
+	params.Set(pathParamName, remotePath)
+	req, err := http.NewRequest("POST", serverUrl+"?"+params.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	//Linking the same hashes again has the same result, so it is safe to retry
+	resp, err := c.do(req, requestRetryable)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err = c.checkServerResponse(resp); err != nil {
+		return nil, err
+	}
+
+	response := &dedupResponse{}
+	if err = json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return nil, fmt.Errorf("Unable to decode dedup response, error: %s", err.Error())
+	}
+	return response, nil
+}
+
+func (c *client) uploadDedup(serverUrl, localPath, remotePath, dirFileFilterPattern string) (result *DedupUploadResult, returnErr error) {
+	defer CatchPanicAsError(&returnErr)
+
+	isDir, err := c.isDir(localPath)
+	if err != nil {
+		return nil, err
+	}
+	localPath = filepath.Clean(localPath)
+
+	manifest, err := getDedupManifest(localPath, isDir, dirFileFilterPattern)
+	if err != nil {
+		return nil, err
+	}
+
+	pathParamName := "path"
+	if isDir {
+		pathParamName = "dir"
+	}
+
+	c.logger.Debug("Sending the hashes of %d files to check which of them the server already has", len(manifest.Files))
+	response, err := c.postDedupManifest(serverUrl, pathParamName, remotePath, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := map[string]bool{}
+	for _, relPath := range response.Missing {
+		missing[relPath] = true
+	}
+
+	result = &DedupUploadResult{}
+	for _, file := range manifest.Files {
+		if missing[file.Path] {
+			result.UploadedFiles++
+			result.UploadedBytes += file.Size
+		} else {
+			result.LinkedFiles++
+			result.LinkedBytes += file.Size
+		}
+	}
+
+	if len(missing) == 0 {
+		c.logger.Debug("The server already has all %d files", result.LinkedFiles)
+		return result, nil
+	}
+
+	c.logger.Debug("Uploading %d missing files, the server already has %d files", result.UploadedFiles, result.LinkedFiles)
+	if !isDir {
+		if err = c.uploadFile(serverUrl, localPath, remotePath); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	walkContext := ziputils.NewDirWalkContext(dirFileFilterPattern)
+	walkContext.IncludeFile = func(path string, info os.FileInfo) bool {
+		return missing[filepath.ToSlash(path[len(localPath)+1:])]
+	}
+	ziputils.UploadDirectoryToUrlWithDoer(c.logger, &requestDoer{c}, serverUrl+"?dir="+url.QueryEscape(remotePath), "application/octet-stream", localPath, walkContext, c.checkServerResponse)
+	return result, nil
+}
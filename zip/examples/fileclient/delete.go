@@ -0,0 +1,34 @@
+package fileclient
+
+import (
+	"fmt"
+)
+
+type DeleteOptions struct {
+	//FileFilter only deletes the files matching this filepath.Match pattern (on their base name) inside a directory
+	FileFilter string
+	//DryRun only lists the paths that would be deleted
+	DryRun bool
+	//RemoveEmptyDirs also removes the subdirectories left empty after a filtered delete
+	RemoveEmptyDirs bool
+}
+
+type DeleteError struct {
+	Path  string
+	Error string
+}
+
+// DeleteResult lists the deleted (or with DryRun the would-be deleted) paths, and the paths the server failed to delete
+type DeleteResult struct {
+	DryRun       bool
+	DeletedPaths []string
+	Errors       []*DeleteError
+}
+
+// err returns an error describing the failed paths, or nil if all paths could be deleted
+func (d *DeleteResult) err() error {
+	if len(d.Errors) == 0 {
+		return nil
+	}
+	return fmt.Errorf("Unable to delete %d paths, the first error: %s", len(d.Errors), d.Errors[0].Error)
+}
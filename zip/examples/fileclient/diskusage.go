@@ -0,0 +1,11 @@
+package fileclient
+
+type RootDiskUsage struct {
+	Name           string
+	Path           string
+	UsedBytes      int64
+	QuotaBytes     int64
+	AvailableBytes int64
+	DiskFreeBytes  int64
+	DiskTotalBytes int64
+}
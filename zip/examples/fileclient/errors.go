@@ -0,0 +1,113 @@
+package fileclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// ErrorCode is the stable machine-readable code the fileserver returns along with its error responses
+type ErrorCode string
+
+const (
+	ErrorCodeBadRequest          ErrorCode = "BAD_REQUEST"
+	ErrorCodeUnauthorized        ErrorCode = "UNAUTHORIZED"
+	ErrorCodePermissionDenied    ErrorCode = "PERMISSION_DENIED"
+	ErrorCodeNotFound            ErrorCode = "NOT_FOUND"
+	ErrorCodeAlreadyExists       ErrorCode = "ALREADY_EXISTS"
+	ErrorCodeNotEmpty            ErrorCode = "NOT_EMPTY"
+	ErrorCodeConflict            ErrorCode = "CONFLICT"
+	ErrorCodeInvalidArchive      ErrorCode = "INVALID_ARCHIVE"
+	ErrorCodeTooLarge            ErrorCode = "TOO_LARGE"
+	ErrorCodeQuotaExceeded       ErrorCode = "QUOTA_EXCEEDED"
+	ErrorCodeInsufficientStorage ErrorCode = "INSUFFICIENT_STORAGE"
+	ErrorCodeLocked              ErrorCode = "LOCKED"
+	ErrorCodeInternal            ErrorCode = "INTERNAL"
+)
+
+/*
+ServerError is returned by the Client whenever the server responds with a non-200 status code.
+
+Callers can switch on the Code, for example:
+
+	err := client.Delete(serverUrl, remotePath)
+	switch fileclient.ErrorCodeOf(err) {
+	case fileclient.ErrorCodeNotFound:
+		//Already deleted
+	case "":
+		//Not a server error (or nil)
+	}
+*/
+type ServerError struct {
+	StatusCode int
+	Code       ErrorCode
+	Message    string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("Server status code %d (%s) with response %s", e.StatusCode, e.Code, e.Message)
+}
+
+// ErrorCodeOf returns the Code of err if it is a *ServerError, otherwise an empty ErrorCode
+func ErrorCodeOf(err error) ErrorCode {
+	if e, ok := err.(*ServerError); ok {
+		return e.Code
+	}
+	return ""
+}
+
+func IsNotFound(err error) bool {
+	return ErrorCodeOf(err) == ErrorCodeNotFound
+}
+
+// IsUnauthorized is true when the server requires a (different) token, see WithBearerToken
+func IsUnauthorized(err error) bool {
+	return ErrorCodeOf(err) == ErrorCodeUnauthorized
+}
+
+func IsPermissionDenied(err error) bool {
+	return ErrorCodeOf(err) == ErrorCodePermissionDenied
+}
+
+func IsAlreadyExists(err error) bool {
+	return ErrorCodeOf(err) == ErrorCodeAlreadyExists
+}
+
+// IsLocked is true when the server refused the request because another operation is busy with the same path
+func IsLocked(err error) bool {
+	return ErrorCodeOf(err) == ErrorCodeLocked
+}
+
+func errorCodeFromStatusCode(statusCode int) ErrorCode {
+	switch statusCode {
+	case http.StatusBadRequest, http.StatusMethodNotAllowed:
+		return ErrorCodeBadRequest
+	case http.StatusUnauthorized:
+		return ErrorCodeUnauthorized
+	case http.StatusForbidden:
+		return ErrorCodePermissionDenied
+	case http.StatusNotFound:
+		return ErrorCodeNotFound
+	case http.StatusConflict:
+		return ErrorCodeConflict
+	case http.StatusRequestEntityTooLarge:
+		return ErrorCodeTooLarge
+	case http.StatusInsufficientStorage:
+		return ErrorCodeInsufficientStorage
+	case http.StatusLocked:
+		return ErrorCodeLocked
+	default:
+		return ErrorCodeInternal
+	}
+}
+
+func newServerError(statusCode int, body []byte) *ServerError {
+	serverErr := &ServerError{}
+	if err := json.Unmarshal(body, serverErr); err != nil || serverErr.Code == "" {
+		//Older servers (and HEAD requests) do not send a JSON body
+		serverErr.Code = errorCodeFromStatusCode(statusCode)
+		serverErr.Message = string(body)
+	}
+	serverErr.StatusCode = statusCode
+	return serverErr
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/dustin/go-humanize"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+	"github.com/francoishill/golang-web-dry/zip/examples/fileclient"
+)
+
+/*
+The commands take their paths as arguments, the server and other settings come from the flags before the command
+(or the environment variables and config file), for example:
+
+	fileclient-cli --serverurl http://files:60878 upload --filefilter "*.zip" ./builds /srv/builds
+	fileclient-cli --profile prod --json stat /srv/builds/1.2
+
+With multiple sources (or wildcards) upload and download copy all of them into the destination directory in a single
+request, like 'scp a b c host:dir/'. Wildcards are expanded locally for upload and on the server for download and rm:
+
+	fileclient-cli upload ./dist/*.zip ./CHANGELOG.md /srv/builds/1.2
+	fileclient-cli download "/srv/logs/2024-*.log" ./logs
+	fileclient-cli rm "/srv/builds/1.*"
+*/
+func (a *appContext) commands() []cli.Command {
+	fileFilterFlag := cli.StringFlag{
+		Name:  "filefilter,ff",
+		Value: "",
+		Usage: "The golang filepath filter pattern (for file base name), see http://golang.org/pkg/path/filepath/#Match",
+	}
+
+	return []cli.Command{
+		{
+			Name:   "upload",
+			Usage:  "upload <localpath>... <remotepath>, uploads a local file or directory (or multiple into the remote directory)",
+			Action: a.uploadAction,
+			Flags: []cli.Flag{
+				fileFilterFlag,
+				cli.BoolFlag{
+					Name:  "dedup",
+					Usage: "Send the file hashes first and only upload the files the server does not have yet (the remote root must have dedup enabled)",
+				},
+			},
+		},
+		{
+			Name:   "download",
+			Usage:  "download <remotepath>... <localpath>, downloads a remote file or directory (or multiple into the local directory)",
+			Action: a.downloadAction,
+			Flags:  []cli.Flag{fileFilterFlag},
+		},
+		{
+			Name:   "rm",
+			Usage:  "rm <remotepath>..., deletes remote files or directories (or only the files matching --filefilter inside them)",
+			Action: a.rmAction,
+			Flags: []cli.Flag{
+				fileFilterFlag,
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Only list the paths that would be deleted",
+				},
+				cli.BoolFlag{
+					Name:  "remove-empty-dirs",
+					Usage: "Also remove the directories left empty after deleting the files matching the filefilter",
+				},
+			},
+		},
+		{
+			Name:   "mv",
+			Usage:  "mv <remotepath> <newremotepath>, moves or renames a remote file or directory",
+			Action: a.mvAction,
+		},
+		{
+			Name:   "stat",
+			Usage:  "stat <remotepath>, exits with code 3 if the remote path does not exist",
+			Action: a.statAction,
+		},
+		{
+			Name:   "ls",
+			Usage:  "ls <remotepath>, lists the files and directories of a remote directory",
+			Action: a.lsAction,
+		},
+		{
+			Name:   "find",
+			Usage:  "find <remotedir>, searches the remote directory on the server for the files and directories matching the flags",
+			Action: a.findAction,
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "name",
+					Usage: "The golang filepath pattern of the base name, can be repeated to match any of them",
+				},
+				cli.StringFlag{
+					Name:  "type",
+					Usage: "Only find files (file) or directories (dir)",
+				},
+				cli.StringFlag{
+					Name:  "min-size",
+					Usage: "Only find files of at least this size, like 10MiB",
+				},
+				cli.StringFlag{
+					Name:  "max-size",
+					Usage: "Only find files of at most this size, like 1GiB",
+				},
+				cli.StringFlag{
+					Name:  "newer-than",
+					Usage: "Only find paths modified after this RFC3339 time, or within this duration like 24h",
+				},
+				cli.StringFlag{
+					Name:  "older-than",
+					Usage: "Only find paths modified before this RFC3339 time, or longer ago than this duration like 720h",
+				},
+				cli.StringFlag{
+					Name:  "contains",
+					Usage: "Only find files containing this text, the line of its first occurrence is shown",
+				},
+				cli.IntFlag{
+					Name:  "max-results",
+					Usage: "Stop after this many matches (the server default is 1000)",
+				},
+				cli.DurationFlag{
+					Name:  "search-timeout",
+					Usage: "Stop searching after this duration (the server default is 30s)",
+				},
+			},
+		},
+		{
+			Name:   "shell",
+			Usage:  "shell [remotedir], starts an interactive shell (cd, lcd, ls, get, put, rm, mv, stat) starting in the remote directory",
+			Action: a.shellAction,
+		},
+	}
+}
+
+// requireArgs returns the arguments of the command, it panics with a usage error unless there is one for each name
+func requireArgs(c *cli.Context, names ...string) []string {
+	args := c.Args()
+	if len(args) != len(names) {
+		panic(&usageError{fmt.Sprintf("Command '%s' expects the arguments <%s>, got %d arguments", c.Command.Name, strings.Join(names, "> <"), len(args))})
+	}
+	return args
+}
+
+// requireSources returns the arguments of a command taking one or more sources, like 'rm <remotepath>...'
+func requireSources(c *cli.Context, sourceName string) []string {
+	args := c.Args()
+	if len(args) == 0 {
+		panic(&usageError{fmt.Sprintf("Command '%s' expects the arguments <%s>..., got no arguments", c.Command.Name, sourceName)})
+	}
+	return args
+}
+
+// requireSourcesAndDestination returns the arguments of a command taking one or more sources and a destination, like 'upload <localpath>... <remotepath>'
+func requireSourcesAndDestination(c *cli.Context, sourceName, destinationName string) (sources []string, destination string) {
+	args := c.Args()
+	if len(args) < 2 {
+		panic(&usageError{fmt.Sprintf("Command '%s' expects the arguments <%s>... <%s>, got %d arguments", c.Command.Name, sourceName, destinationName, len(args))})
+	}
+	return args[:len(args)-1], args[len(args)-1]
+}
+
+type uploadResult struct {
+	LocalPaths []string
+	RemotePath string
+	Dedup      *fileclient.DedupUploadResult `json:",omitempty"`
+}
+
+func (r *uploadResult) printHuman(w io.Writer) {
+	fmt.Fprintf(w, "Uploaded %s to %s\n", strings.Join(r.LocalPaths, ", "), r.RemotePath)
+	if r.Dedup != nil {
+		fmt.Fprintf(w, "Linked %d existing files (%s), uploaded %d files (%s)\n",
+			r.Dedup.LinkedFiles, humanize.IBytes(uint64(r.Dedup.LinkedBytes)),
+			r.Dedup.UploadedFiles, humanize.IBytes(uint64(r.Dedup.UploadedBytes)))
+	}
+}
+
+func (a *appContext) uploadAction(c *cli.Context) {
+	c2 := a.start(c)
+	sources, remotePath := requireSourcesAndDestination(c, "localpath", "remotepath")
+	serverUrl := c2.RequireGlobalString("serverurl")
+	dirFileFilterPattern := c2.settings.String("filefilter")
+
+	client := fileclient.New(a.logger, c2.ClientOptions()...)
+	defer (&timer{a.logger, time.Now()}).printDuration()
+
+	result := &uploadResult{LocalPaths: expandLocalGlobs(sources), RemotePath: remotePath}
+	if isMultipleSources(sources) {
+		if c2.settings.Bool("dedup") {
+			panic(&usageError{"Flag 'dedup' only supports uploading a single local path"})
+		}
+		err := client.UploadPaths(serverUrl, result.LocalPaths, remotePath, dirFileFilterPattern)
+		CheckError(err)
+	} else if c2.settings.Bool("dedup") {
+		dedupResult, err := client.UploadDedup(serverUrl, result.LocalPaths[0], remotePath, dirFileFilterPattern)
+		CheckError(err)
+		result.Dedup = dedupResult
+	} else {
+		err := client.UploadDirFiltered(serverUrl, result.LocalPaths[0], remotePath, dirFileFilterPattern)
+		CheckError(err)
+	}
+	a.printResult(result)
+}
+
+type downloadResult struct {
+	RemotePaths []string
+	LocalPath   string
+}
+
+func (r *downloadResult) printHuman(w io.Writer) {
+	fmt.Fprintf(w, "Downloaded %s to %s\n", strings.Join(r.RemotePaths, ", "), r.LocalPath)
+}
+
+func (a *appContext) downloadAction(c *cli.Context) {
+	c2 := a.start(c)
+	sources, localPath := requireSourcesAndDestination(c, "remotepath", "localpath")
+	serverUrl := c2.RequireGlobalString("serverurl")
+	dirFileFilterPattern := c2.settings.String("filefilter")
+
+	client := fileclient.New(a.logger, c2.ClientOptions()...)
+	defer (&timer{a.logger, time.Now()}).printDuration()
+
+	result := &downloadResult{RemotePaths: expandRemoteGlobs(client, serverUrl, sources), LocalPath: localPath}
+	if isMultipleSources(sources) {
+		err := client.DownloadPaths(serverUrl, result.RemotePaths, localPath, dirFileFilterPattern)
+		CheckError(err)
+	} else {
+		err := client.DownloadDirFiltered(serverUrl, localPath, result.RemotePaths[0], dirFileFilterPattern)
+		CheckError(err)
+	}
+	a.printResult(result)
+}
+
+type rmResult struct {
+	RemotePaths  []string
+	DryRun       bool
+	DeletedPaths []string
+	Errors       []*fileclient.DeleteError
+}
+
+func (r *rmResult) printHuman(w io.Writer) {
+	for _, deletedPath := range r.DeletedPaths {
+		if r.DryRun {
+			fmt.Fprintf(w, "Would delete %s\n", deletedPath)
+		} else {
+			fmt.Fprintf(w, "Deleted %s\n", deletedPath)
+		}
+	}
+	for _, deleteErr := range r.Errors {
+		fmt.Fprintf(w, "Failed to delete %s: %s\n", deleteErr.Path, deleteErr.Error)
+	}
+}
+
+func (a *appContext) rmAction(c *cli.Context) {
+	c2 := a.start(c)
+	sources := requireSources(c, "remotepath")
+	serverUrl := c2.RequireGlobalString("serverurl")
+
+	client := fileclient.New(a.logger, c2.ClientOptions()...)
+	defer (&timer{a.logger, time.Now()}).printDuration()
+
+	options := &fileclient.DeleteOptions{
+		FileFilter:      c2.settings.String("filefilter"),
+		DryRun:          c2.settings.Bool("dry-run"),
+		RemoveEmptyDirs: c2.settings.Bool("remove-empty-dirs"),
+	}
+
+	result := &rmResult{RemotePaths: expandRemoteGlobs(client, serverUrl, sources), DryRun: options.DryRun}
+	for _, remotePath := range result.RemotePaths {
+		deleteResult, err := client.DeleteWithOptions(serverUrl, remotePath, options)
+		CheckError(err)
+
+		result.DeletedPaths = append(result.DeletedPaths, deleteResult.DeletedPaths...)
+		result.Errors = append(result.Errors, deleteResult.Errors...)
+	}
+
+	a.printResult(result)
+	if len(result.Errors) > 0 {
+		panic(fmt.Sprintf("%d paths could not be deleted", len(result.Errors)))
+	}
+}
+
+type mvResult struct {
+	RemotePath    string
+	NewRemotePath string
+}
+
+func (r *mvResult) printHuman(w io.Writer) {
+	fmt.Fprintf(w, "Moved %s to %s\n", r.RemotePath, r.NewRemotePath)
+}
+
+func (a *appContext) mvAction(c *cli.Context) {
+	c2 := a.start(c)
+	args := requireArgs(c, "remotepath", "newremotepath")
+	serverUrl := c2.RequireGlobalString("serverurl")
+
+	client := fileclient.New(a.logger, c2.ClientOptions()...)
+	err := client.Move(serverUrl, args[0], args[1])
+	CheckError(err)
+	a.printResult(&mvResult{RemotePath: args[0], NewRemotePath: args[1]})
+}
+
+type statResult struct {
+	RemotePath string
+	IsDir      bool
+}
+
+func (r *statResult) printHuman(w io.Writer) {
+	if r.IsDir {
+		fmt.Fprintf(w, "%s is a directory\n", r.RemotePath)
+	} else {
+		fmt.Fprintf(w, "%s is a file\n", r.RemotePath)
+	}
+}
+
+func (a *appContext) statAction(c *cli.Context) {
+	c2 := a.start(c)
+	args := requireArgs(c, "remotepath")
+	serverUrl := c2.RequireGlobalString("serverurl")
+
+	client := fileclient.New(a.logger, c2.ClientOptions()...)
+	stats, err := client.Stats(serverUrl, args[0])
+	CheckError(err)
+
+	if !stats.Exists {
+		panic(&notFoundError{"Remote path '" + args[0] + "' does not exist"})
+	}
+	a.printResult(&statResult{RemotePath: args[0], IsDir: stats.IsDir})
+}
+
+type lsResult struct {
+	RemotePath string
+	Entries    []*fileclient.FileEntry
+}
+
+func (r *lsResult) printHuman(w io.Writer) {
+	for _, entry := range r.Entries {
+		typeChar, size, name := "-", humanize.IBytes(uint64(entry.Size)), entry.Name
+		if entry.IsDir {
+			typeChar, size, name = "d", "-", entry.Name+"/"
+		}
+		fmt.Fprintf(w, "%s%s %10s %s %s\n", typeChar, entry.Mode.Perm().String()[1:], size, entry.ModTime.Format("2006-01-02 15:04"), name)
+	}
+}
+
+func (a *appContext) lsAction(c *cli.Context) {
+	c2 := a.start(c)
+	args := requireArgs(c, "remotepath")
+	serverUrl := c2.RequireGlobalString("serverurl")
+
+	client := fileclient.New(a.logger, c2.ClientOptions()...)
+	entries, err := client.List(serverUrl, args[0])
+	CheckError(err)
+	a.printResult(&lsResult{RemotePath: args[0], Entries: entries})
+}
+
+type findResult struct {
+	RemoteDir string
+	*fileclient.FindResult
+}
+
+func (r *findResult) printHuman(w io.Writer) {
+	for _, match := range r.Matches {
+		typeChar, size, location := "-", humanize.IBytes(uint64(match.Size)), match.Path
+		if match.IsDir {
+			typeChar, size = "d", "-"
+		}
+		if match.Line > 0 {
+			location = fmt.Sprintf("%s:%d", match.Path, match.Line)
+		}
+		fmt.Fprintf(w, "%s%s %10s %s %s\n", typeChar, match.Mode.Perm().String()[1:], size, match.ModTime.Format("2006-01-02 15:04"), location)
+	}
+	if r.Truncated {
+		fmt.Fprintf(w, "Stopped after %d matches (%s), there may be more\n", len(r.Matches), r.Reason)
+	}
+	if r.Errors > 0 {
+		fmt.Fprintf(w, "Skipped %d paths the server could not read\n", r.Errors)
+	}
+}
+
+func (a *appContext) findAction(c *cli.Context) {
+	c2 := a.start(c)
+	args := requireArgs(c, "remotedir")
+	serverUrl := c2.RequireGlobalString("serverurl")
+
+	now := time.Now()
+	options := &fileclient.FindOptions{
+		Names:      c.StringSlice("name"), //Only a command line flag, a list cannot come from the environment
+		Type:       c2.settings.String("type"),
+		MinSize:    c2.ByteSize("min-size"),
+		MaxSize:    c2.ByteSize("max-size"),
+		NewerThan:  c2.Time("newer-than", now),
+		OlderThan:  c2.Time("older-than", now),
+		Contains:   c2.settings.String("contains"),
+		MaxResults: c2.settings.Int("max-results"),
+		Timeout:    c2.settings.Duration("search-timeout"),
+	}
+
+	client := fileclient.New(a.logger, c2.ClientOptions()...)
+	result, err := client.Find(serverUrl, args[0], options)
+	CheckError(err)
+	a.printResult(&findResult{RemoteDir: args[0], FindResult: result})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+
+	"github.com/francoishill/golang-web-dry/zip/examples/cliconfig"
+	"github.com/francoishill/golang-web-dry/zip/examples/fileclient"
+	"github.com/francoishill/golang-web-dry/zip/ziputils"
+)
+
+// The exit codes tell scripts what kind of error occurred, without having to parse the error message
+const (
+	exitCodeError     = 1
+	exitCodeUsage     = 2
+	exitCodeNotFound  = 3
+	exitCodeAuth      = 4
+	exitCodeNetwork   = 5
+	exitCodeIntegrity = 6
+	exitCodeConflict  = 7
+)
+
+const exitCodesUsage = "Exit codes: 1 error, 2 invalid usage, 3 not found, 4 unauthorized or permission denied, 5 network error, 6 integrity error (incomplete or invalid transfer), 7 conflict (already exists, not empty or locked)."
+
+// usageError is an invalid command line, like a missing argument or an invalid flag value
+type usageError struct {
+	message string
+}
+
+func (e *usageError) Error() string {
+	return e.message
+}
+
+// notFoundError is a path that does not exist, without the server returning an error (like for 'stat' or a glob without matches)
+type notFoundError struct {
+	message string
+}
+
+func (e *notFoundError) Error() string {
+	return e.message
+}
+
+func exitCodeOf(err error) int {
+	var usageErr *usageError
+	var flagErr *cliconfig.FlagError
+	if errors.As(err, &usageErr) || errors.As(err, &flagErr) {
+		return exitCodeUsage
+	}
+
+	var notFoundErr *notFoundError
+	if errors.As(err, &notFoundErr) || os.IsNotExist(err) {
+		return exitCodeNotFound
+	}
+
+	if err == ziputils.ErrTarValidationFailed {
+		return exitCodeIntegrity
+	}
+
+	switch fileclient.ErrorCodeOf(err) {
+	case "":
+		break
+	case fileclient.ErrorCodeNotFound:
+		return exitCodeNotFound
+	case fileclient.ErrorCodeUnauthorized, fileclient.ErrorCodePermissionDenied:
+		return exitCodeAuth
+	case fileclient.ErrorCodeInvalidArchive:
+		return exitCodeIntegrity
+	case fileclient.ErrorCodeAlreadyExists, fileclient.ErrorCodeNotEmpty, fileclient.ErrorCodeConflict, fileclient.ErrorCodeLocked:
+		return exitCodeConflict
+	default:
+		return exitCodeError
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return exitCodeNetwork
+	}
+
+	return exitCodeError
+}
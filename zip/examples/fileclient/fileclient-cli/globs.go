@@ -0,0 +1,51 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+	"github.com/francoishill/golang-web-dry/zip/examples/fileclient"
+)
+
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[`)
+}
+
+// isMultipleSources is true if the sources go into a destination directory, which is also the case for a single pattern (even if it only matches one path)
+func isMultipleSources(sources []string) bool {
+	return len(sources) > 1 || hasGlobMeta(sources[0])
+}
+
+// expandLocalGlobs returns the local paths matching the patterns, a path without wildcards is kept as is
+func expandLocalGlobs(patterns []string) []string {
+	paths := []string{}
+	for _, pattern := range patterns {
+		if !hasGlobMeta(pattern) {
+			paths = append(paths, pattern)
+			continue
+		}
+
+		matches, err := filepath.Glob(pattern)
+		CheckError(err)
+		if len(matches) == 0 {
+			panic(&notFoundError{"No local paths match '" + pattern + "'"})
+		}
+		paths = append(paths, matches...)
+	}
+	return paths
+}
+
+// expandRemoteGlobs returns the remote paths matching the patterns, the directories are listed on the server
+func expandRemoteGlobs(client fileclient.Client, serverUrl string, patterns []string) []string {
+	paths := []string{}
+	for _, pattern := range patterns {
+		matches, err := client.Glob(serverUrl, pattern)
+		CheckError(err)
+		if len(matches) == 0 {
+			panic(&notFoundError{"No remote paths match '" + pattern + "'"})
+		}
+		paths = append(paths, matches...)
+	}
+	return paths
+}
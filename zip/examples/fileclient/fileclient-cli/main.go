@@ -1,15 +1,24 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/codegangsta/cli"
+	"github.com/dustin/go-humanize"
 
 	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+	"github.com/francoishill/golang-web-dry/logging/leveledlog"
+	"github.com/francoishill/golang-web-dry/zip/examples/cliconfig"
 	"github.com/francoishill/golang-web-dry/zip/examples/fileclient"
 )
 
@@ -17,44 +26,181 @@ const (
 	AppVersion = "0.0.2"
 )
 
-type Logger interface {
-	Debug(msg string, args ...interface{})
-	Info(msg string, args ...interface{})
-	Error(msg string, args ...interface{})
+// newLogger logs the debug and info messages to out and the warnings and errors to stderr
+func newLogger(out io.Writer) leveledlog.Logger {
+	flags := log.Ldate | log.Ltime | log.Lshortfile
+	return leveledlog.NewStdByLevel(map[leveledlog.Level]*log.Logger{
+		leveledlog.DebugLevel: log.New(out, "[D] ", flags),
+		leveledlog.InfoLevel:  log.New(out, "[I] ", flags),
+		leveledlog.WarnLevel:  log.New(os.Stderr, "[W] ", flags),
+		leveledlog.ErrorLevel: log.New(os.Stderr, "[E] ", flags),
+	})
 }
 
-type defaultLogger struct {
-	d *log.Logger
-	i *log.Logger
-	e *log.Logger
-}
+const envVarPrefix = "FILECLIENT_"
 
-func (l *defaultLogger) Debug(msg string, args ...interface{}) {
-	l.d.Println(fmt.Sprintf(msg, args...))
-}
+/*
+clientConfig is the YAML config file of named server profiles, each profile has flag values by flag name:
 
-func (l *defaultLogger) Info(msg string, args ...interface{}) {
-	l.i.Println(fmt.Sprintf(msg, args...))
+	profile: prod
+	profiles:
+	  prod:
+	    serverurl: https://files.example.com:60878
+	    token: ${PROD_FILES_TOKEN}
+	    retries: 3
+	  local:
+	    serverurl: http://localhost:60878
+	    filefilter: "*.log"
+*/
+type clientConfig struct {
+	//Profile is used when the --profile flag is not given
+	Profile  string                      `yaml:"profile"`
+	Profiles map[string]cliconfig.Values `yaml:"profiles"`
 }
 
-func (l *defaultLogger) Error(msg string, args ...interface{}) {
-	l.e.Println(fmt.Sprintf(msg, args...))
+func defaultConfigFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".fileclient.yml")
 }
 
+// cliExtendedContext reads the flags from the command line, the FILECLIENT_* environment variables or the selected profile of the config file
 type cliExtendedContext struct {
 	*cli.Context
+	settings *cliconfig.Lookup
+}
+
+func newCliExtendedContext(c *cli.Context) *cliExtendedContext {
+	settings := &cliconfig.Lookup{Context: c, EnvPrefix: envVarPrefix}
+
+	configFile := settings.String("config")
+	configFileRequired := configFile != ""
+	if !configFileRequired {
+		configFile = defaultConfigFile()
+	}
+
+	config := &clientConfig{}
+	if configFile != "" && !cliconfig.LoadFile(configFile, config) && configFileRequired {
+		panic(&usageError{"Config file '" + configFile + "' does not exist"})
+	}
+
+	profileName := settings.String("profile")
+	if profileName == "" {
+		profileName = config.Profile
+	}
+	if profileName != "" {
+		profile, ok := config.Profiles[profileName]
+		if !ok {
+			panic(&usageError{"Profile '" + profileName + "' does not exist in config file '" + configFile + "'"})
+		}
+		settings.Config = profile
+	}
+
+	return &cliExtendedContext{c, settings}
 }
 
 func (c *cliExtendedContext) RequireGlobalString(flagName string) string {
-	val := c.GlobalString(flagName)
+	return c.settings.RequireString(flagName)
+}
+
+func (c *cliExtendedContext) GlobalFileMode(flagName string, defaultMode os.FileMode) os.FileMode {
+	val := c.settings.String(flagName)
 	if strings.TrimSpace(val) == "" {
-		panic("Flag '" + flagName + "' is empty")
+		return defaultMode
+	}
+
+	mode, err := strconv.ParseUint(val, 8, 32)
+	if err != nil {
+		panic(&usageError{"Flag '" + flagName + "' must be an octal file mode, got '" + val + "'"})
 	}
-	return val
+	return os.FileMode(mode)
+}
+
+// ByteSize returns the byte size of the flag, 0 if it is not set
+func (c *cliExtendedContext) ByteSize(flagName string) int64 {
+	val := c.settings.String(flagName)
+	if val == "" {
+		return 0
+	}
+
+	size, err := humanize.ParseBytes(val)
+	if err != nil {
+		panic(&usageError{"Flag '" + flagName + "' must be a byte size like 10MiB, got '" + val + "'"})
+	}
+	return int64(size)
+}
+
+// Time returns the RFC3339 time of the flag, or now minus its duration, the zero time if it is not set
+func (c *cliExtendedContext) Time(flagName string, now time.Time) time.Time {
+	val := c.settings.String(flagName)
+	if val == "" {
+		return time.Time{}
+	}
+
+	if t, err := time.Parse(time.RFC3339, val); err == nil {
+		return t
+	}
+	if duration, err := time.ParseDuration(val); err == nil {
+		return now.Add(-duration)
+	}
+	panic(&usageError{"Flag '" + flagName + "' must be an RFC3339 time or a duration like 24h, got '" + val + "'"})
+}
+
+func (c *cliExtendedContext) ClientOptions() []fileclient.Option {
+	options := []fileclient.Option{
+		fileclient.WithUserAgent("fileclient-cli/" + AppVersion),
+	}
+
+	if token := c.settings.String("token"); token != "" {
+		options = append(options, fileclient.WithBearerToken(token))
+	}
+
+	if timeout := c.settings.Duration("timeout"); timeout > 0 {
+		options = append(options, fileclient.WithRequestTimeout(timeout))
+	}
+
+	if retries := c.settings.Int("retries"); retries > 0 {
+		retryPolicy := fileclient.DefaultRetryPolicy()
+		retryPolicy.MaxAttempts = retries + 1
+		options = append(options, fileclient.WithRetryPolicy(retryPolicy))
+	}
+
+	if rateLimit := c.settings.String("limit-rate"); rateLimit != "" {
+		bytesPerSecond, err := humanize.ParseBytes(rateLimit)
+		if err != nil {
+			panic(&usageError{"Flag 'limit-rate' must be a byte size like 1MiB, got '" + rateLimit + "'"})
+		}
+
+		var burstBytes uint64 = 0
+		if burst := c.settings.String("limit-burst"); burst != "" {
+			burstBytes, err = humanize.ParseBytes(burst)
+			if err != nil {
+				panic(&usageError{"Flag 'limit-burst' must be a byte size like 4MiB, got '" + burst + "'"})
+			}
+		}
+		options = append(options, fileclient.WithRateLimit(int64(bytesPerSecond), int64(burstBytes)))
+	}
+
+	return options
+}
+
+// stopOnInterrupt returns a channel that is closed once the process receives an interrupt signal (Ctrl+C)
+func stopOnInterrupt() <-chan struct{} {
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		close(stop)
+	}()
+	return stop
 }
 
 type timer struct {
-	logger    Logger
+	logger    leveledlog.Logger
 	startTime time.Time
 }
 
@@ -64,37 +210,82 @@ func (t *timer) printDuration() {
 }
 
 type appContext struct {
-	logger Logger
+	logger     leveledlog.Logger
+	jsonOutput bool
+}
+
+// start reads the settings of the command line and config file, each action starts with it
+func (a *appContext) start(c *cli.Context) *cliExtendedContext {
+	c2 := newCliExtendedContext(c)
+
+	a.jsonOutput = c2.settings.Bool("json")
+	if a.jsonOutput {
+		//Keeps stdout free for the JSON output
+		a.logger = newLogger(os.Stderr)
+	} else {
+		a.logger.Info("VERSION " + AppVersion)
+	}
+	return c2
 }
 
+// MainAction runs the legacy --mode actions, when no command is given
 func (a *appContext) MainAction(c *cli.Context) {
-	c2 := &cliExtendedContext{c}
+	c2 := a.start(c)
 
 	mode := c2.RequireGlobalString("mode")
 
 	serverUrl := c2.RequireGlobalString("serverurl")
-	remotePath := c2.RequireGlobalString("remotepath")
+	remotePath := ""
+	if mode != "BATCH" && mode != "DISKUSAGE" && mode != "TRASH" && mode != "RESTORE" {
+		remotePath = c2.RequireGlobalString("remotepath")
+	}
 
-	client := fileclient.New(a.logger)
+	client := fileclient.New(a.logger, c2.ClientOptions()...)
 
 	defer (&timer{a.logger, time.Now()}).printDuration()
 	switch mode {
 	case "DOWNLOAD":
 		localPath := c2.RequireGlobalString("localpath")
-		dirFileFilterPattern := c.GlobalString("filefilter") //Not required
+		dirFileFilterPattern := c2.settings.String("filefilter") //Not required
 		err := client.DownloadDirFiltered(serverUrl, localPath, remotePath, dirFileFilterPattern)
 		CheckError(err)
 		break
 	case "UPLOAD":
 		localPath := c2.RequireGlobalString("localpath")
-		dirFileFilterPattern := c.GlobalString("filefilter") //Not required
-		err := client.UploadDirFiltered(serverUrl, localPath, remotePath, dirFileFilterPattern)
-		CheckError(err)
+		dirFileFilterPattern := c2.settings.String("filefilter") //Not required
+		if c2.settings.Bool("dedup") {
+			result, err := client.UploadDedup(serverUrl, localPath, remotePath, dirFileFilterPattern)
+			CheckError(err)
+			a.logger.Info("DEDUP_LINKED %d files (%s)", result.LinkedFiles, humanize.IBytes(uint64(result.LinkedBytes)))
+			a.logger.Info("DEDUP_UPLOADED %d files (%s)", result.UploadedFiles, humanize.IBytes(uint64(result.UploadedBytes)))
+		} else {
+			err := client.UploadDirFiltered(serverUrl, localPath, remotePath, dirFileFilterPattern)
+			CheckError(err)
+		}
 		break
 	case "DELETE":
-		dirFileFilterPattern := c.GlobalString("filefilter") //Not required
-		err := client.DeleteDirFiltered(serverUrl, remotePath, dirFileFilterPattern)
+		options := &fileclient.DeleteOptions{
+			FileFilter:      c2.settings.String("filefilter"), //Not required
+			DryRun:          c2.settings.Bool("dry-run"),
+			RemoveEmptyDirs: c2.settings.Bool("remove-empty-dirs"),
+		}
+		result, err := client.DeleteWithOptions(serverUrl, remotePath, options)
 		CheckError(err)
+
+		for _, deletedPath := range result.DeletedPaths {
+			if result.DryRun {
+				a.logger.Info("WOULD_DELETE %s", deletedPath)
+			} else {
+				a.logger.Info("DELETED %s", deletedPath)
+			}
+		}
+		for _, deleteErr := range result.Errors {
+			a.logger.Error("DELETE_FAILED %s: %s", deleteErr.Path, deleteErr.Error)
+		}
+
+		if len(result.Errors) > 0 {
+			panic(fmt.Sprintf("%d paths could not be deleted", len(result.Errors)))
+		}
 		break
 	case "STATS":
 		stats, err := client.Stats(serverUrl, remotePath)
@@ -114,41 +305,163 @@ func (a *appContext) MainAction(c *cli.Context) {
 
 		break
 	case "MOVE":
-		newRemotePath := c.GlobalString("newpath") //Not required
+		newRemotePath := c2.settings.String("newpath") //Not required
 		err := client.Move(serverUrl, remotePath, newRemotePath)
 		CheckError(err)
 		break
+	case "COPY":
+		newRemotePath := c2.RequireGlobalString("newpath")
+		dirFileFilterPattern := c2.settings.String("filefilter") //Not required
+		err := client.CopyDirFiltered(serverUrl, remotePath, newRemotePath, dirFileFilterPattern)
+		CheckError(err)
+		break
+	case "MKDIR":
+		fileMode := c2.GlobalFileMode("filemode", 0755)
+		err := client.Mkdir(serverUrl, remotePath, c2.settings.Bool("parents"), fileMode)
+		CheckError(err)
+		break
+	case "CHMOD":
+		c2.RequireGlobalString("filemode")
+		fileMode := c2.GlobalFileMode("filemode", 0)
+		err := client.Chmod(serverUrl, remotePath, fileMode)
+		CheckError(err)
+		break
+	case "TOUCH":
+		err := client.Touch(serverUrl, remotePath)
+		CheckError(err)
+		break
+	case "WATCH":
+		stop := stopOnInterrupt()
+		events, err := client.Watch(serverUrl, remotePath, stop)
+		CheckError(err)
+
+		for event := range events {
+			a.logger.Info("WATCH_EVENT %s %s", event.Type, event.Path)
+		}
+		break
+	case "TAIL":
+		stop := stopOnInterrupt()
+		err := client.Tail(serverUrl, remotePath, int64(c2.settings.Int("offset")), os.Stdout, stop)
+		CheckError(err)
+		break
+	case "DISKUSAGE":
+		usages, err := client.DiskUsage(serverUrl)
+		CheckError(err)
+
+		for _, usage := range usages {
+			a.logger.Info("DISKUSAGE %s %s used=%s quota=%s available=%s diskfree=%s disktotal=%s",
+				usage.Name, usage.Path,
+				humanize.IBytes(uint64(usage.UsedBytes)),
+				humanize.IBytes(uint64(usage.QuotaBytes)),
+				humanize.IBytes(uint64(usage.AvailableBytes)),
+				humanize.IBytes(uint64(usage.DiskFreeBytes)),
+				humanize.IBytes(uint64(usage.DiskTotalBytes)))
+		}
+		break
+	case "TRASH":
+		rootName := c2.RequireGlobalString("root")
+		items, err := client.ListTrash(serverUrl, rootName)
+		CheckError(err)
+
+		for _, item := range items {
+			a.logger.Info("TRASH_ITEM %s %s deleted=%s size=%s client=%s",
+				item.Id, item.OriginalPath,
+				item.DeletedTime.Format(time.RFC3339),
+				humanize.IBytes(uint64(item.SizeBytes)),
+				item.ClientIP)
+		}
+		break
+	case "RESTORE":
+		rootName := c2.RequireGlobalString("root")
+		trashId := c2.RequireGlobalString("trashid")
+		newRemotePath := c2.settings.String("newpath") //Not required
+		err := client.RestoreTrash(serverUrl, rootName, trashId, newRemotePath)
+		CheckError(err)
+		break
+	case "BATCH":
+		batchFile := c2.RequireGlobalString("batchfile")
+		batchJson, err := ioutil.ReadFile(batchFile)
+		CheckError(err)
+
+		batch := &fileclient.Batch{}
+		err = json.Unmarshal(batchJson, batch)
+		CheckError(err)
+
+		results, err := client.Batch(serverUrl, batch)
+		CheckError(err)
+
+		failedCount := 0
+		for _, result := range results {
+			if result.Skipped {
+				a.logger.Info("BATCH_SKIPPED %s %s", result.Op, result.Path)
+			} else if !result.Success {
+				failedCount++
+				a.logger.Error("BATCH_FAILED %s %s: %s", result.Op, result.Path, result.Error)
+			} else {
+				a.logger.Info("BATCH_OK %s %s", result.Op, result.Path)
+			}
+		}
+
+		if failedCount > 0 {
+			panic(fmt.Sprintf("%d batch operations failed", failedCount))
+		}
+		break
 	default:
-		panic("Unknown mode '" + mode + "'")
+		panic(&usageError{"Unknown mode '" + mode + "'"})
 	}
 }
 
-func main() {
-	logger := &defaultLogger{
-		d: log.New(os.Stdout, "[D] ", log.Ldate|log.Ltime|log.Lshortfile),
-		i: log.New(os.Stdout, "[I] ", log.Ldate|log.Ltime|log.Lshortfile),
-		e: log.New(os.Stderr, "[E] ", log.Ldate|log.Ltime|log.Lshortfile),
+// run runs the command line args, panics (also of the actions) are returned as error
+func (a *appContext) run(args []string) (returnErr error) {
+	defer fileclient.CatchPanicAsError(&returnErr)
+
+	err := a.newApp().Run(args)
+	if err != nil {
+		return &usageError{err.Error()}
 	}
-	context := &appContext{logger}
+	return nil
+}
 
-	logger.i.Println("VERSION " + AppVersion)
+func main() {
+	context := &appContext{logger: newLogger(os.Stdout)}
 
-	defer func() {
-		if r := recover(); r != nil {
-			logger.Error("%+v", r)
-			os.Exit(2)
-		}
-	}()
+	if err := context.run(os.Args); err != nil {
+		exitCode := exitCodeOf(err)
+		context.printError(err, exitCode)
+		os.Exit(exitCode)
+	}
+}
 
+func (a *appContext) newApp() *cli.App {
 	app := cli.NewApp()
 	app.Name = "copyclient"
-	app.Usage = "A http client to copy files to a server"
-	app.Action = context.MainAction
+	app.Usage = "A http client to copy files to a server. Every flag can also be set with a FILECLIENT_<FLAG> environment variable (for example FILECLIENT_SERVERURL) or in a profile of the config file. " + exitCodesUsage
+	app.Action = a.MainAction
+	app.Commands = a.commands()
 	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "config",
+			Value: "",
+			Usage: "Path to the YAML config file of server profiles, defaults to ~/.fileclient.yml (if it exists)",
+		},
+		cli.StringFlag{
+			Name:  "profile",
+			Value: "",
+			Usage: "The name of the profile in the config file to take the flag values from, defaults to the 'profile' of the config file",
+		},
+		cli.StringFlag{
+			Name:  "token",
+			Value: "",
+			Usage: "The bearer token for servers started with --auth-token",
+		},
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "Write the result of the command (or the error) as JSON to stdout, the log lines go to stderr",
+		},
 		cli.StringFlag{
 			Name:  "mode,m",
 			Value: "",
-			Usage: "The mode of the action (UPLOAD, DOWNLOAD, DELETE, STATS)",
+			Usage: "The mode of the action when no command is given (UPLOAD, DOWNLOAD, DELETE, STATS, MOVE, COPY, MKDIR, CHMOD, TOUCH, BATCH, WATCH, TAIL, DISKUSAGE, TRASH, RESTORE)",
 		},
 		cli.StringFlag{
 			Name:  "serverurl,s",
@@ -173,9 +486,70 @@ func main() {
 		cli.StringFlag{
 			Name:  "newpath,np",
 			Value: "",
-			Usage: "The new path, this is only currently applicable to the 'MOVE' method.",
+			Usage: "The new path, this is only currently applicable to the 'MOVE', 'COPY' and 'RESTORE' (optional) modes.",
+		},
+		cli.StringFlag{
+			Name:  "root",
+			Value: "",
+			Usage: "The name of the server root, applicable to the 'TRASH' and 'RESTORE' modes.",
+		},
+		cli.StringFlag{
+			Name:  "trashid",
+			Value: "",
+			Usage: "The id of the trash item to restore (see the 'TRASH' mode), applicable to the 'RESTORE' mode.",
+		},
+		cli.StringFlag{
+			Name:  "filemode,fm",
+			Value: "",
+			Usage: "The octal file mode (for example 0755), applicable to the 'MKDIR' (defaults to 0755) and 'CHMOD' modes.",
+		},
+		cli.StringFlag{
+			Name:  "batchfile,bf",
+			Value: "",
+			Usage: "Path to a JSON file containing the batch of operations (see fileclient.Batch), applicable to the 'BATCH' mode.",
+		},
+		cli.DurationFlag{
+			Name:  "timeout",
+			Value: 0,
+			Usage: "The maximum duration of each request (for example 30s or 10m), zero means no timeout. Does not apply to 'WATCH' and 'TAIL'.",
+		},
+		cli.IntFlag{
+			Name:  "retries",
+			Value: 0,
+			Usage: "The number of times to retry idempotent requests (DOWNLOAD, STATS, DELETE --dry-run, MKDIR --parents, CHMOD, TOUCH, WATCH, TAIL) with exponential backoff.",
+		},
+		cli.StringFlag{
+			Name:  "limit-rate",
+			Value: "",
+			Usage: "Limit the bandwidth of uploads and downloads to this number of bytes per second (for example 1MiB or 500KB)",
+		},
+		cli.StringFlag{
+			Name:  "limit-burst",
+			Value: "",
+			Usage: "The number of bytes allowed to burst above 'limit-rate', defaults to one second worth of bytes",
+		},
+		cli.IntFlag{
+			Name:  "offset",
+			Value: 0,
+			Usage: "The byte offset to start from (negative is relative to the end of the file), applicable to the 'TAIL' mode.",
+		},
+		cli.BoolFlag{
+			Name:  "dedup",
+			Usage: "Send the file hashes first and only upload the files the server does not have yet (the remote root must have dedup enabled), applicable to the 'UPLOAD' mode.",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Only list the paths that would be deleted, applicable to the 'DELETE' mode.",
+		},
+		cli.BoolFlag{
+			Name:  "remove-empty-dirs",
+			Usage: "Also remove the directories left empty after deleting the files matching the filefilter, applicable to the 'DELETE' mode.",
+		},
+		cli.BoolFlag{
+			Name:  "parents",
+			Usage: "Create missing parent directories, applicable to the 'MKDIR' mode.",
 		},
 	}
 	app.Version = AppVersion
-	app.Run(os.Args)
+	return app
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+	"github.com/francoishill/golang-web-dry/zip/examples/fileclient"
+)
+
+// commandResult is the result of a command, it is written as JSON with --json or else as human readable lines
+type commandResult interface {
+	printHuman(w io.Writer)
+}
+
+// jsonError is the --json output of a failed command
+type jsonError struct {
+	Error    string
+	Code     fileclient.ErrorCode `json:",omitempty"`
+	ExitCode int
+}
+
+func (a *appContext) printResult(result commandResult) {
+	if !a.jsonOutput {
+		result.printHuman(os.Stdout)
+		return
+	}
+
+	err := json.NewEncoder(os.Stdout).Encode(result)
+	CheckError(err)
+}
+
+func (a *appContext) printError(err error, exitCode int) {
+	if !a.jsonOutput {
+		a.logger.Error("%s", err.Error())
+		return
+	}
+
+	encodeErr := json.NewEncoder(os.Stdout).Encode(&jsonError{
+		Error:    err.Error(),
+		Code:     fileclient.ErrorCodeOf(err),
+		ExitCode: exitCode,
+	})
+	if encodeErr != nil {
+		a.logger.Error("%s", err.Error())
+	}
+}
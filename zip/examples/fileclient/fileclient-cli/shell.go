@@ -0,0 +1,523 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/peterh/liner"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+	"github.com/francoishill/golang-web-dry/zip/examples/fileclient"
+)
+
+/*
+The shell keeps a session against one server, like sftp. Relative remote paths are relative to the remote directory
+of 'cd' and relative local paths to the local directory of 'lcd'. For example:
+
+	fileclient-cli --profile prod shell /srv/builds
+	/srv/builds> cd 1.2
+	/srv/builds/1.2> get app-*.zip
+	/srv/builds/1.2> put ./CHANGELOG.md
+
+Tab completes the commands and the (remote or local) paths, the history is kept in ~/.fileclient_history.
+*/
+
+type shellArgKind int
+
+const (
+	shellArgNone shellArgKind = iota
+	shellArgRemote
+	shellArgLocal
+)
+
+type shellCommand struct {
+	usage string
+	//args are the kinds of the arguments for tab completion, the last kind repeats
+	args []shellArgKind
+	run  func(s *shellSession, args []string)
+}
+
+var shellCommands map[string]*shellCommand
+
+func init() {
+	shellCommands = map[string]*shellCommand{
+		"cd":   {"cd <remotedir>, changes the remote directory", []shellArgKind{shellArgRemote}, (*shellSession).cd},
+		"lcd":  {"lcd <localdir>, changes the local directory", []shellArgKind{shellArgLocal}, (*shellSession).lcd},
+		"pwd":  {"pwd, prints the remote directory", nil, (*shellSession).pwd},
+		"lpwd": {"lpwd, prints the local directory", nil, (*shellSession).lpwd},
+		"ls":   {"ls [remotepath], lists a remote directory", []shellArgKind{shellArgRemote}, (*shellSession).ls},
+		"get":  {"get <remotepath> [localpath], downloads into the local directory (all matches of a wildcard)", []shellArgKind{shellArgRemote, shellArgLocal}, (*shellSession).get},
+		"put":  {"put <localpath> [remotepath], uploads into the remote directory (all matches of a wildcard)", []shellArgKind{shellArgLocal, shellArgRemote}, (*shellSession).put},
+		"rm":   {"rm <remotepath>..., deletes remote files or directories", []shellArgKind{shellArgRemote}, (*shellSession).rm},
+		"mv":   {"mv <remotepath> <newremotepath>, moves or renames a remote file or directory", []shellArgKind{shellArgRemote}, (*shellSession).mv},
+		"stat": {"stat <remotepath>, tells if a remote path is a file or directory", []shellArgKind{shellArgRemote}, (*shellSession).stat},
+		"help": {"help, lists the commands", nil, (*shellSession).help},
+		"exit": {"exit, ends the shell (or Ctrl+D)", nil, nil},
+		"quit": {"quit, ends the shell", nil, nil},
+	}
+}
+
+type shellSession struct {
+	a         *appContext
+	client    fileclient.Client
+	serverUrl string
+	remoteDir string
+	localDir  string
+}
+
+func (a *appContext) shellAction(c *cli.Context) {
+	c2 := a.start(c)
+	if a.jsonOutput {
+		panic(&usageError{"Flag 'json' is not supported by the shell"})
+	}
+
+	s := &shellSession{
+		a:         a,
+		client:    fileclient.New(a.logger, c2.ClientOptions()...),
+		serverUrl: c2.RequireGlobalString("serverurl"),
+	}
+
+	switch args := c.Args(); len(args) {
+	case 0:
+		s.remoteDir = c2.RequireGlobalString("remotepath")
+		break
+	case 1:
+		s.remoteDir = args[0]
+		break
+	default:
+		requireArgs(c, "remotedir")
+	}
+	if !isAbsoluteRemotePath(s.remoteDir) {
+		panic(&usageError{"The remote directory '" + s.remoteDir + "' must be an absolute path"})
+	}
+	s.cd([]string{s.remoteDir})
+
+	localDir, err := os.Getwd()
+	CheckError(err)
+	s.localDir = localDir
+
+	s.run()
+}
+
+func shellHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".fileclient_history")
+}
+
+func (s *shellSession) run() {
+	line := liner.NewLiner()
+	defer line.Close()
+
+	line.SetCtrlCAborts(true)
+	line.SetWordCompleter(s.complete)
+
+	historyFile := shellHistoryFile()
+	if f, err := os.Open(historyFile); err == nil {
+		line.ReadHistory(f)
+		f.Close()
+	}
+	defer func() {
+		if f, err := os.Create(historyFile); err == nil {
+			line.WriteHistory(f)
+			f.Close()
+		}
+	}()
+
+	for {
+		input, err := line.Prompt(s.remoteDir + "> ")
+		if err == liner.ErrPromptAborted {
+			continue
+		} else if err != nil {
+			//io.EOF (Ctrl+D)
+			fmt.Println()
+			return
+		}
+
+		if strings.TrimSpace(input) == "" {
+			continue
+		}
+		line.AppendHistory(input)
+
+		args, err := splitShellArgs(input)
+		if err != nil {
+			s.a.logger.Error("%s", err.Error())
+			continue
+		}
+		if args[0] == "exit" || args[0] == "quit" {
+			return
+		}
+
+		if err = s.runCommand(args); err != nil {
+			s.a.logger.Error("%s", err.Error())
+		}
+	}
+}
+
+// runCommand runs a line of the shell, it returns the errors instead of ending the shell
+func (s *shellSession) runCommand(args []string) (returnErr error) {
+	defer fileclient.CatchPanicAsError(&returnErr)
+
+	command, ok := shellCommands[args[0]]
+	if !ok {
+		return &usageError{"Unknown command '" + args[0] + "', see 'help'"}
+	}
+	command.run(s, args[1:])
+	return nil
+}
+
+// splitShellArgs splits line at the spaces outside of double or single quotes
+func splitShellArgs(line string) ([]string, error) {
+	args := []string{}
+	current := []rune{}
+	inArg := false
+	var quote rune = 0
+
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+			break
+		case quote != 0:
+			current = append(current, r)
+			break
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+			break
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, string(current))
+				current = []rune{}
+				inArg = false
+			}
+			break
+		default:
+			current = append(current, r)
+			inArg = true
+			break
+		}
+	}
+
+	if quote != 0 {
+		return nil, &usageError{"Missing closing quote " + string(quote)}
+	}
+	if inArg {
+		args = append(args, string(current))
+	}
+	return args, nil
+}
+
+// isAbsoluteRemotePath is true for /dir, \dir and C:\dir, since the server may run on another OS
+func isAbsoluteRemotePath(remotePath string) bool {
+	return strings.HasPrefix(remotePath, "/") || strings.HasPrefix(remotePath, `\`) || (len(remotePath) >= 2 && remotePath[1] == ':')
+}
+
+// resolveRemotePath returns the absolute remote path of remotePath relative to dir, using the separator of dir
+func resolveRemotePath(dir, remotePath string) string {
+	if remotePath == "" {
+		return dir
+	}
+
+	sep := "/"
+	if strings.Contains(dir, `\`) && !strings.Contains(dir, "/") {
+		sep = `\`
+	}
+
+	joined := remotePath
+	if !isAbsoluteRemotePath(remotePath) {
+		joined = dir + sep + remotePath
+	}
+
+	cleaned := pathpkg.Clean(strings.Replace(joined, `\`, "/", -1))
+	return strings.Replace(cleaned, "/", sep, -1)
+}
+
+func resolveLocalPath(dir, localPath string) string {
+	if localPath == "" {
+		return dir
+	}
+	if filepath.IsAbs(localPath) {
+		return filepath.Clean(localPath)
+	}
+	return filepath.Join(dir, localPath)
+}
+
+func (s *shellSession) remotePath(remotePath string) string {
+	return resolveRemotePath(s.remoteDir, remotePath)
+}
+
+func (s *shellSession) localPath(localPath string) string {
+	return resolveLocalPath(s.localDir, localPath)
+}
+
+func requireShellArgs(args []string, minCount, maxCount int, usage string) {
+	if len(args) < minCount || len(args) > maxCount {
+		panic(&usageError{"Usage: " + usage})
+	}
+}
+
+func (s *shellSession) cd(args []string) {
+	requireShellArgs(args, 1, 1, shellCommands["cd"].usage)
+
+	dir := s.remotePath(args[0])
+	stats, err := s.client.Stats(s.serverUrl, dir)
+	CheckError(err)
+	if !stats.Exists {
+		panic(&notFoundError{"Remote directory '" + dir + "' does not exist"})
+	}
+	if !stats.IsDir {
+		panic(&usageError{"Remote path '" + dir + "' is not a directory"})
+	}
+	s.remoteDir = dir
+}
+
+func (s *shellSession) lcd(args []string) {
+	requireShellArgs(args, 1, 1, shellCommands["lcd"].usage)
+
+	dir := s.localPath(args[0])
+	info, err := os.Stat(dir)
+	CheckError(err)
+	if !info.IsDir() {
+		panic(&usageError{"Local path '" + dir + "' is not a directory"})
+	}
+	s.localDir = dir
+}
+
+func (s *shellSession) pwd(args []string) {
+	requireShellArgs(args, 0, 0, shellCommands["pwd"].usage)
+	fmt.Println(s.remoteDir)
+}
+
+func (s *shellSession) lpwd(args []string) {
+	requireShellArgs(args, 0, 0, shellCommands["lpwd"].usage)
+	fmt.Println(s.localDir)
+}
+
+func (s *shellSession) ls(args []string) {
+	requireShellArgs(args, 0, 1, shellCommands["ls"].usage)
+
+	remotePath := s.remoteDir
+	if len(args) == 1 {
+		remotePath = s.remotePath(args[0])
+	}
+
+	entries, err := s.client.List(s.serverUrl, remotePath)
+	CheckError(err)
+	(&lsResult{RemotePath: remotePath, Entries: entries}).printHuman(os.Stdout)
+}
+
+func (s *shellSession) get(args []string) {
+	requireShellArgs(args, 1, 2, shellCommands["get"].usage)
+
+	remotePattern := s.remotePath(args[0])
+	remotePaths := expandRemoteGlobs(s.client, s.serverUrl, []string{remotePattern})
+
+	if hasGlobMeta(remotePattern) {
+		localDir := s.localDir
+		if len(args) == 2 {
+			localDir = s.localPath(args[1])
+		}
+		err := s.client.DownloadPaths(s.serverUrl, remotePaths, localDir, "")
+		CheckError(err)
+		(&downloadResult{RemotePaths: remotePaths, LocalPath: localDir}).printHuman(os.Stdout)
+		return
+	}
+
+	_, _, baseName := splitRemoteBaseName(remotePaths[0])
+	localPath := s.localPath(baseName)
+	if len(args) == 2 {
+		localPath = s.localPath(args[1])
+	}
+	err := s.client.Download(s.serverUrl, localPath, remotePaths[0])
+	CheckError(err)
+	(&downloadResult{RemotePaths: remotePaths, LocalPath: localPath}).printHuman(os.Stdout)
+}
+
+func (s *shellSession) put(args []string) {
+	requireShellArgs(args, 1, 2, shellCommands["put"].usage)
+
+	localPattern := s.localPath(args[0])
+	localPaths := expandLocalGlobs([]string{localPattern})
+
+	if hasGlobMeta(localPattern) {
+		remoteDir := s.remoteDir
+		if len(args) == 2 {
+			remoteDir = s.remotePath(args[1])
+		}
+		err := s.client.UploadPaths(s.serverUrl, localPaths, remoteDir, "")
+		CheckError(err)
+		(&uploadResult{LocalPaths: localPaths, RemotePath: remoteDir}).printHuman(os.Stdout)
+		return
+	}
+
+	remotePath := s.remotePath(filepath.Base(localPaths[0]))
+	if len(args) == 2 {
+		remotePath = s.remotePath(args[1])
+	}
+	err := s.client.Upload(s.serverUrl, localPaths[0], remotePath)
+	CheckError(err)
+	(&uploadResult{LocalPaths: localPaths, RemotePath: remotePath}).printHuman(os.Stdout)
+}
+
+func (s *shellSession) rm(args []string) {
+	requireShellArgs(args, 1, len(args), shellCommands["rm"].usage)
+
+	patterns := []string{}
+	for _, arg := range args {
+		patterns = append(patterns, s.remotePath(arg))
+	}
+
+	result := &rmResult{RemotePaths: expandRemoteGlobs(s.client, s.serverUrl, patterns)}
+	for _, remotePath := range result.RemotePaths {
+		deleteResult, err := s.client.DeleteWithOptions(s.serverUrl, remotePath, nil)
+		CheckError(err)
+
+		result.DeletedPaths = append(result.DeletedPaths, deleteResult.DeletedPaths...)
+		result.Errors = append(result.Errors, deleteResult.Errors...)
+	}
+	result.printHuman(os.Stdout)
+}
+
+func (s *shellSession) mv(args []string) {
+	requireShellArgs(args, 2, 2, shellCommands["mv"].usage)
+
+	result := &mvResult{RemotePath: s.remotePath(args[0]), NewRemotePath: s.remotePath(args[1])}
+	err := s.client.Move(s.serverUrl, result.RemotePath, result.NewRemotePath)
+	CheckError(err)
+	result.printHuman(os.Stdout)
+}
+
+func (s *shellSession) stat(args []string) {
+	requireShellArgs(args, 1, 1, shellCommands["stat"].usage)
+
+	remotePath := s.remotePath(args[0])
+	stats, err := s.client.Stats(s.serverUrl, remotePath)
+	CheckError(err)
+	if !stats.Exists {
+		panic(&notFoundError{"Remote path '" + remotePath + "' does not exist"})
+	}
+	(&statResult{RemotePath: remotePath, IsDir: stats.IsDir}).printHuman(os.Stdout)
+}
+
+func (s *shellSession) help(args []string) {
+	names := []string{}
+	for name := range shellCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Println("  " + shellCommands[name].usage)
+	}
+}
+
+// splitRemoteBaseName splits the remote path at its last / or \, sep is empty if it has no separator
+func splitRemoteBaseName(remotePath string) (dir, sep, baseName string) {
+	i := strings.LastIndexAny(remotePath, `/\`)
+	if i < 0 {
+		return "", "", remotePath
+	}
+	return remotePath[:i+1], remotePath[i : i+1], remotePath[i+1:]
+}
+
+// complete is the tab completion of the command names and the remote or local paths of their arguments
+func (s *shellSession) complete(line string, pos int) (head string, completions []string, tail string) {
+	head, tail = line[:pos], line[pos:]
+	wordStart := strings.LastIndexAny(head, " \t") + 1
+	word := head[wordStart:]
+	head = head[:wordStart]
+
+	args, err := splitShellArgs(head)
+	if err != nil {
+		return head, nil, tail
+	}
+
+	if len(args) == 0 {
+		for name := range shellCommands {
+			if strings.HasPrefix(name, word) {
+				completions = append(completions, name)
+			}
+		}
+		sort.Strings(completions)
+		return head, completions, tail
+	}
+
+	command, ok := shellCommands[args[0]]
+	if !ok || len(command.args) == 0 {
+		return head, nil, tail
+	}
+
+	argIndex := len(args) - 1
+	if argIndex >= len(command.args) {
+		argIndex = len(command.args) - 1
+	}
+
+	switch command.args[argIndex] {
+	case shellArgRemote:
+		completions = s.completeRemotePath(word)
+		break
+	case shellArgLocal:
+		completions = s.completeLocalPath(word)
+		break
+	}
+	return head, completions, tail
+}
+
+func (s *shellSession) completeRemotePath(word string) []string {
+	wordDir, sep, prefix := splitRemoteBaseName(word)
+	if sep == "" {
+		sep = "/"
+		if strings.Contains(s.remoteDir, `\`) && !strings.Contains(s.remoteDir, "/") {
+			sep = `\`
+		}
+	}
+
+	entries, err := s.client.List(s.serverUrl, s.remotePath(wordDir))
+	if err != nil {
+		return nil
+	}
+
+	completions := []string{}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name, prefix) {
+			continue
+		}
+		if entry.IsDir {
+			completions = append(completions, wordDir+entry.Name+sep)
+		} else {
+			completions = append(completions, wordDir+entry.Name)
+		}
+	}
+	return completions
+}
+
+func (s *shellSession) completeLocalPath(word string) []string {
+	wordDir, prefix := filepath.Split(word)
+
+	infos, err := ioutil.ReadDir(s.localPath(wordDir))
+	if err != nil {
+		return nil
+	}
+
+	completions := []string{}
+	for _, info := range infos {
+		if !strings.HasPrefix(info.Name(), prefix) {
+			continue
+		}
+		if info.IsDir() {
+			completions = append(completions, wordDir+info.Name()+string(filepath.Separator))
+		} else {
+			completions = append(completions, wordDir+info.Name())
+		}
+	}
+	return completions
+}
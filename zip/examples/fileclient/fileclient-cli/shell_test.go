@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestShellHelpers(t *testing.T) {
+	Convey("Testing splitShellArgs", t, func() {
+		args, err := splitShellArgs(`put  "my file.txt" 'dir name'/ x`)
+		So(err, ShouldBeNil)
+		So(args, ShouldResemble, []string{"put", "my file.txt", "dir name/", "x"})
+
+		_, err = splitShellArgs(`put "my file.txt`)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Testing resolveRemotePath", t, func() {
+		So(resolveRemotePath("/srv/builds", "1.2/app.zip"), ShouldEqual, "/srv/builds/1.2/app.zip")
+		So(resolveRemotePath("/srv/builds", ".."), ShouldEqual, "/srv")
+		So(resolveRemotePath("/srv/builds", "/tmp"), ShouldEqual, "/tmp")
+		So(resolveRemotePath("/srv/builds", ""), ShouldEqual, "/srv/builds")
+		So(resolveRemotePath(`C:\builds`, `1.2\app.zip`), ShouldEqual, `C:\builds\1.2\app.zip`)
+		So(resolveRemotePath(`C:\builds\1.2`, `..`), ShouldEqual, `C:\builds`)
+	})
+}
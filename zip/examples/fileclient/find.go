@@ -0,0 +1,137 @@
+package fileclient
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	FindTypeFile = "file"
+	FindTypeDir  = "dir"
+)
+
+// FindOptions are the criteria of Find, the zero values do not filter. A path must match all of them.
+type FindOptions struct {
+	//Names are filepath.Match patterns on the base name, a path matches if any of them matches
+	Names []string
+	//Type is FindTypeFile or FindTypeDir to only find files or directories
+	Type string
+	//MinSize and MaxSize only find files with a size in this range, MaxSize 0 means no maximum
+	MinSize int64
+	MaxSize int64
+	//NewerThan and OlderThan only find paths modified in this range
+	NewerThan time.Time
+	OlderThan time.Time
+	//Contains only finds files containing this text, FoundFile.Line is the line of its first occurrence
+	Contains string
+	//MaxResults stops the search after this many matches, 0 means the server default (1000)
+	MaxResults int
+	//Timeout stops the search after this duration, 0 means the server default (30 seconds)
+	Timeout time.Duration
+}
+
+// FoundFile is a path matching the FindOptions
+type FoundFile struct {
+	Path    string
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+	IsDir   bool
+	Line    int `json:",omitempty"`
+}
+
+// FindResult lists the matches, if Truncated the search stopped early (see Reason) and there could be more
+type FindResult struct {
+	Matches   []*FoundFile
+	Truncated bool
+	//Reason is "maxresults", "timeout" or "shutdown" if Truncated
+	Reason string `json:",omitempty"`
+	//Errors is the number of paths the server could not read, they were skipped
+	Errors int `json:",omitempty"`
+}
+
+// findLine is a line of the find response, a match or the summary (with Done) ending the response
+type findLine struct {
+	FoundFile
+	Done      bool
+	Matches   int
+	Truncated bool
+	Reason    string
+	Errors    int
+}
+
+func (o *FindOptions) queryValues() url.Values {
+	params := url.Values{}
+	for _, name := range o.Names {
+		params.Add("name", name)
+	}
+	if o.Type != "" {
+		params.Set("type", o.Type)
+	}
+	if o.MinSize > 0 {
+		params.Set("minsize", strconv.FormatInt(o.MinSize, 10))
+	}
+	if o.MaxSize > 0 {
+		params.Set("maxsize", strconv.FormatInt(o.MaxSize, 10))
+	}
+	if !o.NewerThan.IsZero() {
+		params.Set("newerthan", o.NewerThan.Format(time.RFC3339))
+	}
+	if !o.OlderThan.IsZero() {
+		params.Set("olderthan", o.OlderThan.Format(time.RFC3339))
+	}
+	if o.Contains != "" {
+		params.Set("contains", o.Contains)
+	}
+	if o.MaxResults > 0 {
+		params.Set("maxresults", strconv.Itoa(o.MaxResults))
+	}
+	if o.Timeout > 0 {
+		params.Set("timeout", o.Timeout.String())
+	}
+	return params
+}
+
+func (c *client) find(serverUrl, remoteDir string, options *FindOptions) (result *FindResult, returnErr error) {
+	defer CatchPanicAsError(&returnErr)
+
+	params := options.queryValues()
+	params.Set("action", "find")
+	params.Set("path", remoteDir)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	resp, err := c.startStream(serverUrl+"?"+params.Encode(), stop)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result = &FindResult{Matches: []*FoundFile{}}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := &findLine{}
+		if err := json.Unmarshal(scanner.Bytes(), line); err != nil {
+			return nil, fmt.Errorf("Unable to decode the find response line '%s', error: %s", scanner.Text(), err.Error())
+		}
+
+		if !line.Done {
+			match := line.FoundFile
+			result.Matches = append(result.Matches, &match)
+			continue
+		}
+
+		result.Truncated, result.Reason, result.Errors = line.Truncated, line.Reason, line.Errors
+		return result, nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to read the find response after %d matches, error: %s", len(result.Matches), err.Error())
+	}
+	return nil, fmt.Errorf("The find response ended after %d matches without its summary, the server did not finish the search", len(result.Matches))
+}
@@ -0,0 +1,135 @@
+package fileclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// FileEntry is a file or directory inside the remote directory passed to List
+type FileEntry struct {
+	Name    string
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+	IsDir   bool
+}
+
+// List returns the entries of the remote directory sorted by name, or only the entry of the file if remotePath is a file
+func (c *client) List(serverUrl, remotePath string) ([]*FileEntry, error) {
+	return c.list(serverUrl, remotePath)
+}
+
+func (c *client) list(serverUrl, remotePath string) (entries []*FileEntry, returnErr error) {
+	defer CatchPanicAsError(&returnErr)
+
+	params := url.Values{}
+	params.Set("action", "list")
+	params.Set("path", remotePath)
+	req, err := http.NewRequest("GET", serverUrl+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req, requestRetryable)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err = c.checkServerResponse(resp); err != nil {
+		return nil, err
+	}
+
+	entries = []*FileEntry{}
+	if err = json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("Unable to decode the directory entries, error: %s", err.Error())
+	}
+	return entries, nil
+}
+
+/*
+Glob returns the remote paths matching the shell pattern (see path.Match), like filepath.Glob but the directories are
+listed on the server. For example:
+
+	paths, err := client.Glob(serverUrl, "/srv/builds/1.?/app-*.zip")
+*/
+func (c *client) Glob(serverUrl, pattern string) ([]string, error) {
+	return c.glob(serverUrl, pattern, false)
+}
+
+// hasGlobMeta is true if path contains any of the special characters of path.Match
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[`)
+}
+
+// splitRemotePath splits the path at its last separator, both / and \ are separators since the server may run on another OS
+func splitRemotePath(remotePath string) (dir, sep, file string) {
+	i := strings.LastIndexAny(remotePath, `/\`)
+	if i < 0 {
+		return "", "", remotePath
+	}
+	dir, sep, file = remotePath[:i], remotePath[i:i+1], remotePath[i+1:]
+	if dir == "" {
+		dir = sep
+	}
+	return dir, sep, file
+}
+
+func (c *client) glob(serverUrl, pattern string, dirsOnly bool) ([]string, error) {
+	if !hasGlobMeta(pattern) {
+		return []string{pattern}, nil
+	}
+
+	dir, sep, filePattern := splitRemotePath(pattern)
+	if sep == "" {
+		return nil, fmt.Errorf("Remote pattern '%s' must be an absolute path", pattern)
+	}
+
+	dirs := []string{dir}
+	if hasGlobMeta(dir) {
+		var err error
+		if dirs, err = c.glob(serverUrl, dir, true); err != nil {
+			return nil, err
+		}
+	} else {
+		//List returns the file itself for a file, which must not match
+		stats, err := c.getStats(serverUrl, dir)
+		if err != nil {
+			return nil, err
+		}
+		if !stats.Exists || !stats.IsDir {
+			return []string{}, nil
+		}
+	}
+
+	matches := []string{}
+	for _, dir := range dirs {
+		entries, err := c.list(serverUrl, dir)
+		if IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if dirsOnly && !entry.IsDir {
+				continue
+			}
+
+			matched, err := path.Match(filePattern, entry.Name)
+			if err != nil {
+				return nil, err
+			}
+			if matched {
+				matches = append(matches, strings.TrimRight(dir, sep)+sep+entry.Name)
+			}
+		}
+	}
+	return matches, nil
+}
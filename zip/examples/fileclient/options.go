@@ -0,0 +1,81 @@
+package fileclient
+
+import (
+	"net/http"
+	"time"
+)
+
+// Option configures the Client returned by New
+type Option func(c *client)
+
+/*
+RetryPolicy controls how idempotent requests (downloads, stats, dry run deletes, mkdir with parents, chmod, touch,
+watch and tail) are retried after network errors or 429/502/503/504 responses. Uploads, deletes, mkdir without
+parents, moves, copies and batches are never retried, a retry after a lost response would fail.
+
+The n-th retry waits InitialBackoff*Multiplier^(n-1), capped at MaxBackoff, of which a random fraction of up
+to Jitter (between 0 and 1) is subtracted so that many agents do not retry in lockstep.
+*/
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Jitter         float64
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.5,
+	}
+}
+
+// WithHttpClient sends all requests with httpClient instead of http.DefaultClient
+func WithHttpClient(httpClient *http.Client) Option {
+	return func(c *client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRequestTimeout limits the duration of each request, including reading the response body of downloads. Watch and Tail are not limited.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(c *client) {
+		c.requestTimeout = timeout
+	}
+}
+
+func WithRetryPolicy(retryPolicy *RetryPolicy) Option {
+	return func(c *client) {
+		c.retryPolicy = retryPolicy
+	}
+}
+
+// WithHeader adds a header to every request, for example an authorization token
+func WithHeader(key, value string) Option {
+	return func(c *client) {
+		c.headers.Add(key, value)
+	}
+}
+
+// WithBearerToken authenticates every request with the token, for servers started with --auth-token
+func WithBearerToken(token string) Option {
+	return WithHeader("Authorization", "Bearer "+token)
+}
+
+func WithUserAgent(userAgent string) Option {
+	return func(c *client) {
+		c.userAgent = userAgent
+	}
+}
+
+// WithRateLimit limits each upload and download to bytesPerSecond, allowing bursts of burstBytes (zero defaults to one second worth of bytes)
+func WithRateLimit(bytesPerSecond, burstBytes int64) Option {
+	return func(c *client) {
+		c.rateLimitBytesPerSecond = bytesPerSecond
+		c.rateLimitBurstBytes = burstBytes
+	}
+}
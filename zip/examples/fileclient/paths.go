@@ -0,0 +1,49 @@
+package fileclient
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/francoishill/golang-web-dry/logging/leveledlog"
+	"github.com/francoishill/golang-web-dry/zip/ziputils"
+)
+
+// UploadPaths uploads the local files and directories into remoteDir in a single request, each under its base name (like 'scp a b dir host:remoteDir/')
+func (c *client) UploadPaths(serverUrl string, localPaths []string, remoteDir, dirFileFilterPattern string) (returnErr error) {
+	defer CatchPanicAsError(&returnErr)
+
+	c.logger.With(leveledlog.F("path", remoteDir)).Debug("Now starting to upload %d local paths", len(localPaths))
+	walkContext := ziputils.NewDirWalkContext(dirFileFilterPattern)
+	ziputils.UploadPathsToUrlWithDoer(c.logger, &requestDoer{c}, serverUrl+"?dir="+url.QueryEscape(remoteDir), "application/octet-stream", localPaths, walkContext, c.checkServerResponse)
+	return nil
+}
+
+// DownloadPaths downloads the remote files and directories into localDir in a single request, each under its base name
+func (c *client) DownloadPaths(serverUrl string, remotePaths []string, localDir, dirFileFilterPattern string) (returnErr error) {
+	defer CatchPanicAsError(&returnErr)
+
+	params := url.Values{}
+	params.Set("action", "archive")
+	params["path"] = remotePaths
+	if dirFileFilterPattern != "" {
+		params.Set("filefilter", dirFileFilterPattern)
+	}
+
+	req, err := http.NewRequest("GET", serverUrl+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req, requestRetryable)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err = c.checkServerResponse(resp); err != nil {
+		return err
+	}
+
+	ziputils.SaveTarReaderToPath(c.logger, c.rateLimitTransfer(resp.Body), localDir)
+	return nil
+}
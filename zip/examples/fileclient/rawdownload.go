@@ -0,0 +1,48 @@
+package fileclient
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+func (c *client) readRange(serverUrl, remotePath string, offset, length int64, writer io.Writer) (returnErr error) {
+	defer CatchPanicAsError(&returnErr)
+
+	if offset < 0 {
+		return fmt.Errorf("The offset cannot be negative, got %d", offset)
+	}
+
+	req, err := http.NewRequest("GET", serverUrl+"?raw=1&path="+url.QueryEscape(remotePath), nil)
+	if err != nil {
+		return err
+	}
+	//Always a range, even for the whole file (bytes=0-), so a server without raw downloads is noticed by its 200 response with the tar of the file
+	if length > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := c.do(req, requestRetryable)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		if err = c.checkServerResponse(resp); err != nil {
+			return err
+		}
+		//The range of an empty file is ignored, only a raw download is sent inline
+		isRaw := strings.HasPrefix(resp.Header.Get("Content-Disposition"), "inline")
+		if !isRaw || resp.ContentLength != 0 {
+			return fmt.Errorf("The server did not serve the range of '%s', it may not support raw downloads", remotePath)
+		}
+	}
+
+	_, err = io.Copy(writer, c.rateLimitTransfer(resp.Body))
+	return err
+}
@@ -0,0 +1,145 @@
+package fileclient
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/francoishill/golang-web-dry/logging/leveledlog"
+)
+
+type requestFlags int
+
+const (
+	//The request may be sent again if it failed
+	requestRetryable requestFlags = 1 << iota
+	//The response is streamed for an unknown duration so the request timeout does not apply
+	requestStreaming
+)
+
+// cancelOnCloseBody cancels the request context once the response body is closed
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnCloseBody) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// requestDoer sends the streamed uploads of ziputils through the client, they are never retried
+type requestDoer struct {
+	c *client
+}
+
+func (r *requestDoer) Do(req *http.Request) (*http.Response, error) {
+	req.Body = r.c.rateLimitTransfer(req.Body)
+	return r.c.do(req, 0)
+}
+
+func isRetryableStatusCode(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func (c *client) backoffDuration(retryNumber int, resp *http.Response) time.Duration {
+	p := c.retryPolicy
+	backoff := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(retryNumber-1))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	backoff -= backoff * p.Jitter * rand.Float64()
+
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			retryAfter := float64(time.Duration(seconds) * time.Second)
+			if retryAfter > backoff && (p.MaxBackoff <= 0 || retryAfter <= float64(p.MaxBackoff)) {
+				backoff = retryAfter
+			}
+		}
+	}
+	return time.Duration(backoff)
+}
+
+func (c *client) applyHeaders(req *http.Request) {
+	for key, values := range c.headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+}
+
+func (c *client) doOnce(req *http.Request, flags requestFlags) (*http.Response, error) {
+	if c.requestTimeout <= 0 || flags&requestStreaming != 0 {
+		return c.httpClient.Do(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), c.requestTimeout)
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnCloseBody{resp.Body, cancel}
+	return resp, nil
+}
+
+// do sends the request with the configured headers and timeout, retrying it according to the RetryPolicy if it is retryable
+func (c *client) do(req *http.Request, flags requestFlags) (*http.Response, error) {
+	c.applyHeaders(req)
+
+	maxAttempts := 1
+	if c.retryPolicy != nil && flags&requestRetryable != 0 && (req.Body == nil || req.GetBody != nil) {
+		maxAttempts = c.retryPolicy.MaxAttempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		attemptReq := req
+		if attempt > 1 {
+			attemptReq = req.Clone(req.Context())
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				attemptReq.Body = body
+			}
+		}
+
+		resp, err := c.doOnce(attemptReq, flags)
+		if attempt >= maxAttempts || req.Context().Err() != nil {
+			return resp, err
+		}
+		if err == nil && !isRetryableStatusCode(resp.StatusCode) {
+			return resp, nil
+		}
+
+		backoff := c.backoffDuration(attempt, resp)
+		if err != nil {
+			c.logger.With(leveledlog.F("attempt", attempt), leveledlog.F("error", err.Error())).Warn("%s %s failed, retrying in %s", req.Method, req.URL.Path, backoff)
+		} else {
+			c.logger.With(leveledlog.F("attempt", attempt), leveledlog.F("status", resp.StatusCode)).Warn("%s %s failed, retrying in %s", req.Method, req.URL.Path, backoff)
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package fileclient
+
+import (
+	"time"
+)
+
+// TrashItem is a path deleted on a server root that has a trash, it can be restored with RestoreTrash until it is purged
+type TrashItem struct {
+	Id           string
+	OriginalPath string
+	IsDir        bool
+	FileFilter   string
+	DeletedTime  time.Time
+	ClientIP     string
+	UserAgent    string
+	SizeBytes    int64
+}
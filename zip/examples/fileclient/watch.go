@@ -0,0 +1,137 @@
+package fileclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/francoishill/golang-web-dry/logging/leveledlog"
+)
+
+const (
+	WatchEventCreated  = "created"
+	WatchEventModified = "modified"
+	WatchEventDeleted  = "deleted"
+	WatchEventRenamed  = "renamed"
+)
+
+type WatchEvent struct {
+	Type  string
+	Path  string
+	IsDir bool
+	Time  time.Time
+}
+
+/*
+startStream sends a GET request which is cancelled (including reading its body) as soon as stop is closed, stop may
+be nil. The caller must close the body of the returned response once the stream ended, which also stops watching stop.
+*/
+func (c *client) startStream(requestUrl string, stop <-chan struct{}) (*http.Response, error) {
+	req, err := http.NewRequest("GET", requestUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req = req.WithContext(ctx)
+
+	done := make(chan struct{})
+	var doneOnce sync.Once
+	finish := func() {
+		doneOnce.Do(func() { close(done) })
+		cancel()
+	}
+	go func() {
+		select {
+		case <-stop:
+		case <-done:
+		}
+		cancel()
+	}()
+
+	resp, err := c.do(req, requestRetryable|requestStreaming)
+	if err != nil {
+		finish()
+		return nil, err
+	}
+
+	if err = c.checkServerResponse(resp); err != nil {
+		resp.Body.Close()
+		finish()
+		return nil, err
+	}
+
+	resp.Body = &cancelOnCloseBody{resp.Body, finish}
+	return resp, nil
+}
+
+func (c *client) watch(serverUrl, remotePath string, stop <-chan struct{}) (<-chan *WatchEvent, error) {
+	resp, err := c.startStream(serverUrl+"?action=watch&path="+url.QueryEscape(remotePath), stop)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan *WatchEvent)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+
+		scanner := bufio.NewScanner(resp.Body)
+		data := ""
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.HasPrefix(line, "data:") {
+				data += strings.TrimSpace(line[len("data:"):])
+				continue
+			}
+			if line != "" || data == "" {
+				//Event names and heartbeat comments are ignored
+				continue
+			}
+
+			event := &WatchEvent{}
+			err := json.Unmarshal([]byte(data), event)
+			data = ""
+			if err != nil {
+				c.logger.Debug("Ignoring invalid watch event, error: %s", err.Error())
+				continue
+			}
+
+			select {
+			case events <- event:
+			case <-stop:
+				return
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			c.logger.With(leveledlog.F("path", remotePath), leveledlog.F("error", err.Error())).Debug("Watch of remote path ended")
+		}
+	}()
+
+	return events, nil
+}
+
+func (c *client) tail(serverUrl, remotePath string, offset int64, writer io.Writer, stop <-chan struct{}) error {
+	resp, err := c.startStream(serverUrl+"?action=tail&path="+url.QueryEscape(remotePath)+fmt.Sprintf("&offset=%d", offset), stop)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(writer, resp.Body)
+	select {
+	case <-stop:
+		//Reading fails when the body is closed because we stopped
+		return nil
+	default:
+		return err
+	}
+}
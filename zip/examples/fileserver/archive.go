@@ -0,0 +1,47 @@
+package fileserver
+
+import (
+	"net/http"
+	"strings"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+	"github.com/francoishill/golang-web-dry/zip/ziputils"
+)
+
+/*
+handleArchive sends multiple files and directories (the repeated 'path' query parameter) in a single tar stream, each
+of them under its base name, for example:
+
+	GET /?action=archive&path=/srv/logs/a.log&path=/srv/logs/2024&filefilter=*.log
+
+The filefilter only applies to the files inside the directories, the given files are always sent.
+*/
+func (a *appContext) handleArchive(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	CheckError(err)
+
+	paths := []string{}
+	lockRequests := []*pathLockRequest{}
+	for _, path := range r.Form["path"] {
+		path = a.checkPathAllowed(strings.TrimRight(path, ` /\`))
+		paths = append(paths, path)
+		lockRequests = append(lockRequests, readLock(path))
+	}
+	if len(paths) == 0 {
+		panic(badRequest("Cannot find 'path' query parameter..."))
+	}
+
+	defer a.locks.acquire(r.Context().Done(), lockRequests...)()
+
+	//Check all paths before the response starts, so a missing path is still a proper error response
+	for _, path := range paths {
+		_, err := a.storageFor(path).Stat(path)
+		CheckError(err)
+	}
+
+	a.logger.Info("Sending %d paths: %s", len(paths), strings.Join(paths, ", "))
+	walkContext := ziputils.NewDirWalkContext(a.getDirFileFilterPatternFromRequest(r))
+	walkContext.IncludeFile, walkContext.IncludeDir = a.isUnreservedPath, a.isUnreservedPath
+	walkContext.FileSystem = a.fileSystem()
+	ziputils.UploadPathsToHttpResponseWriter(a.logger, w, paths, walkContext)
+}
@@ -0,0 +1,321 @@
+package fileserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+	"github.com/francoishill/golang-web-dry/requests/requestproxyutils"
+	"github.com/francoishill/golang-web-dry/zip/ziputils"
+)
+
+/*
+The audit log (see WithAuditLog) gets one JSON line for every mutating request, whether it succeeded or not,
+for example:
+
+	{"Time":"2024-03-01T10:15:04Z","Operation":"upload","Paths":["/srv/builds/1.2"],"User":"token:5e884898da28","ClientIP":"10.0.0.7","UserAgent":"Go-http-client/1.1","Bytes":52428800,"Files":12,"DurationMs":1530,"Outcome":"success","Status":200}
+
+The file is only ever appended to. Once it would grow beyond its max size it is renamed to <file>.1 (the older
+backups shift to <file>.2 and so on) and a new file is started.
+*/
+
+const (
+	auditOutcomeSuccess = "success"
+	auditOutcomeFailure = "failure"
+)
+
+// The operations written to the audit log, the rest of the requests only read
+var auditedOperations = map[string]bool{
+	"upload": true, "dedup": true, "delete": true, "move": true, "copy": true,
+	"mkdir": true, "chmod": true, "touch": true, "restore": true,
+}
+
+type auditEntry struct {
+	Time       time.Time
+	Operation  string
+	Paths      []string
+	User       string
+	ClientIP   string
+	UserAgent  string
+	Bytes      int64
+	Files      int
+	DurationMs int64
+	DryRun     bool `json:",omitempty"`
+	Outcome    string
+	Status     int
+	Error      string `json:",omitempty"`
+}
+
+// auditLog appends the entries to a file, rotating it by size
+type auditLog struct {
+	mutex      sync.Mutex
+	filePath   string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// auditRecord collects the entry of a single request, it is written once the request is done
+type auditRecord struct {
+	entry     *auditEntry
+	startTime time.Time
+	body      *countingReader
+}
+
+type auditRecordContextKey struct{}
+
+func openAuditLog(filePath string, maxSize int64, maxBackups int) *auditLog {
+	if maxSize > 0 && maxBackups < 1 {
+		//Rotating without a backup would delete the whole log
+		panic(fmt.Sprintf("The audit log rotated at %d bytes must keep at least 1 backup, got %d", maxSize, maxBackups))
+	}
+
+	l := &auditLog{
+		filePath:   filePath,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	l.openFile()
+	return l
+}
+
+func (l *auditLog) openFile() {
+	file, err := os.OpenFile(l.filePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	CheckError(err)
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		CheckError(err)
+	}
+
+	l.file = file
+	l.size = info.Size()
+}
+
+func (l *auditLog) backupPath(index int) string {
+	return fmt.Sprintf("%s.%d", l.filePath, index)
+}
+
+// rotate moves the current file to the first backup, the oldest backup beyond maxBackups is removed
+func (l *auditLog) rotate() {
+	//Also reopened if the rotation fails half way, then the current file simply keeps growing
+	defer l.openFile()
+
+	err := l.file.Close()
+	CheckError(err)
+
+	if err = os.Remove(l.backupPath(l.maxBackups)); err != nil && !os.IsNotExist(err) {
+		CheckError(err)
+	}
+	for i := l.maxBackups - 1; i >= 1; i-- {
+		if err = os.Rename(l.backupPath(i), l.backupPath(i+1)); err != nil && !os.IsNotExist(err) {
+			CheckError(err)
+		}
+	}
+	err = os.Rename(l.filePath, l.backupPath(1))
+	CheckError(err)
+}
+
+func (l *auditLog) write(entry *auditEntry) {
+	line, err := json.Marshal(entry)
+	CheckError(err)
+	line = append(line, '\n')
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var rotateErr interface{}
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		func() {
+			defer func() { rotateErr = recover() }()
+			l.rotate()
+		}()
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	CheckError(err)
+
+	if rotateErr != nil {
+		//The entry is written, but the failed rotation still needs to be reported
+		panic(rotateErr)
+	}
+}
+
+func (l *auditLog) close() {
+	if l == nil {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.file.Close()
+}
+
+// requestUser identifies who made a request, by a fingerprint of its bearer token (never the token itself)
+func (a *appContext) requestUser(r *http.Request) string {
+	if len(a.authTokens) == 0 || !a.isAuthorized(r) {
+		return "anonymous"
+	}
+
+	token := strings.TrimSpace(r.Header.Get("Authorization")[len("Bearer "):])
+	hash := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(hash[:])[:12]
+}
+
+func (a *appContext) newAuditEntry(r *http.Request, operation string, paths ...string) *auditEntry {
+	return &auditEntry{
+		Time:      time.Now().UTC(),
+		Operation: operation,
+		Paths:     paths,
+		User:      a.requestUser(r),
+		ClientIP:  requestproxyutils.IP(r),
+		UserAgent: r.UserAgent(),
+	}
+}
+
+/*
+startAudit starts the audit record of a mutating request of the legacy handler, the returned request carries it (see
+auditRecordOf) and must be finished with finishAudit. Other requests, or all of them without an audit log, are
+returned unchanged.
+*/
+func (a *appContext) startAudit(r *http.Request) *http.Request {
+	if a.audit == nil {
+		return r
+	}
+
+	operation := requestOperation(r)
+	if !auditedOperations[operation] {
+		return r
+	}
+
+	paths := []string{}
+	query := r.URL.Query()
+	for _, name := range []string{"path", "dir", "newpath"} {
+		if value := query.Get(name); value != "" {
+			paths = append(paths, value)
+		}
+	}
+
+	record := &auditRecord{
+		entry:     a.newAuditEntry(r, operation, paths...),
+		startTime: time.Now(),
+		body:      &countingReader{reader: r.Body},
+	}
+	r.Body = &replacedBody{record.body, r.Body}
+	return r.WithContext(context.WithValue(r.Context(), auditRecordContextKey{}, record))
+}
+
+// auditRecordOf returns the audit record started by startAudit, nil if the request is not audited
+func auditRecordOf(r *http.Request) *auditRecord {
+	record, _ := r.Context().Value(auditRecordContextKey{}).(*auditRecord)
+	return record
+}
+
+func (rec *auditRecord) addFiles(count int) {
+	if rec == nil {
+		return
+	}
+	rec.entry.Files += count
+}
+
+func (rec *auditRecord) setDryRun(dryRun bool) {
+	if rec == nil {
+		return
+	}
+	rec.entry.DryRun = dryRun
+}
+
+// fail marks the request as failed, it is called with the error the request is answered with
+func (rec *auditRecord) fail(apiErr *apiError) {
+	if rec == nil {
+		return
+	}
+	rec.entry.Status = apiErr.StatusCode
+	rec.entry.Error = apiErr.Message
+}
+
+// countFiles counts the files written by a tar upload with these options
+func (rec *auditRecord) countFiles(options *ziputils.SaveTarOptions) *ziputils.SaveTarOptions {
+	if rec == nil {
+		return options
+	}
+
+	afterFile := options.AfterFile
+	options.AfterFile = func(destinationPath string, writtenSize int64) {
+		if afterFile != nil {
+			afterFile(destinationPath, writtenSize)
+		}
+		rec.entry.Files++
+	}
+	return options
+}
+
+// finishAudit writes the audit record of the request (if it has one), a failing audit log never fails the request
+func (a *appContext) finishAudit(r *http.Request) {
+	record := auditRecordOf(r)
+	if record == nil {
+		return
+	}
+
+	entry := record.entry
+	entry.Bytes = record.body.count
+	entry.DurationMs = int64(time.Now().Sub(record.startTime) / time.Millisecond)
+	if entry.Status == 0 {
+		entry.Status = http.StatusOK
+	}
+	entry.Outcome = auditOutcomeSuccess
+	if entry.Status >= http.StatusBadRequest {
+		entry.Outcome = auditOutcomeFailure
+	}
+
+	a.writeAuditEntry(entry)
+}
+
+// auditBatchOperation writes the audit entry of a single operation of a batch request
+func (a *appContext) auditBatchOperation(r *http.Request, op *batchOperation, result *batchResult, startTime time.Time) {
+	if a.audit == nil {
+		return
+	}
+
+	operation := strings.ToLower(op.Op)
+	if operation == "stats" {
+		return
+	}
+
+	paths := []string{op.Path}
+	if op.NewPath != "" {
+		paths = append(paths, op.NewPath)
+	}
+
+	entry := a.newAuditEntry(r, "batch:"+operation, paths...)
+	entry.Bytes = int64(len(op.Data))
+	entry.DurationMs = int64(time.Now().Sub(startTime) / time.Millisecond)
+	entry.Status = http.StatusOK
+	entry.Outcome = auditOutcomeSuccess
+	if !result.Success {
+		entry.Status = result.status
+		entry.Outcome = auditOutcomeFailure
+		entry.Error = result.Error
+	}
+	a.writeAuditEntry(entry)
+}
+
+func (a *appContext) writeAuditEntry(entry *auditEntry) {
+	defer func() {
+		if r := recover(); r != nil {
+			a.logger.Error("Cannot write the audit log entry of '%s' on %v: %+v", entry.Operation, entry.Paths, r)
+		}
+	}()
+	a.audit.write(entry)
+}
@@ -0,0 +1,74 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAuditLogRotation(t *testing.T) {
+	Convey("Testing the rotation of the audit log", t, func() {
+		dir, err := ioutil.TempDir("", "fileserver-audit")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		filePath := filepath.Join(dir, "audit.log")
+		operationsOf := func(path string) []string {
+			content, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+
+			operations := []string{}
+			for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+				entry := &auditEntry{}
+				So(json.Unmarshal([]byte(line), entry), ShouldBeNil)
+				operations = append(operations, entry.Operation)
+			}
+			return operations
+		}
+
+		//Every entry is bigger than the max size, so each one is rotated into the backups
+		l := openAuditLog(filePath, 10, 2)
+		defer l.close()
+
+		Convey("The backups should shift and the oldest beyond maxBackups should be removed", func() {
+			for _, operation := range []string{"first", "second", "third", "fourth"} {
+				l.write(&auditEntry{Operation: operation})
+			}
+
+			So(operationsOf(filePath), ShouldResemble, []string{"fourth"})
+			So(operationsOf(l.backupPath(1)), ShouldResemble, []string{"third"})
+			So(operationsOf(l.backupPath(2)), ShouldResemble, []string{"second"})
+			_, err := os.Stat(l.backupPath(3))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("Rotating without backups should be rejected instead of deleting the log", func() {
+			So(func() { openAuditLog(filepath.Join(dir, "other.log"), 10, 0) }, ShouldPanic)
+
+			unrotated := openAuditLog(filepath.Join(dir, "unrotated.log"), 0, 0)
+			defer unrotated.close()
+			unrotated.write(&auditEntry{Operation: "first"})
+			unrotated.write(&auditEntry{Operation: "second"})
+			So(operationsOf(filepath.Join(dir, "unrotated.log")), ShouldResemble, []string{"first", "second"})
+		})
+
+		Convey("A failed rotation should keep writing to the current file", func() {
+			l.write(&auditEntry{Operation: "first"})
+
+			//The oldest backup cannot be removed while it is a directory with a file in it
+			So(os.MkdirAll(filepath.Join(l.backupPath(2), "blocked"), 0755), ShouldBeNil)
+			So(func() { l.write(&auditEntry{Operation: "second"}) }, ShouldPanic)
+			So(operationsOf(filePath), ShouldResemble, []string{"first", "second"})
+
+			So(os.RemoveAll(l.backupPath(2)), ShouldBeNil)
+			l.write(&auditEntry{Operation: "third"})
+			So(operationsOf(filePath), ShouldResemble, []string{"third"})
+			So(operationsOf(l.backupPath(1)), ShouldResemble, []string{"first", "second"})
+		})
+	})
+}
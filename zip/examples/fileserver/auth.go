@@ -0,0 +1,42 @@
+package fileserver
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+const errCodeUnauthorized = "UNAUTHORIZED"
+
+// isAuthorized is true if no tokens are configured or the request has one of them as its bearer token
+func (a *appContext) isAuthorized(r *http.Request) bool {
+	if len(a.authTokens) == 0 {
+		return true
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return false
+	}
+	requestToken := []byte(strings.TrimSpace(authorization[len("Bearer "):]))
+
+	authorized := false
+	for _, token := range a.authTokens {
+		//Compare all of them in constant time, so the timing does not reveal which (or how much of a) token matched
+		if subtle.ConstantTimeCompare(requestToken, []byte(token)) == 1 {
+			authorized = true
+		}
+	}
+	return authorized
+}
+
+// authenticate is the middleware refusing the requests without a valid bearer token (see WithAuthTokens) with 401 Unauthorized
+func (a *appContext) authenticate(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if !a.isAuthorized(r) {
+		a.logger.Error("Refusing unauthorized %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="fileserver"`)
+		writeApiError(w, newApiError(http.StatusUnauthorized, errCodeUnauthorized, "Missing or invalid bearer token"))
+		return
+	}
+	next(w, r)
+}
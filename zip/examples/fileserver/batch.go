@@ -0,0 +1,200 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+)
+
+const maxBatchRequestSize = 64 * 1024 * 1024
+
+type batchRequest struct {
+	StopOnError bool
+	Operations  []*batchOperation
+}
+
+type batchOperation struct {
+	Op         string
+	Path       string
+	NewPath    string `json:",omitempty"`
+	FileFilter string `json:",omitempty"`
+	Mode       string `json:",omitempty"`
+	Parents    bool   `json:",omitempty"`
+	Data       []byte `json:",omitempty"`
+}
+
+type batchResult struct {
+	Op      string
+	Path    string
+	Success bool
+	Skipped bool       `json:",omitempty"`
+	Error   string     `json:",omitempty"`
+	Code    string     `json:",omitempty"`
+	Stats   *pathStats `json:",omitempty"`
+
+	status int //the http status of a failed operation, only for the audit log
+}
+
+func (o *batchOperation) fileMode(defaultMode os.FileMode) os.FileMode {
+	if o.Mode == "" {
+		return defaultMode
+	}
+
+	mode, err := strconv.ParseUint(o.Mode, 8, 32)
+	if err != nil {
+		panic(badRequest("Operation 'Mode' must be an octal file mode, got '%s'", o.Mode))
+	}
+	return os.FileMode(mode)
+}
+
+// validate panics with a 400 error if a field the operation needs is missing or invalid, it is checked before taking any lock
+func (o *batchOperation) validate() {
+	if o.Path == "" {
+		panic(badRequest("Operation is missing 'Path'"))
+	}
+
+	switch strings.ToLower(o.Op) {
+	case "stats", "delete", "touch":
+		break
+	case "move", "copy":
+		if o.NewPath == "" {
+			panic(badRequest("Operation '%s' is missing 'NewPath'", strings.ToLower(o.Op)))
+		}
+		break
+	case "chmod":
+		if o.Mode == "" {
+			panic(badRequest("Operation 'chmod' is missing 'Mode'"))
+		}
+		o.fileMode(0)
+		break
+	case "mkdir", "upload":
+		o.fileMode(0)
+		break
+	default:
+		panic(badRequest("Unsupported batch operation '%s'", o.Op))
+	}
+}
+
+func (o *batchOperation) lockRequests() []*pathLockRequest {
+	switch strings.ToLower(o.Op) {
+	case "stats":
+		return []*pathLockRequest{readLock(o.Path)}
+	case "copy":
+		return []*pathLockRequest{readLock(o.Path), writeLock(o.NewPath)}
+	case "move":
+		return []*pathLockRequest{writeLock(o.Path), writeLock(o.NewPath)}
+	default:
+		return []*pathLockRequest{writeLock(o.Path)}
+	}
+}
+
+func (a *appContext) runBatchOperation(r *http.Request, op *batchOperation) (result *batchResult) {
+	result = &batchResult{
+		Op:   op.Op,
+		Path: op.Path,
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			a.logger.Error("Batch operation '%s' on path '%s' failed: %+v", op.Op, op.Path, r)
+			apiErr := toApiError(r)
+			result.Success = false
+			result.Error = apiErr.Message
+			result.Code = apiErr.Code
+			result.status = apiErr.StatusCode
+		}
+	}()
+
+	op.validate()
+	a.checkPathAllowed(op.Path)
+	if op.NewPath != "" {
+		a.checkPathAllowed(op.NewPath)
+	}
+	defer a.locks.acquire(r.Context().Done(), op.lockRequests()...)()
+
+	switch strings.ToLower(op.Op) {
+	case "stats":
+		result.Stats = a.getStats(op.Path)
+		break
+	case "delete":
+		deleteResult := a.deletePath(r, op.Path, op.FileFilter, nil)
+		if len(deleteResult.Errors) > 0 {
+			panic(newApiError(http.StatusInternalServerError, errCodeInternal, "Unable to delete %d paths, the first error: %s", len(deleteResult.Errors), deleteResult.Errors[0].Error))
+		}
+		break
+	case "move":
+		a.movePath(op.Path, op.NewPath)
+		break
+	case "copy":
+		a.copyPath(op.Path, op.NewPath, op.FileFilter)
+		break
+	case "mkdir":
+		a.makeDirectory(op.Path, op.Parents, op.fileMode(0755))
+		break
+	case "chmod":
+		a.changeMode(op.Path, op.fileMode(0))
+		break
+	case "touch":
+		a.touchFile(op.Path)
+		break
+	case "upload":
+		a.newUploadPreflight(op.Path).requireFile(op.Path, int64(len(op.Data)))
+		a.writeFile(op.Path, op.Data, op.fileMode(0644))
+		break
+	default:
+		panic(badRequest("Unsupported batch operation '%s'", op.Op))
+	}
+
+	result.Success = true
+	return
+}
+
+func (a *appContext) fireBatchOperationHook(r *http.Request, op *batchOperation) {
+	switch strings.ToLower(op.Op) {
+	case "upload":
+		a.hooks.fire(newHookEvent(r, hookEventUpload, int64(len(op.Data)), op.Path))
+		break
+	case "delete":
+		a.hooks.fire(newHookEvent(r, hookEventDelete, 0, op.Path))
+		break
+	case "move":
+		a.hooks.fire(newHookEvent(r, hookEventMove, 0, op.Path, op.NewPath))
+		break
+	}
+}
+
+func (a *appContext) handleBatch(w http.ResponseWriter, r *http.Request) {
+	batch := &batchRequest{}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchRequestSize)).Decode(batch)
+	CheckError(err)
+
+	a.logger.Info("Running batch of %d operations", len(batch.Operations))
+
+	results := []*batchResult{}
+	failed := false
+	for _, op := range batch.Operations {
+		if failed && batch.StopOnError {
+			results = append(results, &batchResult{Op: op.Op, Path: op.Path, Skipped: true})
+			continue
+		}
+
+		startTime := time.Now()
+		result := a.runBatchOperation(r, op)
+		a.auditBatchOperation(r, op, result, startTime)
+		if !result.Success {
+			failed = true
+		} else {
+			a.fireBatchOperationHook(r, op)
+		}
+		results = append(results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(results)
+	CheckError(err)
+}
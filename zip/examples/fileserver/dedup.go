@@ -0,0 +1,331 @@
+package fileserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+	"github.com/francoishill/golang-web-dry/osutils"
+	Tokens "github.com/francoishill/golang-web-dry/tokens/randomtokens"
+)
+
+const (
+	blobsDirName = ".fileserver-blobs"
+
+	maxDedupManifestSize = 64 * 1024 * 1024
+)
+
+// dedupManifestFile is the hash of a file the client wants to upload, Path is relative to the uploaded directory
+type dedupManifestFile struct {
+	Path string
+	Hash string
+	Mode os.FileMode
+	Size int64
+}
+
+type dedupManifest struct {
+	Files []*dedupManifestFile
+}
+
+type dedupResult struct {
+	Linked  []string
+	Missing []string
+}
+
+func (rt *root) blobsDir() string {
+	return filepath.Join(rt.Path, blobsDirName)
+}
+
+/*
+blobPath is where a root with dedup=1 stores a file content. Every content is stored once, keyed by its sha256 hash:
+
+	<root>/.fileserver-blobs/<first 2 hash characters>/<sha256>
+
+The files in the visible tree are hard links to these blobs, so the link count of a blob is its reference count
+(a blob with a single link is only referenced by the store and is garbage collected). Because the linked files
+share one inode, they also share the mode and modification time of the blob. A file is only linked if it has the
+permission bits of the blob (otherwise it keeps its own copy) and it takes the mtime of the blob, which is the mtime
+of the first file with that content. The server never writes into a file of a dedup root in place and changing the
+mode or mtime of a file first detaches it (see detachFile), so the other links are never changed.
+*/
+func (rt *root) blobPath(hash string) string {
+	return filepath.Join(rt.blobsDir(), hash[:2], hash)
+}
+
+// findDedupRoot returns the root of path if it stores its files in a blob store, otherwise nil
+func (a *appContext) findDedupRoot(path string) *root {
+	rt := a.findRoot(path)
+	if rt == nil || !rt.DedupEnabled {
+		return nil
+	}
+	return rt
+}
+
+func isValidBlobHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+func hashFile(path string) string {
+	file, err := os.Open(path)
+	CheckError(err)
+	defer file.Close()
+
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, file)
+	CheckError(err)
+
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// replaceWithLink atomically replaces destPath (if it exists) with a hard link to existingPath
+func replaceWithLink(existingPath, destPath string) {
+	tempPath := filepath.Join(filepath.Dir(destPath), "."+filepath.Base(destPath)+".link-"+Tokens.GenerateRandomAlphaNumericString(8))
+	err := os.Link(existingPath, tempPath)
+	CheckError(err)
+
+	err = os.Rename(tempPath, destPath)
+	if err != nil {
+		os.Remove(tempPath)
+	}
+	CheckError(err)
+}
+
+// ingestFile moves the content of path into the blob store of rt, path becomes a hard link to the (possibly already existing) blob
+func (a *appContext) ingestFile(rt *root, path string) {
+	info, err := os.Lstat(path)
+	CheckError(err)
+	if !info.Mode().IsRegular() {
+		return
+	}
+
+	hash := hashFile(path)
+	blobPath := rt.blobPath(hash)
+
+	a.blobMutex.Lock()
+	defer a.blobMutex.Unlock()
+
+	if blobInfo, err := os.Stat(blobPath); err == nil {
+		if os.SameFile(info, blobInfo) {
+			return
+		}
+		if blobInfo.Mode().Perm() != info.Mode().Perm() {
+			//Linking would change the mode of the file, so it keeps its own copy
+			a.logger.Debug("Not deduplicating %s, its mode %s differs from blob %s", path, info.Mode().Perm(), filepath.Base(blobPath))
+			return
+		}
+		a.logger.Debug("Deduplicated %s with blob %s", path, filepath.Base(blobPath))
+		replaceWithLink(blobPath, path)
+		return
+	}
+
+	err = os.MkdirAll(filepath.Dir(blobPath), 0755)
+	CheckError(err)
+	err = os.Link(path, blobPath)
+	CheckError(err)
+}
+
+// ingestDirectory ingests all the files inside dir (or dir itself if it is a file)
+func (a *appContext) ingestDirectory(rt *root, dir string) {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && a.isReservedPath(path) {
+			return filepath.SkipDir
+		}
+		if info.Mode().IsRegular() {
+			a.ingestFile(rt, path)
+		}
+		return nil
+	})
+	CheckError(err)
+}
+
+// detachFile gives path its own copy of its content, so it can be changed (for example chmod) without changing the other links to its blob
+func detachFile(path string) {
+	info, err := os.Stat(path)
+	CheckError(err)
+
+	osutils.CopyFile(path, path)
+	err = os.Chtimes(path, info.ModTime(), info.ModTime())
+	CheckError(err)
+}
+
+// detachDirectory detaches all the linked files inside dir (or dir itself if it is a file)
+func (a *appContext) detachDirectory(dir string) {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && a.isReservedPath(path) {
+			return filepath.SkipDir
+		}
+		if info.Mode().IsRegular() && osutils.GetHardLinkCount(path, info) > 1 {
+			detachFile(path)
+		}
+		return nil
+	})
+	CheckError(err)
+}
+
+/*
+moveWithDedup calls move to move oldPath to newPath on the local disk. Files leaving a dedup root are first detached
+from its blobs, the roots they move into would otherwise write into the blobs in place (for example a tar upload or
+chmod). Files entering a dedup root are ingested into its blob store afterwards.
+*/
+func (a *appContext) moveWithDedup(oldPath, newPath string, move func()) {
+	oldRoot := a.findDedupRoot(oldPath)
+	newRoot := a.findDedupRoot(newPath)
+
+	if oldRoot != nil && oldRoot != newRoot {
+		a.detachDirectory(oldPath)
+	}
+	move()
+	if newRoot != nil && newRoot != oldRoot {
+		a.ingestDirectory(newRoot, newPath)
+	}
+}
+
+/*
+linkBlob replaces destPath with a link to the blob of hash, returning false if there is no blob with that content
+and mode. A missing file is uploaded normally, with another mode it then keeps its own copy (see ingestFile).
+*/
+func (a *appContext) linkBlob(rt *root, hash string, mode os.FileMode, destPath string) bool {
+	a.blobMutex.Lock()
+	defer a.blobMutex.Unlock()
+
+	blobPath := rt.blobPath(hash)
+	blobInfo, err := os.Stat(blobPath)
+	if os.IsNotExist(err) {
+		return false
+	}
+	CheckError(err)
+	if blobInfo.Mode().Perm() != mode.Perm() {
+		return false
+	}
+
+	if destInfo, err := os.Stat(destPath); err == nil && os.SameFile(destInfo, blobInfo) {
+		return true
+	}
+
+	err = os.MkdirAll(filepath.Dir(destPath), 0755)
+	CheckError(err)
+	replaceWithLink(blobPath, destPath)
+	return true
+}
+
+func (a *appContext) collectBlobGarbageOfRoot(rt *root) {
+	defer func() {
+		if r := recover(); r != nil {
+			a.logger.Error("Unable to collect the unreferenced blobs of root '%s': %+v", rt.Name, r)
+		}
+	}()
+
+	a.collectBlobGarbage(rt)
+}
+
+// collectBlobGarbage removes the blobs of rt that are no longer linked from the visible tree (or the trash)
+func (a *appContext) collectBlobGarbage(rt *root) {
+	a.blobMutex.Lock()
+	defer a.blobMutex.Unlock()
+
+	removedCount := 0
+	removedBytes := int64(0)
+	err := filepath.Walk(rt.blobsDir(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || osutils.GetHardLinkCount(path, info) > 1 {
+			return nil
+		}
+
+		if err = os.Remove(path); err != nil {
+			return err
+		}
+		removedCount++
+		removedBytes += info.Size()
+		return nil
+	})
+	CheckError(err)
+
+	if removedCount > 0 {
+		a.logger.Info("Removed %d unreferenced blobs (%d bytes) from root '%s'", removedCount, removedBytes, rt.Name)
+		a.usageChanged(rt.Path)
+	}
+}
+
+func getDedupDestinationPath(basePath string, isDir bool, file *dedupManifestFile) string {
+	if !isDir {
+		if file.Path != "" {
+			panic(badRequest("The manifest of a single file upload must have an empty 'Path', got '%s'", file.Path))
+		}
+		return basePath
+	}
+
+	destPath := filepath.Join(basePath, filepath.FromSlash(file.Path))
+	if file.Path == "" || !isSameOrChildPath(filepath.Clean(basePath), destPath) || destPath == filepath.Clean(basePath) {
+		panic(badRequest("Invalid manifest path '%s'", file.Path))
+	}
+	return destPath
+}
+
+/*
+handleDedupUpload is the first step of a deduplicated upload. The client sends the hashes of its files, every file
+whose content is already in the blob store is linked into place and the response lists the Missing files, which
+the client then uploads normally (a tar of only those files). The upload hook only fires here if nothing is missing.
+*/
+func (a *appContext) handleDedupUpload(w http.ResponseWriter, r *http.Request) {
+	path, isDir := a.getFileOrFolderFromRequest(r)
+
+	rt := a.findDedupRoot(path)
+	if rt == nil {
+		panic(badRequest("Path '%s' is not inside a root with dedup enabled", path))
+	}
+
+	defer a.locks.acquire(r.Context().Done(), writeLock(path))()
+
+	manifest := &dedupManifest{}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxDedupManifestSize)).Decode(manifest)
+	CheckError(err)
+
+	a.logger.Info("Deduplicating %d files for %s", len(manifest.Files), path)
+
+	result := &dedupResult{Linked: []string{}, Missing: []string{}}
+	for _, file := range manifest.Files {
+		file.Hash = strings.ToLower(file.Hash)
+		if !isValidBlobHash(file.Hash) {
+			panic(badRequest("Invalid sha256 hash '%s' of manifest path '%s'", file.Hash, file.Path))
+		}
+
+		destPath := getDedupDestinationPath(path, isDir, file)
+		a.checkPathAllowed(destPath)
+
+		if a.linkBlob(rt, file.Hash, file.Mode, destPath) {
+			result.Linked = append(result.Linked, file.Path)
+		} else {
+			result.Missing = append(result.Missing, file.Path)
+		}
+	}
+
+	auditRecordOf(r).addFiles(len(result.Linked))
+	if len(result.Missing) == 0 {
+		a.hooks.fire(newHookEvent(r, hookEventUpload, 0, path))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(result)
+	CheckError(err)
+}
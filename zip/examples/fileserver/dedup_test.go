@@ -0,0 +1,126 @@
+package fileserver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/francoishill/golang-web-dry/logging/leveledlog"
+)
+
+func TestDedupBlobs(t *testing.T) {
+	Convey("Testing the blob store of a dedup root", t, func() {
+		dir, err := ioutil.TempDir("", "fileserver-dedup")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		rt := &root{Name: "dedup", Path: dir, DedupEnabled: true}
+		a := &appContext{logger: leveledlog.Discard(), roots: []*root{rt}}
+
+		modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		writeFile := func(relPath, content string, fileModTime time.Time) string {
+			path := filepath.Join(dir, relPath)
+			So(os.MkdirAll(filepath.Dir(path), 0755), ShouldBeNil)
+			So(ioutil.WriteFile(path, []byte(content), 0644), ShouldBeNil)
+			So(os.Chtimes(path, fileModTime, fileModTime), ShouldBeNil)
+			return path
+		}
+		isSameFile := func(path1, path2 string) bool {
+			info1, err := os.Stat(path1)
+			So(err, ShouldBeNil)
+			info2, err := os.Stat(path2)
+			So(err, ShouldBeNil)
+			return os.SameFile(info1, info2)
+		}
+		modTimeOf := func(path string) time.Time {
+			info, err := os.Stat(path)
+			So(err, ShouldBeNil)
+			return info.ModTime()
+		}
+
+		Convey("replaceWithLink should replace an existing file without leaving the temporary link behind", func() {
+			existingPath := writeFile("a.txt", "a", modTime)
+			destPath := writeFile("b.txt", "b", modTime)
+
+			replaceWithLink(existingPath, destPath)
+
+			So(isSameFile(existingPath, destPath), ShouldBeTrue)
+			entries, err := ioutil.ReadDir(dir)
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 2)
+		})
+
+		Convey("Files with the same content should share a blob whatever their mtime, with another mode they should not", func() {
+			path1 := writeFile("1/a.txt", "same", modTime)
+			path2 := writeFile("2/a.txt", "same", modTime.Add(time.Hour))
+			path3 := writeFile("3/a.txt", "same", modTime)
+			So(os.Chmod(path3, 0600), ShouldBeNil)
+
+			a.ingestFile(rt, path1)
+			a.ingestFile(rt, path2)
+			a.ingestFile(rt, path3)
+
+			So(isSameFile(path1, path2), ShouldBeTrue)
+			So(isSameFile(path1, rt.blobPath(hashFile(path1))), ShouldBeTrue)
+			So(modTimeOf(path2).Equal(modTime), ShouldBeTrue)
+			So(isSameFile(path1, path3), ShouldBeFalse)
+		})
+
+		Convey("Touching or changing the mode of a linked file should not change the other links", func() {
+			path1 := writeFile("1/a.txt", "same", modTime)
+			path2 := writeFile("2/a.txt", "same", modTime)
+			path3 := writeFile("3/a.txt", "same", modTime)
+			a.ingestFile(rt, path1)
+			a.ingestFile(rt, path2)
+			a.ingestFile(rt, path3)
+
+			a.touchFile(path1)
+			a.changeMode(path3, 0600)
+
+			So(isSameFile(path1, path2), ShouldBeFalse)
+			So(modTimeOf(path1).After(modTime), ShouldBeTrue)
+			So(isSameFile(path3, path2), ShouldBeFalse)
+			So(modTimeOf(path2).Equal(modTime), ShouldBeTrue)
+
+			info, err := os.Stat(path2)
+			So(err, ShouldBeNil)
+			So(info.Mode().Perm(), ShouldEqual, os.FileMode(0644))
+		})
+
+		Convey("linkBlob should link the blob of the hash if it has the mode", func() {
+			path1 := writeFile("1/a.txt", "same", modTime)
+			a.ingestFile(rt, path1)
+			hash := hashFile(path1)
+
+			destPath := filepath.Join(dir, "linked", "a.txt")
+			So(a.linkBlob(rt, hash, 0644, destPath), ShouldBeTrue)
+			So(isSameFile(path1, destPath), ShouldBeTrue)
+
+			Convey("Unknown content or another mode should not be linked", func() {
+				So(a.linkBlob(rt, hashFile(writeFile("new.txt", "new", modTime)), 0644, filepath.Join(dir, "x.txt")), ShouldBeFalse)
+				So(a.linkBlob(rt, hash, 0600, filepath.Join(dir, "y.txt")), ShouldBeFalse)
+			})
+		})
+
+		Convey("Garbage collection should only remove the blobs that are no longer linked", func() {
+			path1 := writeFile("1/a.txt", "kept", modTime)
+			path2 := writeFile("2/b.txt", "removed", modTime)
+			a.ingestFile(rt, path1)
+			a.ingestFile(rt, path2)
+			keptBlob := rt.blobPath(hashFile(path1))
+			removedBlob := rt.blobPath(hashFile(path2))
+
+			So(os.Remove(path2), ShouldBeNil)
+			a.collectBlobGarbage(rt)
+
+			_, err := os.Stat(keptBlob)
+			So(err, ShouldBeNil)
+			_, err = os.Stat(removedBlob)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,128 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"syscall"
+
+	"github.com/francoishill/golang-web-dry/zip/ziputils"
+)
+
+const (
+	errCodeBadRequest          = "BAD_REQUEST"
+	errCodePermissionDenied    = "PERMISSION_DENIED"
+	errCodeNotFound            = "NOT_FOUND"
+	errCodeAlreadyExists       = "ALREADY_EXISTS"
+	errCodeNotEmpty            = "NOT_EMPTY"
+	errCodeConflict            = "CONFLICT"
+	errCodeInvalidArchive      = "INVALID_ARCHIVE"
+	errCodeTooLarge            = "TOO_LARGE"
+	errCodeQuotaExceeded       = "QUOTA_EXCEEDED"
+	errCodeInsufficientStorage = "INSUFFICIENT_STORAGE"
+	errCodeLocked              = "LOCKED"
+	errCodeInternal            = "INTERNAL"
+)
+
+// apiError is panicked by the handlers (or converted from a recovered panic) and written to the client as JSON
+type apiError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func newApiError(statusCode int, code, msg string, args ...interface{}) *apiError {
+	return &apiError{
+		StatusCode: statusCode,
+		Code:       code,
+		Message:    fmt.Sprintf(msg, args...),
+	}
+}
+
+func badRequest(msg string, args ...interface{}) *apiError {
+	return newApiError(http.StatusBadRequest, errCodeBadRequest, msg, args...)
+}
+
+func toApiError(recovered interface{}) *apiError {
+	err, isErr := recovered.(error)
+	if !isErr {
+		return newApiError(http.StatusInternalServerError, errCodeInternal, "%+v", recovered)
+	}
+
+	if e, ok := err.(*apiError); ok {
+		return e
+	}
+
+	msg := err.Error()
+	switch {
+	case os.IsNotExist(err):
+		return newApiError(http.StatusNotFound, errCodeNotFound, "%s", msg)
+	case os.IsPermission(err):
+		return newApiError(http.StatusForbidden, errCodePermissionDenied, "%s", msg)
+	case os.IsExist(err):
+		return newApiError(http.StatusConflict, errCodeAlreadyExists, "%s", msg)
+	case isSyscallError(err, syscall.ENOTEMPTY):
+		return newApiError(http.StatusConflict, errCodeNotEmpty, "%s", msg)
+	case isSyscallError(err, syscall.ENOTDIR), isSyscallError(err, syscall.EISDIR):
+		return newApiError(http.StatusConflict, errCodeConflict, "%s", msg)
+	case isSyscallError(err, syscall.ENOSPC):
+		return newApiError(http.StatusInsufficientStorage, errCodeInsufficientStorage, "%s", msg)
+	case isSyscallError(err, syscall.EINVAL):
+		return newApiError(http.StatusBadRequest, errCodeBadRequest, "%s", msg)
+	case err == ziputils.ErrTarValidationFailed:
+		return newApiError(http.StatusBadRequest, errCodeInvalidArchive, "%s", msg)
+	}
+
+	switch err.(type) {
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return newApiError(http.StatusBadRequest, errCodeBadRequest, "Invalid JSON: %s", msg)
+	case *ziputils.InvalidTarEntryError:
+		return newApiError(http.StatusBadRequest, errCodeInvalidArchive, "%s", msg)
+	case *http.MaxBytesError:
+		return newApiError(http.StatusRequestEntityTooLarge, errCodeTooLarge, "%s", msg)
+	}
+
+	return newApiError(http.StatusInternalServerError, errCodeInternal, "%s", msg)
+}
+
+func isSyscallError(err error, errno syscall.Errno) bool {
+	switch e := err.(type) {
+	case *os.PathError:
+		return e.Err == errno
+	case *os.LinkError:
+		return e.Err == errno
+	case *os.SyscallError:
+		return e.Err == errno
+	}
+	return err == errno
+}
+
+func writeApiError(w http.ResponseWriter, e *apiError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.StatusCode)
+	json.NewEncoder(w).Encode(e)
+}
+
+// catchPanicAsError turns a panic (like of CheckError) into the error returned by the exported functions
+func catchPanicAsError(errPointer *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	switch t := r.(type) {
+	case error:
+		*errPointer = t
+	case string:
+		*errPointer = errors.New(t)
+	default:
+		*errPointer = fmt.Errorf("%#v", t)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/dustin/go-humanize"
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+	"github.com/francoishill/golang-web-dry/logging/leveledlog"
+	"github.com/francoishill/golang-web-dry/logging/leveledlog/golog"
+	"github.com/francoishill/golang-web-dry/zip/examples/cliconfig"
+	"github.com/francoishill/golang-web-dry/zip/examples/fileserver"
+	"github.com/ian-kent/go-log/appenders"
+	"github.com/ian-kent/go-log/layout"
+)
+
+var (
+	DISPLAY_VERSION = "0.0.1"
+)
+
+const envVarPrefix = "FILESERVER_"
+
+// cliExtendedContext reads the flags from the command line, the FILESERVER_* environment variables or the --config file
+type cliExtendedContext struct {
+	*cli.Context
+	settings *cliconfig.Lookup
+}
+
+func newCliExtendedContext(c *cli.Context) *cliExtendedContext {
+	settings := &cliconfig.Lookup{Context: c, EnvPrefix: envVarPrefix}
+	if configFile := settings.String("config"); configFile != "" {
+		config := cliconfig.Values{}
+		if !cliconfig.LoadFile(configFile, &config) {
+			panic("Config file '" + configFile + "' does not exist")
+		}
+		settings.Config = config
+	}
+	return &cliExtendedContext{c, settings}
+}
+
+func (c *cliExtendedContext) RequireGlobalString(flagName string) string {
+	return c.settings.RequireString(flagName)
+}
+
+// getLogger logs to the console and to a rolling log file, the returned func closes the file
+func getLogger() (leveledlog.Logger, func()) {
+	layoutToUse := layout.Pattern("%d [%p] %m") //date, level/priority, message
+
+	rollingFileAppender := appenders.RollingFile("rolling-log.log", true)
+	rollingFileAppender.MaxBackupIndex = 5
+	rollingFileAppender.MaxFileSize = 20 * 1024 * 1024 // 20 MB
+	rollingFileAppender.SetLayout(layoutToUse)
+
+	consoleAppender := appenders.Console()
+	consoleAppender.SetLayout(layoutToUse)
+
+	logger := leveledlog.Multi(
+		golog.NewFromAppender(rollingFileAppender),
+		golog.NewFromAppender(consoleAppender),
+	)
+
+	closeLogFile := func() {
+		if closer, ok := interface{}(rollingFileAppender).(interface {
+			Close()
+		}); ok {
+			closer.Close()
+		}
+	}
+
+	return logger, closeLogFile
+}
+
+// parseByteSize parses a human readable size like "512KiB" or "10MB", an empty string is 0
+func parseByteSize(flagName, value string) int64 {
+	if value == "" {
+		return 0
+	}
+
+	size, err := humanize.ParseBytes(value)
+	if err != nil {
+		panic("Flag '" + flagName + "' must be a byte size like 10MiB, got '" + value + "'")
+	}
+	return int64(size)
+}
+
+func MainAction(c *cli.Context) {
+	c2 := newCliExtendedContext(c)
+
+	port := c2.RequireGlobalString("port")
+
+	defaultLogger, closeLogFile := getLogger()
+	defer closeLogFile()
+
+	if configFile := c2.settings.String("config"); configFile != "" {
+		defaultLogger.Info("Loaded the config file %s", configFile)
+	}
+
+	options := []fileserver.Option{
+		fileserver.WithAuthTokens(c2.settings.StringSlice("auth-token")...),
+		fileserver.WithLockPolicy(c2.settings.String("lock-policy"), c2.settings.Duration("lock-timeout")),
+		fileserver.WithRateLimits(
+			parseByteSize("rate-limit-request", c2.settings.String("rate-limit-request")),
+			parseByteSize("rate-limit-global", c2.settings.String("rate-limit-global"))),
+	}
+	for _, rootFlag := range c2.settings.StringSlice("root") {
+		options = append(options, fileserver.WithRoot(rootFlag))
+	}
+	if hooksFile := c2.settings.String("hooks"); hooksFile != "" {
+		options = append(options, fileserver.WithHooksFile(hooksFile))
+	}
+	if auditLogFile := c2.settings.String("audit-log"); auditLogFile != "" {
+		maxSize := parseByteSize("audit-log-max-size", c2.settings.String("audit-log-max-size"))
+		options = append(options, fileserver.WithAuditLog(auditLogFile, maxSize, c2.settings.Int("audit-log-max-backups")))
+	}
+
+	h, err := fileserver.New(defaultLogger, options...)
+	CheckError(err)
+
+	server := &http.Server{
+		Addr:    ":" + port,
+		Handler: h,
+	}
+
+	go func() {
+		defaultLogger.Info("Now serving FileServer on port %s (process id is %d)", port, os.Getpid())
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			defaultLogger.Error("%s", err)
+			closeLogFile()
+			os.Exit(1)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	signal.Stop(signals)
+
+	defaultLogger.Info("Received %s", sig)
+	h.Shutdown(server, c2.settings.Duration("shutdown-timeout"))
+	defaultLogger.Info("FileServer stopped")
+}
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "copyserver"
+	app.Usage = "A http server to allow clients to upload and download files. Every flag can also be set with a FILESERVER_<FLAG> environment variable (for example FILESERVER_LOCK_TIMEOUT, repeated values separated by ';') or in the --config file"
+	app.Action = MainAction
+	app.Version = DISPLAY_VERSION
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "config",
+			Value: "",
+			Usage: "Path to a YAML file with flag values by flag name (for example 'port: 5003' or 'root: [builds=/srv/builds]'), ${VAR} environment variables in it are expanded",
+		},
+		cli.StringSliceFlag{
+			Name:  "auth-token",
+			Value: &cli.StringSlice{},
+			Usage: "Require this bearer token (in the Authorization header) for all requests, can be repeated to accept several tokens",
+		},
+		cli.StringFlag{
+			Name:  "port,p",
+			Value: "60878",
+			Usage: "The port of the server",
+		},
+		cli.DurationFlag{
+			Name:  "shutdown-timeout",
+			Value: 30 * time.Second,
+			Usage: "How long to wait for in-flight transfers to finish after SIGINT/SIGTERM before aborting them",
+		},
+		cli.StringFlag{
+			Name:  "lock-policy",
+			Value: fileserver.LockPolicyWait,
+			Usage: "What to do when another request is busy with the same path (or a parent/child of it): 'wait' for it or 'fail' with 423 Locked",
+		},
+		cli.DurationFlag{
+			Name:  "lock-timeout",
+			Value: 0,
+			Usage: "With the 'wait' lock policy, fail with 423 Locked after waiting this long for a path lock (0 waits forever)",
+		},
+		cli.StringSliceFlag{
+			Name:  "root",
+			Value: &cli.StringSlice{},
+			Usage: "A root directory to serve as name=path with optional ',quota=10GiB', ',trash=1,trashretention=168h,trashmaxsize=5GiB', ',dedup=1' and ',storage=memory' or ',storage=s3,s3endpoint=URL,s3bucket=NAME,s3prefix=PREFIX,s3region=REGION' (credentials from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY), can be repeated. When given, all paths must be inside a root",
+		},
+		cli.StringFlag{
+			Name:  "rate-limit-request",
+			Value: "",
+			Usage: "Limit the bandwidth of each request (upload or download) to this number of bytes per second (for example 5MiB), empty means unlimited",
+		},
+		cli.StringFlag{
+			Name:  "rate-limit-global",
+			Value: "",
+			Usage: "Limit the combined bandwidth of all requests to this number of bytes per second (for example 50MiB), empty means unlimited",
+		},
+		cli.StringFlag{
+			Name:  "hooks",
+			Value: "",
+			Usage: "Path to a JSON file of hooks (commands or webhooks) to run after uploads, deletes and moves",
+		},
+		cli.StringFlag{
+			Name:  "audit-log",
+			Value: "",
+			Usage: "Append a JSON line for every upload, delete, move and other change (who, what, how much, the outcome) to this file, empty disables the audit log",
+		},
+		cli.StringFlag{
+			Name:  "audit-log-max-size",
+			Value: "100MiB",
+			Usage: "Rotate the audit log once it reaches this size (for example 100MiB)",
+		},
+		cli.IntFlag{
+			Name:  "audit-log-max-backups",
+			Value: 10,
+			Usage: "How many rotated audit log files to keep (as <file>.1 to <file>.N, at least 1), older ones are removed",
+		},
+	}
+	app.Run(os.Args)
+}
@@ -0,0 +1,139 @@
+package fileserver
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+	"github.com/francoishill/golang-web-dry/zip/examples/fileserver/storage"
+	"github.com/francoishill/golang-web-dry/zip/ziputils"
+)
+
+// errDifferentStorages is the error of a rename between two storages, it would have to copy
+var errDifferentStorages = errors.New("Cannot rename between different storages")
+
+/*
+serverFileSystem lets the ziputils functions work on the storage of the root of each path, so all roots (the local
+ones through the shared localStorage) are sent, saved, copied and deleted by the same code.
+*/
+type serverFileSystem struct {
+	a *appContext
+}
+
+func (a *appContext) fileSystem() ziputils.FileSystem {
+	return serverFileSystem{a: a}
+}
+
+type storageFileInfo struct {
+	info *storage.FileInfo
+}
+
+func (f storageFileInfo) Name() string {
+	return f.info.Name
+}
+
+func (f storageFileInfo) Size() int64 {
+	return f.info.Size
+}
+
+func (f storageFileInfo) Mode() os.FileMode {
+	if f.info.IsDir {
+		return f.info.Mode | os.ModeDir
+	}
+	return f.info.Mode
+}
+
+func (f storageFileInfo) ModTime() time.Time {
+	return f.info.ModTime
+}
+
+func (f storageFileInfo) IsDir() bool {
+	return f.info.IsDir
+}
+
+func (f storageFileInfo) Sys() interface{} {
+	return nil
+}
+
+func (f serverFileSystem) Stat(path string) (os.FileInfo, error) {
+	info, err := f.a.storageFor(path).Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return storageFileInfo{info: info}, nil
+}
+
+func (f serverFileSystem) Walk(root string, walkFn filepath.WalkFunc) error {
+	return storage.Walk(f.a.storageFor(root), root, func(path string, info *storage.FileInfo, err error) error {
+		//A nil info must stay a nil interface, not a storageFileInfo without info
+		var fileInfo os.FileInfo
+		if info != nil {
+			fileInfo = storageFileInfo{info: info}
+		}
+		return walkFn(path, fileInfo, err)
+	})
+}
+
+func (f serverFileSystem) ReadDirNames(dir string) ([]string, error) {
+	entries, err := f.a.storageFor(dir).List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	return names, nil
+}
+
+func (f serverFileSystem) Open(path string) (io.ReadCloser, error) {
+	return f.a.storageFor(path).Open(path)
+}
+
+func (f serverFileSystem) Create(path string, mode os.FileMode) (io.WriteCloser, error) {
+	return f.a.storageFor(path).Create(path, mode.Perm())
+}
+
+func (f serverFileSystem) MkdirAll(path string, mode os.FileMode) error {
+	return f.a.storageFor(path).Mkdir(path, mode.Perm(), true)
+}
+
+func (f serverFileSystem) Remove(path string) error {
+	return f.a.storageFor(path).Remove(path)
+}
+
+func (f serverFileSystem) RemoveAll(path string) error {
+	return f.a.storageFor(path).RemoveAll(path)
+}
+
+func (f serverFileSystem) Rename(oldPath, newPath string) error {
+	s := f.a.storageFor(oldPath)
+	if f.a.storageFor(newPath) != s {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: errDifferentStorages}
+	}
+	return s.Rename(oldPath, newPath)
+}
+
+func (f serverFileSystem) Chtimes(path string, atime, mtime time.Time) error {
+	return f.a.storageFor(path).Chtimes(path, atime, mtime)
+}
+
+// getStorageSize is the total size of the files inside path
+func getStorageSize(s storage.Storage, path string) int64 {
+	var size int64 = 0
+	err := storage.Walk(s, path, func(walkPath string, info *storage.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir {
+			size += info.Size
+		}
+		return nil
+	})
+	CheckError(err)
+	return size
+}
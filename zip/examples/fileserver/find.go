@@ -0,0 +1,340 @@
+package fileserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+	"github.com/francoishill/golang-web-dry/logging/leveledlog"
+	"github.com/francoishill/golang-web-dry/zip/examples/fileserver/storage"
+)
+
+const (
+	defaultFindMaxResults = 1000
+	maxFindMaxResults     = 100000
+	defaultFindTimeout    = 30 * time.Second
+	maxFindTimeout        = 5 * time.Minute
+
+	//Lines longer than this are not searched by 'contains', the rest of the file is skipped
+	maxFindLineLength = 1024 * 1024
+
+	findTypeFile = "file"
+	findTypeDir  = "dir"
+
+	findReasonMaxResults = "maxresults"
+	findReasonTimeout    = "timeout"
+	findReasonShutdown   = "shutdown"
+)
+
+// errFindStopped ends the walk of a find, the reason is in the findSummary
+var errFindStopped = errors.New("Find stopped")
+
+/*
+findOptions are the query parameters of a find request, all of them are optional:
+
+	name        a filepath.Match pattern on the base name, may be repeated to match any of them
+	type        'file' or 'dir'
+	minsize     the minimum size of files like 10MiB, directories never match a size range
+	maxsize     the maximum size of files
+	newerthan   modified after this RFC3339 time, or within this duration like 24h
+	olderthan   modified before this RFC3339 time, or longer ago than this duration
+	contains    files containing this text, the line of the first occurrence is returned
+	maxresults  stop after this many matches (default 1000, at most 100000)
+	timeout     stop searching after this duration (default 30s, at most 5m)
+*/
+type findOptions struct {
+	names      []string
+	fileType   string
+	minSize    int64
+	maxSize    int64
+	newerThan  time.Time
+	olderThan  time.Time
+	contains   string
+	maxResults int
+	timeout    time.Duration
+}
+
+// findMatch is a line of the find response for every matching path
+type findMatch struct {
+	Path    string
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+	IsDir   bool
+	Line    int `json:",omitempty"`
+}
+
+// findSummary is the last line of the find response, Truncated means there could be more matches than returned
+type findSummary struct {
+	Done      bool
+	Matches   int
+	Truncated bool
+	Reason    string `json:",omitempty"`
+	Errors    int    `json:",omitempty"`
+}
+
+func (a *appContext) getSizeQueryValue(r *http.Request, keyName string) int64 {
+	val := r.FormValue(keyName)
+	if val == "" {
+		return -1
+	}
+
+	size, err := humanize.ParseBytes(val)
+	if err != nil {
+		panic(badRequest("Query parameter '%s' must be a size like 10MiB, got '%s'", keyName, val))
+	}
+	return int64(size)
+}
+
+// getTimeQueryValue parses an RFC3339 time, or a duration which is subtracted from now
+func (a *appContext) getTimeQueryValue(r *http.Request, keyName string, now time.Time) time.Time {
+	val := r.FormValue(keyName)
+	if val == "" {
+		return time.Time{}
+	}
+
+	if t, err := time.Parse(time.RFC3339, val); err == nil {
+		return t
+	}
+	if duration, err := time.ParseDuration(val); err == nil {
+		return now.Add(-duration)
+	}
+	panic(badRequest("Query parameter '%s' must be an RFC3339 time or a duration like 24h, got '%s'", keyName, val))
+}
+
+func (a *appContext) getFindOptionsFromRequest(r *http.Request) *findOptions {
+	err := r.ParseForm()
+	CheckError(err)
+
+	now := time.Now()
+	options := &findOptions{
+		names:      r.Form["name"],
+		fileType:   strings.ToLower(r.FormValue("type")),
+		minSize:    a.getSizeQueryValue(r, "minsize"),
+		maxSize:    a.getSizeQueryValue(r, "maxsize"),
+		newerThan:  a.getTimeQueryValue(r, "newerthan", now),
+		olderThan:  a.getTimeQueryValue(r, "olderthan", now),
+		contains:   r.FormValue("contains"),
+		maxResults: defaultFindMaxResults,
+		timeout:    defaultFindTimeout,
+	}
+
+	for _, name := range options.names {
+		if _, err := filepath.Match(name, ""); err != nil {
+			panic(badRequest("Query parameter 'name' has an invalid pattern '%s'", name))
+		}
+	}
+
+	switch options.fileType {
+	case "", findTypeFile, findTypeDir:
+		break
+	default:
+		panic(badRequest("Query parameter 'type' must be '%s' or '%s', got '%s'", findTypeFile, findTypeDir, options.fileType))
+	}
+
+	if val := r.FormValue("maxresults"); val != "" {
+		options.maxResults, err = strconv.Atoi(val)
+		if err != nil || options.maxResults <= 0 {
+			panic(badRequest("Query parameter 'maxresults' must be a positive integer, got '%s'", val))
+		}
+		if options.maxResults > maxFindMaxResults {
+			options.maxResults = maxFindMaxResults
+		}
+	}
+
+	if val := r.FormValue("timeout"); val != "" {
+		options.timeout, err = time.ParseDuration(val)
+		if err != nil || options.timeout <= 0 {
+			panic(badRequest("Query parameter 'timeout' must be a positive duration like 1m, got '%s'", val))
+		}
+		if options.timeout > maxFindTimeout {
+			options.timeout = maxFindTimeout
+		}
+	}
+
+	return options
+}
+
+// matchesInfo checks everything except the content, which is only read if the rest matches
+func (o *findOptions) matchesInfo(path string, info *storage.FileInfo) bool {
+	if o.fileType == findTypeFile && info.IsDir || o.fileType == findTypeDir && !info.IsDir {
+		return false
+	}
+
+	if len(o.names) > 0 {
+		nameMatches := false
+		for _, name := range o.names {
+			if matched, _ := filepath.Match(name, filepath.Base(path)); matched {
+				nameMatches = true
+				break
+			}
+		}
+		if !nameMatches {
+			return false
+		}
+	}
+
+	if o.minSize >= 0 || o.maxSize >= 0 || o.contains != "" {
+		if info.IsDir {
+			return false
+		}
+		if o.minSize >= 0 && info.Size < o.minSize || o.maxSize >= 0 && info.Size > o.maxSize {
+			return false
+		}
+	}
+
+	if !o.newerThan.IsZero() && !info.ModTime.After(o.newerThan) {
+		return false
+	}
+	if !o.olderThan.IsZero() && !info.ModTime.Before(o.olderThan) {
+		return false
+	}
+	return true
+}
+
+// findLineContaining returns the (1-based) line number of the first line of the file containing text, 0 if none does
+func findLineContaining(ctx context.Context, store storage.Storage, path, text string) (int, error) {
+	file, err := store.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxFindLineLength)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if strings.Contains(scanner.Text(), text) {
+			return lineNumber, nil
+		}
+		if ctx.Err() != nil {
+			return 0, errFindStopped
+		}
+	}
+
+	if err := scanner.Err(); err != nil && err != bufio.ErrTooLong {
+		return 0, err
+	}
+	return 0, nil
+}
+
+/*
+handleFind walks dir and streams a JSON line for every match (see findOptions), flushed as they are found. The last
+line is the findSummary, a response without it was interrupted. The trash and blob store directories are skipped, and
+paths that cannot be read are counted in the summary instead of failing the search. The walk takes no locks, paths
+changed during the search may or may not be found.
+*/
+func (a *appContext) handleFind(w http.ResponseWriter, r *http.Request, dir string) {
+	flusher := getFlusher(w)
+	options := a.getFindOptionsFromRequest(r)
+
+	store := a.storageFor(dir)
+	_, err := store.Stat(dir)
+	CheckError(err)
+
+	ctx, cancel := context.WithTimeout(r.Context(), options.timeout)
+	defer cancel()
+
+	logger := a.logger.With(leveledlog.F("path", dir))
+	logger.Info("Finding in %s", dir)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(w)
+	summary := &findSummary{Done: true}
+
+	err = storage.Walk(store, dir, func(path string, info *storage.FileInfo, err error) error {
+		select {
+		case <-a.transfers.shutdownChannel():
+			summary.Reason = findReasonShutdown
+			return errFindStopped
+		default:
+		}
+		if ctx.Err() != nil {
+			summary.Reason = findReasonTimeout
+			return errFindStopped
+		}
+
+		if err != nil {
+			logger.Debug("Skipping unreadable path %s: %s", path, err.Error())
+			summary.Errors++
+			if info != nil && info.IsDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if a.isReservedPath(path) {
+			if info.IsDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !options.matchesInfo(path, info) {
+			return nil
+		}
+
+		line := 0
+		if options.contains != "" {
+			line, err = findLineContaining(ctx, store, path, options.contains)
+			if err == errFindStopped {
+				summary.Reason = findReasonTimeout
+				return err
+			}
+			if err != nil {
+				logger.Debug("Skipping unreadable file %s: %s", path, err.Error())
+				summary.Errors++
+				return nil
+			}
+			if line == 0 {
+				return nil
+			}
+		}
+
+		match := &findMatch{
+			Path:    path,
+			Size:    info.Size,
+			Mode:    info.Mode,
+			ModTime: info.ModTime,
+			IsDir:   info.IsDir,
+			Line:    line,
+		}
+		if info.IsDir {
+			match.Size = 0
+		}
+		if err := encoder.Encode(match); err != nil {
+			//The client is gone, there is nobody to send the summary to
+			return err
+		}
+		flusher.Flush()
+
+		summary.Matches++
+		if summary.Matches >= options.maxResults {
+			summary.Reason = findReasonMaxResults
+			return errFindStopped
+		}
+		return nil
+	})
+
+	if err != nil && err != errFindStopped {
+		logger.Debug("Find ended early: %s", err.Error())
+		return
+	}
+
+	summary.Truncated = summary.Reason != ""
+	logger.With(leveledlog.F("matches", summary.Matches), leveledlog.F("errors", summary.Errors)).Info("Found %d matches", summary.Matches)
+	if err := encoder.Encode(summary); err == nil {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,216 @@
+package fileserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+	"github.com/francoishill/golang-web-dry/executils/RunningCommand"
+	"github.com/francoishill/golang-web-dry/logging/leveledlog"
+	"github.com/francoishill/golang-web-dry/requests/requestproxyutils"
+)
+
+const (
+	hookEventUpload = "upload"
+	hookEventDelete = "delete"
+	hookEventMove   = "move"
+
+	defaultHookTimeout = 60 * time.Second
+)
+
+/*
+HookConfig is a hook run after uploads, deletes or moves (see WithHooks), or one entry of the JSON hooks file given
+to WithHooksFile. Either Command or Url must be set.
+
+For example:
+
+	[
+		{"Events": ["upload"], "Command": "/usr/bin/make", "Args": ["-C", "/srv/site"], "TimeoutSeconds": 300},
+		{"Events": ["upload", "delete", "move"], "Url": "http://cache.local/invalidate"}
+	]
+
+Commands receive the event in FILESERVER_* environment variables, webhooks receive it as a JSON POST body.
+*/
+type HookConfig struct {
+	Events           []string
+	Command          string
+	Args             []string
+	WorkingDirectory string
+	Url              string
+	TimeoutSeconds   int
+}
+
+type hookEvent struct {
+	Event     string
+	Paths     []string
+	ClientIP  string
+	UserAgent string
+	Bytes     int64
+	Time      time.Time
+}
+
+type hookRunner struct {
+	logger     leveledlog.Logger
+	hooks      []*HookConfig
+	httpClient *http.Client
+}
+
+func (h *HookConfig) handlesEvent(event string) bool {
+	for _, e := range h.Events {
+		if e == "*" || strings.EqualFold(e, event) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *HookConfig) timeout() time.Duration {
+	if h.TimeoutSeconds <= 0 {
+		return defaultHookTimeout
+	}
+	return time.Duration(h.TimeoutSeconds) * time.Second
+}
+
+func (h *HookConfig) String() string {
+	if h.Command != "" {
+		return "command " + h.Command
+	}
+	return "webhook " + h.Url
+}
+
+func loadHooksFile(filePath string) []*HookConfig {
+	content, err := ioutil.ReadFile(filePath)
+	CheckError(err)
+
+	hooks := []*HookConfig{}
+	err = json.Unmarshal(content, &hooks)
+	CheckError(err)
+
+	for _, hook := range hooks {
+		if (hook.Command == "") == (hook.Url == "") {
+			panic(fmt.Sprintf("Hook for events %v in '%s' must have exactly one of 'Command' or 'Url'", hook.Events, filePath))
+		}
+	}
+	return hooks
+}
+
+func newHookRunner(logger leveledlog.Logger, hooks []*HookConfig) *hookRunner {
+	return &hookRunner{
+		logger:     logger,
+		hooks:      hooks,
+		httpClient: &http.Client{},
+	}
+}
+
+func newHookEvent(r *http.Request, event string, bytes int64, paths ...string) *hookEvent {
+	return &hookEvent{
+		Event:     event,
+		Paths:     paths,
+		ClientIP:  requestproxyutils.IP(r),
+		UserAgent: r.UserAgent(),
+		Bytes:     bytes,
+		Time:      time.Now(),
+	}
+}
+
+// fire runs all hooks registered for the event in the background, it never blocks the request
+func (h *hookRunner) fire(event *hookEvent) {
+	if h == nil {
+		return
+	}
+
+	for _, hook := range h.hooks {
+		if !hook.handlesEvent(event.Event) {
+			continue
+		}
+
+		if hook.Command != "" {
+			go h.runCommand(hook, event)
+		} else {
+			go h.postWebhook(hook, event)
+		}
+	}
+}
+
+func (h *hookRunner) recoverHookPanic(hook *HookConfig, event *hookEvent) {
+	if r := recover(); r != nil {
+		h.logger.Error("Hook %s for event '%s' on %v failed: %+v", hook, event.Event, event.Paths, r)
+	}
+}
+
+func (h *hookRunner) runCommand(hook *HookConfig, event *hookEvent) {
+	defer h.recoverHookPanic(hook, event)
+
+	startTime := time.Now()
+	finishOnce := &sync.Once{}
+	onFinish := func(rc *RunningCommand.RunningCommand) {
+		finishOnce.Do(func() {
+			duration := time.Now().Sub(startTime)
+			if rc.RanSuccessfully {
+				h.logger.Info("Hook %s for event '%s' on %v succeeded in %s", hook, event.Event, event.Paths, duration)
+			} else {
+				h.logger.Error("Hook %s for event '%s' on %v failed after %s, output: %s", hook, event.Event, event.Paths, duration, strings.Join(rc.CurrentFeedback, ""))
+			}
+		})
+	}
+
+	rc := RunningCommand.New(onFinish, event, hook.Command, hook.Args...)
+	rc.WorkingDirectory = hook.WorkingDirectory
+	rc.NoStdin = true
+	//Buffered, otherwise the command waits for a reader to report its success and is only finished by the timeout
+	rc.QuitChannel = make(chan bool, 1)
+	rc.Environment = []string{
+		"FILESERVER_EVENT=" + event.Event,
+		"FILESERVER_PATHS=" + strings.Join(event.Paths, string(os.PathListSeparator)),
+		"FILESERVER_CLIENT_IP=" + event.ClientIP,
+		"FILESERVER_USER_AGENT=" + event.UserAgent,
+		fmt.Sprintf("FILESERVER_BYTES=%d", event.Bytes),
+	}
+	rc.Start(hook.timeout())
+}
+
+func (h *hookRunner) postWebhook(hook *HookConfig, event *hookEvent) {
+	defer h.recoverHookPanic(hook, event)
+
+	body, err := json.Marshal(event)
+	CheckError(err)
+
+	req, err := http.NewRequest("POST", hook.Url, bytes.NewReader(body))
+	CheckError(err)
+	req.Header.Set("Content-Type", "application/json")
+
+	startTime := time.Now()
+	client := *h.httpClient
+	client.Timeout = hook.timeout()
+	resp, err := client.Do(req)
+	CheckError(err)
+	defer resp.Body.Close()
+
+	duration := time.Now().Sub(startTime)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		h.logger.Error("Hook %s for event '%s' on %v failed after %s with status %d: %s", hook, event.Event, event.Paths, duration, resp.StatusCode, string(respBody))
+		return
+	}
+	h.logger.Info("Hook %s for event '%s' on %v succeeded in %s", hook, event.Event, event.Paths, duration)
+}
+
+// countingReader counts the bytes read through it, used to report upload sizes to hooks
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count += int64(n)
+	return n, err
+}
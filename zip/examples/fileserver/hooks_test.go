@@ -0,0 +1,71 @@
+package fileserver
+
+import (
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/francoishill/golang-web-dry/logging/leveledlog"
+)
+
+// recordingSink keeps the messages logged by the hooks, which run in their own goroutines
+type recordingSink struct {
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (s *recordingSink) Write(level leveledlog.Level, message string, fields []leveledlog.Field) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.messages = append(s.messages, level.String()+" "+message)
+}
+
+func (s *recordingSink) find(substring string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, message := range s.messages {
+		if strings.Contains(message, substring) {
+			return message
+		}
+	}
+	return ""
+}
+
+func TestHookCommands(t *testing.T) {
+	Convey("Testing the command hooks", t, func() {
+		if runtime.GOOS == "windows" {
+			return
+		}
+
+		sink := &recordingSink{}
+		runner := &hookRunner{logger: leveledlog.New(sink)}
+		event := &hookEvent{Event: hookEventUpload, Paths: []string{"/srv/a.txt"}, Time: time.Now()}
+
+		waitForMessage := func(substring string) string {
+			for start := time.Now(); time.Now().Sub(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+				if message := sink.find(substring); message != "" {
+					return message
+				}
+			}
+			return ""
+		}
+
+		Convey("A fast command should be logged as succeeded right away, not after its timeout", func() {
+			runner.runCommand(&HookConfig{Events: []string{hookEventUpload}, Command: "true", TimeoutSeconds: 60}, event)
+
+			message := waitForMessage("succeeded")
+			So(message, ShouldStartWith, "INFO ")
+			So(sink.find("failed"), ShouldBeEmpty)
+		})
+
+		Convey("A failing command should be logged as failed", func() {
+			runner.runCommand(&HookConfig{Events: []string{hookEventUpload}, Command: "false", TimeoutSeconds: 60}, event)
+
+			So(waitForMessage("failed"), ShouldStartWith, "ERROR ")
+		})
+	})
+}
@@ -0,0 +1,43 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+	"github.com/francoishill/golang-web-dry/zip/examples/fileserver/storage"
+)
+
+// listDirectory returns the entries of dir sorted by name, without the trash and blob store directories of the roots. If dir is a file it is the only entry.
+func (a *appContext) listDirectory(dir string) []*storage.FileInfo {
+	a.logger.Info("Listing directory %s", dir)
+
+	store := a.storageFor(dir)
+	info, err := store.Stat(dir)
+	CheckError(err)
+	if !info.IsDir {
+		return []*storage.FileInfo{info}
+	}
+
+	entries, err := store.List(dir)
+	CheckError(err)
+
+	visible := []*storage.FileInfo{}
+	for _, entry := range entries {
+		if !a.isReservedPath(filepath.Join(dir, entry.Name)) {
+			visible = append(visible, entry)
+		}
+	}
+	return visible
+}
+
+func (a *appContext) handleList(w http.ResponseWriter, r *http.Request, dir string) {
+	defer a.locks.acquire(r.Context().Done(), readLock(dir))()
+
+	entries := a.listDirectory(dir)
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(entries)
+	CheckError(err)
+}
@@ -0,0 +1,149 @@
+package fileserver
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The lock policies of WithLockPolicy, what a request does when another request is busy with the same path
+const (
+	LockPolicyWait = "wait"
+	LockPolicyFail = "fail"
+)
+
+type pathLockRequest struct {
+	path  string
+	write bool
+}
+
+func readLock(path string) *pathLockRequest {
+	return &pathLockRequest{path: filepath.Clean(path), write: false}
+}
+
+func writeLock(path string) *pathLockRequest {
+	return &pathLockRequest{path: filepath.Clean(path), write: true}
+}
+
+func isSameOrChildPath(parent, child string) bool {
+	return child == parent || strings.HasPrefix(child, strings.TrimRight(parent, `/\`)+string(filepath.Separator))
+}
+
+// conflictsWith is true when both locks touch the same tree (one is the other or an ancestor of it) and either one is a writer
+func (p *pathLockRequest) conflictsWith(other *pathLockRequest) bool {
+	if !p.write && !other.write {
+		return false
+	}
+	return isSameOrChildPath(p.path, other.path) || isSameOrChildPath(other.path, p.path)
+}
+
+/*
+pathLocker hands out hierarchical reader/writer locks on paths. Many readers can hold a path at once, but a writer
+excludes everyone else from that path, its parents and its children.
+
+All the paths of a single operation are acquired together (or not at all) so operations like move can never
+deadlock each other. A nil *pathLocker does no locking.
+
+	release := a.locks.acquire(r.Context().Done(), readLock(srcPath), writeLock(destPath))
+	defer release()
+*/
+type pathLocker struct {
+	policy  string
+	timeout time.Duration
+
+	mutex    sync.Mutex
+	held     map[*pathLockRequest]bool
+	released chan struct{}
+}
+
+func newPathLocker(policy string, timeout time.Duration) *pathLocker {
+	switch policy {
+	case LockPolicyWait, LockPolicyFail:
+		break
+	default:
+		panic(fmt.Sprintf("Invalid lock policy '%s', expected '%s' or '%s'", policy, LockPolicyWait, LockPolicyFail))
+	}
+
+	return &pathLocker{
+		policy:   policy,
+		timeout:  timeout,
+		held:     make(map[*pathLockRequest]bool),
+		released: make(chan struct{}),
+	}
+}
+
+func lockedError(requests []*pathLockRequest) *apiError {
+	paths := []string{}
+	for _, req := range requests {
+		paths = append(paths, req.path)
+	}
+	return newApiError(http.StatusLocked, errCodeLocked, "Path is locked by another operation: %s", strings.Join(paths, ", "))
+}
+
+// tryAcquire must be called while holding the mutex
+func (l *pathLocker) tryAcquire(requests []*pathLockRequest) bool {
+	for held := range l.held {
+		for _, req := range requests {
+			if req.conflictsWith(held) {
+				return false
+			}
+		}
+	}
+
+	for _, req := range requests {
+		l.held[req] = true
+	}
+	return true
+}
+
+func (l *pathLocker) release(requests []*pathLockRequest) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, req := range requests {
+		delete(l.held, req)
+	}
+
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// acquire panics with a 423 Locked apiError if the paths are busy and the policy is 'fail' (or the wait timed out)
+func (l *pathLocker) acquire(cancel <-chan struct{}, requests ...*pathLockRequest) (release func()) {
+	if l == nil {
+		return func() {}
+	}
+
+	var deadline <-chan time.Time
+	if l.timeout > 0 {
+		timer := time.NewTimer(l.timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for {
+		l.mutex.Lock()
+		if l.tryAcquire(requests) {
+			l.mutex.Unlock()
+			return func() { l.release(requests) }
+		}
+		released := l.released
+		l.mutex.Unlock()
+
+		if l.policy == LockPolicyFail {
+			panic(lockedError(requests))
+		}
+
+		select {
+		case <-released:
+			break
+		case <-deadline:
+			panic(lockedError(requests))
+		case <-cancel:
+			panic(lockedError(requests))
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package fileserver
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/francoishill/golang-web-dry/zip/ziputils"
+)
+
+/*
+The metrics are served at /metrics in the Prometheus text format, for example:
+
+	fileserver_requests_total{operation="upload",status="200"} 12
+	fileserver_request_duration_seconds_bucket{operation="upload",status="200",le="0.5"} 9
+	fileserver_uploaded_bytes_total 52428800
+	fileserver_active_transfers 1
+	fileserver_extraction_failures_total{reason="end_of_tar"} 2
+	fileserver_root_used_bytes{root="builds"} 1073741824
+
+The disk usage of the roots is cached for diskUsageMetricsMaxAge, walking big roots on every scrape would be too slow.
+*/
+
+const diskUsageMetricsMaxAge = time.Minute
+
+var requestDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// The operations of the requests, anything else is counted as "other" so junk actions cannot add label values
+var knownActions = map[string]bool{
+	"list": true, "find": true, "watch": true, "tail": true, "archive": true, "diskusage": true, "trash": true,
+	"batch": true, "dedup": true, "move": true, "copy": true, "mkdir": true, "chmod": true, "touch": true, "restore": true,
+}
+
+type requestMetricKey struct {
+	operation string
+	status    int
+}
+
+type requestMetric struct {
+	count        int64
+	durationSum  float64
+	bucketCounts []int64
+}
+
+type metrics struct {
+	mutex              sync.Mutex
+	requests           map[requestMetricKey]*requestMetric
+	extractionFailures map[string]int64
+
+	uploadedBytes   int64
+	downloadedBytes int64
+
+	diskUsageMutex   sync.Mutex
+	diskUsage        []*rootDiskUsage
+	diskUsageUpdated time.Time
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:           map[requestMetricKey]*requestMetric{},
+		extractionFailures: map[string]int64{},
+	}
+}
+
+// requestOperation names the operation of a request of the legacy handler, like "upload", "download" or "move"
+func requestOperation(r *http.Request) string {
+	action := strings.ToLower(r.URL.Query().Get("action"))
+	if action != "" {
+		if !knownActions[action] {
+			return "other"
+		}
+		return action
+	}
+
+	switch r.Method {
+	case "GET":
+		return "download"
+	case "POST":
+		return "upload"
+	case "HEAD":
+		return "stats"
+	case "DELETE":
+		return "delete"
+	}
+	return "other"
+}
+
+// metricsResponseWriter records the status code and the size of a response, and the size of the request body
+type metricsResponseWriter struct {
+	http.ResponseWriter
+	m         *metrics
+	operation string
+	startTime time.Time
+	status    int
+	written   int64
+	body      *countingReader
+}
+
+func (w *metricsResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *metricsResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *metricsResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// startRequest starts measuring a request of the legacy handler, the returned writer must be used for the response and finished once the request is done
+func (m *metrics) startRequest(w http.ResponseWriter, r *http.Request) *metricsResponseWriter {
+	recorder := &metricsResponseWriter{
+		ResponseWriter: w,
+		m:              m,
+		operation:      requestOperation(r),
+		startTime:      time.Now(),
+		body:           &countingReader{reader: r.Body},
+	}
+	r.Body = &replacedBody{recorder.body, r.Body}
+	return recorder
+}
+
+func (w *metricsResponseWriter) finish() {
+	m := w.m
+	if m == nil {
+		return
+	}
+
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	duration := time.Now().Sub(w.startTime).Seconds()
+
+	if w.operation == "upload" || w.operation == "dedup" {
+		atomic.AddInt64(&m.uploadedBytes, w.body.count)
+	}
+	if w.operation == "download" || w.operation == "archive" || w.operation == "tail" {
+		atomic.AddInt64(&m.downloadedBytes, w.written)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := requestMetricKey{w.operation, status}
+	metric, ok := m.requests[key]
+	if !ok {
+		metric = &requestMetric{bucketCounts: make([]int64, len(requestDurationBuckets))}
+		m.requests[key] = metric
+	}
+
+	metric.count++
+	metric.durationSum += duration
+	for i, bucket := range requestDurationBuckets {
+		if duration <= bucket {
+			metric.bucketCounts[i]++
+		}
+	}
+}
+
+// isInvalidTarEntry tells whether the handler panicked because a tar entry would be saved outside of the upload path
+func isInvalidTarEntry(recovered interface{}) bool {
+	_, ok := recovered.(*ziputils.InvalidTarEntryError)
+	return ok
+}
+
+// observeError counts the uploads that failed while extracting the tar stream, recovered is the panic of the handler
+func (m *metrics) observeError(r *http.Request, recovered interface{}, apiErr *apiError) {
+	if m == nil {
+		return
+	}
+
+	operation := requestOperation(r)
+	if operation != "upload" && operation != "dedup" {
+		return
+	}
+
+	reason := ""
+	switch {
+	case recovered == ziputils.ErrTarValidationFailed:
+		reason = "end_of_tar"
+		break
+	case recovered == tar.ErrHeader || recovered == io.ErrUnexpectedEOF || isInvalidTarEntry(recovered):
+		reason = "invalid_tar"
+		break
+	case apiErr.StatusCode >= http.StatusInternalServerError:
+		reason = "other"
+		break
+	default:
+		//Not an extraction failure, like a rejected path or an exceeded quota
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.extractionFailures[reason]++
+}
+
+func (a *appContext) getCachedDiskUsage() []*rootDiskUsage {
+	m := a.metrics
+	m.diskUsageMutex.Lock()
+	defer m.diskUsageMutex.Unlock()
+
+	if m.diskUsage == nil || time.Now().Sub(m.diskUsageUpdated) > diskUsageMetricsMaxAge {
+		usages := []*rootDiskUsage{}
+		for _, rt := range a.roots {
+			usages = append(usages, a.getRootDiskUsage(rt))
+		}
+		m.diskUsage = usages
+		m.diskUsageUpdated = time.Now()
+	}
+	return m.diskUsage
+}
+
+// metricLabels formats the label pairs (name, value, name, value, ...) like {name="value",...}
+func metricLabels(nameValues ...string) string {
+	pairs := []string{}
+	for i := 0; i+1 < len(nameValues); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(nameValues[i+1])
+		pairs = append(pairs, nameValues[i]+`="`+value+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func writeMetricHeader(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func (a *appContext) writeMetrics(w io.Writer) {
+	m := a.metrics
+
+	m.mutex.Lock()
+	keys := []requestMetricKey{}
+	requests := map[requestMetricKey]requestMetric{}
+	for key, metric := range m.requests {
+		keys = append(keys, key)
+		requests[key] = requestMetric{metric.count, metric.durationSum, append([]int64{}, metric.bucketCounts...)}
+	}
+	reasons := []string{}
+	extractionFailures := map[string]int64{}
+	for reason, count := range m.extractionFailures {
+		reasons = append(reasons, reason)
+		extractionFailures[reason] = count
+	}
+	m.mutex.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].operation != keys[j].operation {
+			return keys[i].operation < keys[j].operation
+		}
+		return keys[i].status < keys[j].status
+	})
+	sort.Strings(reasons)
+
+	writeMetricHeader(w, "fileserver_requests_total", "counter", "The number of requests by operation and status code.")
+	for _, key := range keys {
+		fmt.Fprintf(w, "fileserver_requests_total%s %d\n", metricLabels("operation", key.operation, "status", strconv.Itoa(key.status)), requests[key].count)
+	}
+
+	writeMetricHeader(w, "fileserver_request_duration_seconds", "histogram", "The duration of the requests by operation and status code.")
+	for _, key := range keys {
+		metric := requests[key]
+		operation, status := key.operation, strconv.Itoa(key.status)
+		for i, bucket := range requestDurationBuckets {
+			fmt.Fprintf(w, "fileserver_request_duration_seconds_bucket%s %d\n", metricLabels("operation", operation, "status", status, "le", formatFloat(bucket)), metric.bucketCounts[i])
+		}
+		fmt.Fprintf(w, "fileserver_request_duration_seconds_bucket%s %d\n", metricLabels("operation", operation, "status", status, "le", "+Inf"), metric.count)
+		fmt.Fprintf(w, "fileserver_request_duration_seconds_sum%s %s\n", metricLabels("operation", operation, "status", status), formatFloat(metric.durationSum))
+		fmt.Fprintf(w, "fileserver_request_duration_seconds_count%s %d\n", metricLabels("operation", operation, "status", status), metric.count)
+	}
+
+	writeMetricHeader(w, "fileserver_uploaded_bytes_total", "counter", "The bytes received by uploads.")
+	fmt.Fprintf(w, "fileserver_uploaded_bytes_total %d\n", atomic.LoadInt64(&m.uploadedBytes))
+
+	writeMetricHeader(w, "fileserver_downloaded_bytes_total", "counter", "The bytes sent by downloads.")
+	fmt.Fprintf(w, "fileserver_downloaded_bytes_total %d\n", atomic.LoadInt64(&m.downloadedBytes))
+
+	writeMetricHeader(w, "fileserver_active_transfers", "gauge", "The number of uploads and downloads in progress.")
+	var activeTransfers int64 = 0
+	if a.transfers != nil {
+		activeTransfers = a.transfers.getActiveTransfers()
+	}
+	fmt.Fprintf(w, "fileserver_active_transfers %d\n", activeTransfers)
+
+	writeMetricHeader(w, "fileserver_extraction_failures_total", "counter", "The uploads that failed while extracting the tar stream, by reason (end_of_tar is a stream without the END_OF_TAR marker).")
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "fileserver_extraction_failures_total%s %d\n", metricLabels("reason", reason), extractionFailures[reason])
+	}
+
+	usages := a.getCachedDiskUsage()
+	writeMetricHeader(w, "fileserver_root_used_bytes", "gauge", "The bytes used by the files of the root.")
+	for _, usage := range usages {
+		fmt.Fprintf(w, "fileserver_root_used_bytes%s %d\n", metricLabels("root", usage.Name), usage.UsedBytes)
+	}
+	writeMetricHeader(w, "fileserver_root_quota_bytes", "gauge", "The quota of the root, 0 if it has no quota.")
+	for _, usage := range usages {
+		fmt.Fprintf(w, "fileserver_root_quota_bytes%s %d\n", metricLabels("root", usage.Name), usage.QuotaBytes)
+	}
+	writeMetricHeader(w, "fileserver_root_available_bytes", "gauge", "The bytes that can still be uploaded to the root (the smallest of the quota and the free disk space).")
+	for _, usage := range usages {
+		fmt.Fprintf(w, "fileserver_root_available_bytes%s %d\n", metricLabels("root", usage.Name), usage.AvailableBytes)
+	}
+	writeMetricHeader(w, "fileserver_root_disk_total_bytes", "gauge", "The size of the disk of the root, 0 if the root is not on the local disk.")
+	for _, usage := range usages {
+		fmt.Fprintf(w, "fileserver_root_disk_total_bytes%s %d\n", metricLabels("root", usage.Name), usage.DiskTotalBytes)
+	}
+}
+
+func (a *appContext) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	a.writeMetrics(w)
+}
@@ -0,0 +1,213 @@
+package fileserver
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+	"github.com/francoishill/golang-web-dry/logging/leveledlog"
+	"github.com/francoishill/golang-web-dry/zip/examples/fileserver/storage"
+	"github.com/francoishill/golang-web-dry/zip/ziputils"
+)
+
+type pathStats struct {
+	Exists bool
+	IsDir  bool
+}
+
+type deleteError struct {
+	Path  string
+	Error string
+}
+
+type deleteResult struct {
+	DryRun       bool
+	DeletedPaths []string
+	Errors       []*deleteError `json:",omitempty"`
+}
+
+func newDeleteResult(dryRun bool, walkResult *ziputils.DeleteResult) *deleteResult {
+	result := &deleteResult{DryRun: dryRun}
+	result.add(walkResult)
+	return result
+}
+
+func (d *deleteResult) add(walkResult *ziputils.DeleteResult) {
+	d.DeletedPaths = append(d.DeletedPaths, walkResult.DeletedPaths...)
+	for _, fileErr := range walkResult.Errors {
+		d.Errors = append(d.Errors, &deleteError{Path: fileErr.Path, Error: fileErr.Err.Error()})
+	}
+}
+
+/*
+deletePath moves path into the trash if its root has one, otherwise it is removed permanently. With options.DryRun
+nothing is deleted, the result only lists what would have been.
+*/
+func (a *appContext) deletePath(r *http.Request, path, dirFileFilterPattern string, options *ziputils.DeleteOptions) *deleteResult {
+	if options == nil {
+		options = &ziputils.DeleteOptions{}
+	}
+
+	isDir := a.isDir(path)
+	walkContext := ziputils.NewDirWalkContext(dirFileFilterPattern)
+	walkContext.FileSystem = a.fileSystem()
+	if dirFileFilterPattern != "" {
+		//Without a filter the whole directory is deleted, a root with a trash cannot be deleted that way (see trashPath)
+		walkContext.IncludeFile, walkContext.IncludeDir = a.isUnreservedPath, a.isUnreservedPath
+	}
+
+	if options.DryRun {
+		a.logger.Info("Dry run of deleting %s", path)
+		if !isDir {
+			return &deleteResult{DryRun: true, DeletedPaths: []string{path}}
+		}
+		return newDeleteResult(true, walkContext.DeleteDirectoryWithOptions(path, options))
+	}
+
+	//The trash is counted in the usage of its root, so only a permanent delete changes it
+	if rt := a.findTrashRoot(path); rt != nil {
+		return a.trashPath(r, rt, path, dirFileFilterPattern, options.RemoveEmptyDirs)
+	}
+
+	defer a.usageChanged(path)
+	if isDir {
+		a.logger.Info("Deleting directory %s", path)
+		return newDeleteResult(false, walkContext.DeleteDirectoryWithOptions(path, options))
+	} else {
+		a.logger.Info("Deleting file %s", path)
+		err := a.storageFor(path).Remove(path)
+		CheckError(err)
+		return &deleteResult{DeletedPaths: []string{path}}
+	}
+}
+
+func (a *appContext) movePath(oldPath, newPath string) {
+	a.logger.Info("Moving %s to %s", oldPath, newPath)
+	a.checkSameStorage(oldPath, newPath)
+	a.moveWithDedup(oldPath, newPath, func() {
+		err := a.storageFor(oldPath).Rename(oldPath, newPath)
+		CheckError(err)
+	})
+	a.usageChanged(oldPath, newPath)
+}
+
+// checkCopyDestination panics if destPath is srcPath or inside it, copying would keep walking into the copies it made
+func checkCopyDestination(srcPath, destPath string) {
+	if isSameOrChildPath(filepath.Clean(srcPath), filepath.Clean(destPath)) {
+		panic(badRequest("Cannot copy '%s' into itself, destination '%s'", srcPath, destPath))
+	}
+}
+
+func (a *appContext) copyPath(srcPath, destPath, dirFileFilterPattern string) {
+	checkCopyDestination(srcPath, destPath)
+
+	if a.isDir(srcPath) {
+		a.logger.Info("Copying directory %s to %s", srcPath, destPath)
+	} else {
+		a.logger.Info("Copying file %s to %s", srcPath, destPath)
+	}
+
+	//A file is copied by the same walk, it is the only path visited
+	walkContext := ziputils.NewDirWalkContext(dirFileFilterPattern)
+	walkContext.IncludeFile, walkContext.IncludeDir = a.isUnreservedPath, a.isUnreservedPath
+	walkContext.FileSystem = a.fileSystem()
+	walkContext.CopyDirectory(srcPath, destPath)
+
+	if rt := a.findDedupRoot(destPath); rt != nil {
+		a.ingestDirectory(rt, destPath)
+	}
+	a.usageChanged(destPath)
+}
+
+func (a *appContext) makeDirectory(path string, parents bool, mode os.FileMode) {
+	a.logger.Info("Creating directory %s", path)
+	err := a.storageFor(path).Mkdir(path, mode, parents)
+	CheckError(err)
+}
+
+func (a *appContext) changeMode(path string, mode os.FileMode) {
+	a.logger.Info("Changing mode of %s to %s", path, mode)
+	a.requireLocalStorage(path, "Changing the mode")
+
+	rt := a.findDedupRoot(path)
+	isDedupFile := rt != nil && !a.isDir(path)
+	if isDedupFile {
+		//The links share the mode of the blob, so the file first needs its own content (it is linked again if the mode is that of the blob)
+		detachFile(path)
+	}
+
+	err := os.Chmod(path, mode)
+	CheckError(err)
+
+	if isDedupFile {
+		a.ingestFile(rt, path)
+	}
+}
+
+func (a *appContext) touchFile(path string) {
+	a.logger.Info("Touching file %s", path)
+
+	s := a.storageFor(path)
+	info, err := s.Stat(path)
+	if os.IsNotExist(err) {
+		err = storage.WriteFile(s, path, []byte{}, 0644)
+		CheckError(err)
+		return
+	}
+	CheckError(err)
+
+	if a.findDedupRoot(path) != nil && !info.IsDir {
+		//The links share the mtime of the blob, so the touched file keeps its own content (linking it again would undo the touch)
+		detachFile(path)
+	}
+
+	now := time.Now()
+	err = s.Chtimes(path, now, now)
+	if errors.Is(err, errors.ErrUnsupported) && !info.IsDir {
+		//The storage cannot only change the modification time, so the file is written again
+		data := readStorageFile(s, path)
+		err = storage.WriteFile(s, path, data, info.Mode.Perm())
+	}
+	CheckError(err)
+}
+
+func readStorageFile(s storage.Storage, path string) []byte {
+	reader, err := s.Open(path)
+	CheckError(err)
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	CheckError(err)
+	return data
+}
+
+func (a *appContext) getStats(path string) *pathStats {
+	a.logger.Info("Sending stats for path %s", path)
+
+	info, err := a.storageFor(path).Stat(path)
+	if os.IsNotExist(err) {
+		return &pathStats{}
+	}
+	CheckError(err)
+
+	return &pathStats{
+		Exists: true,
+		IsDir:  info.IsDir,
+	}
+}
+
+func (a *appContext) writeFile(path string, data []byte, mode os.FileMode) {
+	a.logger.With(leveledlog.F("path", path), leveledlog.F("bytes", len(data))).Info("Writing file")
+
+	//The storage replaces an existing file instead of writing into it, so a link to a blob keeps its content
+	err := storage.WriteFile(a.storageFor(path), path, data, mode)
+	CheckError(err)
+
+	if rt := a.findDedupRoot(path); rt != nil {
+		a.ingestFile(rt, path)
+	}
+}
@@ -0,0 +1,85 @@
+package fileserver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/francoishill/golang-web-dry/zip/ziputils"
+)
+
+// Option configures the Server returned by New
+type Option func(a *appContext)
+
+/*
+WithRoot serves a root directory, given like the --root flag of the fileserver binary as comma separated key=value
+pairs of which the first is the name and path (see root for all options). For example:
+
+	fileserver.WithRoot("builds=/srv/builds,quota=50GiB,trash=1,trashretention=168h")
+
+Once any root is given, all paths of all requests must be inside one of the roots.
+*/
+func WithRoot(rootSpec string) Option {
+	return func(a *appContext) {
+		a.roots = append(a.roots, parseRootFlag(rootSpec))
+	}
+}
+
+// WithAuthTokens requires one of the tokens as the bearer token of every request, all requests are allowed without tokens
+func WithAuthTokens(tokens ...string) Option {
+	return func(a *appContext) {
+		a.authTokens = append(a.authTokens, tokens...)
+	}
+}
+
+// WithLockPolicy sets what a request does when another request is busy with the same path, see LockPolicyWait and LockPolicyFail
+func WithLockPolicy(policy string, timeout time.Duration) Option {
+	return func(a *appContext) {
+		a.locks = newPathLocker(policy, timeout)
+	}
+}
+
+// WithRateLimits limits the bandwidth of each request and of all requests together, in bytes per second (0 is unlimited)
+func WithRateLimits(requestBytesPerSecond, globalBytesPerSecond int64) Option {
+	return func(a *appContext) {
+		a.requestRateLimit = requestBytesPerSecond
+		a.globalRateLimiter = nil
+		if globalBytesPerSecond > 0 {
+			a.globalRateLimiter = ziputils.NewRateLimiter(globalBytesPerSecond, 0)
+		}
+	}
+}
+
+// WithHooks runs the hooks (commands or webhooks) after uploads, deletes and moves
+func WithHooks(hooks ...*HookConfig) Option {
+	return func(a *appContext) {
+		for _, hook := range hooks {
+			if (hook.Command == "") == (hook.Url == "") {
+				panic(fmt.Sprintf("Hook for events %v must have exactly one of 'Command' or 'Url'", hook.Events))
+			}
+		}
+
+		allHooks := hooks
+		if a.hooks != nil {
+			allHooks = append(a.hooks.hooks, hooks...)
+		}
+		a.hooks = newHookRunner(a.logger, allHooks)
+	}
+}
+
+// WithHooksFile runs the hooks of a JSON file (see HookConfig) after uploads, deletes and moves
+func WithHooksFile(filePath string) Option {
+	return func(a *appContext) {
+		hooks := loadHooksFile(filePath)
+		a.logger.Info("Loaded %d hooks from %s", len(hooks), filePath)
+		WithHooks(hooks...)(a)
+	}
+}
+
+// WithAuditLog appends every change to the JSON lines file, which is rotated at maxSize bytes (0 never) keeping maxBackups (at least 1) old files
+func WithAuditLog(filePath string, maxSize int64, maxBackups int) Option {
+	return func(a *appContext) {
+		a.audit.close()
+		a.audit = openAuditLog(filePath, maxSize, maxBackups)
+		a.logger.Info("Writing the audit log of all changes to %s (rotated at %d bytes, %d backups)", filePath, maxSize, maxBackups)
+	}
+}
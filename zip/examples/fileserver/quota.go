@@ -0,0 +1,248 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+	"github.com/francoishill/golang-web-dry/osutils"
+	"github.com/francoishill/golang-web-dry/zip/ziputils"
+)
+
+type rootDiskUsage struct {
+	Name           string
+	Path           string
+	UsedBytes      int64
+	QuotaBytes     int64
+	AvailableBytes int64
+	DiskFreeBytes  int64
+	DiskTotalBytes int64
+}
+
+/*
+rootUsage counts the bytes used by a root with a quota, so an upload does not have to walk the whole root. It is
+measured on the first reservation after it was invalidated (see usageChanged), every change that is not an upload
+invalidates it, as does the maintenance of the roots in case the files were changed outside the server.
+*/
+type rootUsage struct {
+	mutex     sync.Mutex
+	measured  bool
+	usedBytes int64
+}
+
+func (u *rootUsage) invalidate() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.measured = false
+}
+
+// uploadPreflight tracks the space an upload needs while it is streamed into a root
+type uploadPreflight struct {
+	a        *appContext
+	root     *root
+	diskPath string //empty when the root is not on the local disk
+}
+
+// getDirectorySize sums the sizes of the files in dir, hard linked files (see blobPath) are only counted once
+func getDirectorySize(dir string) int64 {
+	var size int64 = 0
+	countedIds := map[osutils.FileId]bool{}
+	e := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				//Files could be deleted while walking
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if osutils.GetHardLinkCount(path, info) > 1 {
+			if id, ok := osutils.GetFileId(path, info); ok {
+				if countedIds[id] {
+					return nil
+				}
+				countedIds[id] = true
+			}
+		}
+		size += info.Size()
+		return nil
+	})
+	CheckError(e)
+	return size
+}
+
+// usageChanged invalidates the usage of the roots of paths, after their files were changed by anything but an upload
+func (a *appContext) usageChanged(paths ...string) {
+	for _, path := range paths {
+		if rt := a.findRoot(path); rt != nil && rt.QuotaBytes > 0 {
+			rt.usage.invalidate()
+		}
+	}
+}
+
+// checkQuota panics with a 413 error if incomingBytes more do not fit the quota of rt, without reserving them
+func (a *appContext) checkQuota(rt *root, incomingBytes int64) {
+	rt.usage.mutex.Lock()
+	defer rt.usage.mutex.Unlock()
+	a.checkQuotaLocked(rt, incomingBytes)
+}
+
+/*
+reserveQuota panics with a 413 error if incomingBytes more do not fit the quota of rt, otherwise they are added to
+its usage. The reservations of concurrent uploads are serialized, so they cannot all fit into the same free space.
+*/
+func (a *appContext) reserveQuota(rt *root, incomingBytes int64) {
+	rt.usage.mutex.Lock()
+	defer rt.usage.mutex.Unlock()
+	a.checkQuotaLocked(rt, incomingBytes)
+	rt.usage.usedBytes += incomingBytes
+}
+
+// checkQuotaLocked must be called while holding the usage mutex of rt, it measures the usage if it was invalidated
+func (a *appContext) checkQuotaLocked(rt *root, incomingBytes int64) {
+	if !rt.usage.measured {
+		rt.usage.usedBytes = getDirectorySize(rt.Path)
+		rt.usage.measured = true
+	}
+
+	if incomingBytes > 0 && rt.usage.usedBytes+incomingBytes > rt.QuotaBytes {
+		panic(newApiError(http.StatusRequestEntityTooLarge, errCodeQuotaExceeded,
+			"Storing %d more bytes would exceed the quota of root '%s' (%d of %d bytes used)", incomingBytes, rt.Name, rt.usage.usedBytes, rt.QuotaBytes))
+	}
+}
+
+// getExistingDiskPath returns the closest existing parent of path, where the disk space can be queried
+func getExistingDiskPath(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+func (a *appContext) getRootDiskUsage(rt *root) *rootDiskUsage {
+	if rt.Storage != localStorage {
+		//There is no disk to report on, only the used bytes are known
+		return &rootDiskUsage{
+			Name:      rt.Name,
+			Path:      rt.Path,
+			UsedBytes: getStorageSize(rt.Storage, rt.Path),
+		}
+	}
+
+	diskSpace := osutils.GetDiskSpace(rt.Path)
+
+	usage := &rootDiskUsage{
+		Name:           rt.Name,
+		Path:           rt.Path,
+		UsedBytes:      getDirectorySize(rt.Path),
+		QuotaBytes:     rt.QuotaBytes,
+		AvailableBytes: int64(diskSpace.FreeBytes),
+		DiskFreeBytes:  int64(diskSpace.FreeBytes),
+		DiskTotalBytes: int64(diskSpace.TotalBytes),
+	}
+
+	if rt.QuotaBytes > 0 {
+		quotaAvailable := rt.QuotaBytes - usage.UsedBytes
+		if quotaAvailable < 0 {
+			quotaAvailable = 0
+		}
+		if quotaAvailable < usage.AvailableBytes {
+			usage.AvailableBytes = quotaAvailable
+		}
+	}
+	return usage
+}
+
+func (a *appContext) handleDiskUsage(w http.ResponseWriter, r *http.Request) {
+	usages := []*rootDiskUsage{}
+	for _, rt := range a.roots {
+		usages = append(usages, a.getRootDiskUsage(rt))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(usages)
+	CheckError(err)
+}
+
+func (a *appContext) newUploadPreflight(savePath string) *uploadPreflight {
+	p := &uploadPreflight{
+		a:    a,
+		root: a.findRoot(savePath),
+	}
+	if a.isLocalStorage(savePath) {
+		p.diskPath = getExistingDiskPath(savePath)
+	}
+	return p
+}
+
+// require panics with a 413 (quota) or 507 (disk space) error if incomingBytes more cannot be stored
+func (p *uploadPreflight) require(incomingBytes int64) {
+	if incomingBytes <= 0 {
+		return
+	}
+
+	if p.root != nil && p.root.QuotaBytes > 0 {
+		p.a.checkQuota(p.root, incomingBytes)
+	}
+	p.requireDiskSpace(incomingBytes)
+}
+
+// requireDiskSpace panics with a 507 error if incomingBytes more do not fit on the disk
+func (p *uploadPreflight) requireDiskSpace(incomingBytes int64) {
+	if incomingBytes <= 0 || p.diskPath == "" {
+		//Nothing to store or not on the local disk
+		return
+	}
+	if diskSpace := osutils.GetDiskSpace(p.diskPath); uint64(incomingBytes) > diskSpace.FreeBytes {
+		panic(newApiError(http.StatusInsufficientStorage, errCodeInsufficientStorage,
+			"Storing %d more bytes requires more than the %d bytes of free disk space", incomingBytes, diskSpace.FreeBytes))
+	}
+}
+
+// requireFile checks (and reserves) the space needed to write a file of declaredSize to destinationPath
+func (p *uploadPreflight) requireFile(destinationPath string, declaredSize int64) {
+	//The tar entries are already kept inside the upload path, but not out of the trash or blob store of a root
+	p.a.checkPathAllowed(destinationPath)
+
+	incomingBytes := declaredSize
+	if info, err := p.a.storageFor(destinationPath).Stat(destinationPath); err == nil && !info.IsDir {
+		//Overwriting a file only needs the difference
+		incomingBytes -= info.Size
+	}
+	if p.root != nil && p.root.QuotaBytes > 0 {
+		//Reserved under the usage mutex, so concurrent uploads cannot all fit into the same free space
+		p.a.reserveQuota(p.root, incomingBytes)
+	}
+	p.requireDiskSpace(incomingBytes)
+}
+
+/*
+saveTarOptions checks the declared SIZE of every file before it is written. In a dedup root an existing file is
+removed before it is written (it could be a link to a blob) and every written file is moved into the blob store.
+*/
+func (p *uploadPreflight) saveTarOptions() *ziputils.SaveTarOptions {
+	options := &ziputils.SaveTarOptions{
+		BeforeFile: p.requireFile,
+		FileSystem: p.a.fileSystem(),
+	}
+
+	if p.root != nil && p.root.DedupEnabled {
+		//The saved files replace the existing ones (never written into a link to a blob), so they can be ingested once complete
+		options.AfterFile = func(destinationPath string, writtenSize int64) {
+			p.a.ingestFile(p.root, destinationPath)
+		}
+	}
+	return options
+}
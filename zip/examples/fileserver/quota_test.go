@@ -0,0 +1,65 @@
+package fileserver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/francoishill/golang-web-dry/logging/leveledlog"
+)
+
+func TestQuotaReservations(t *testing.T) {
+	Convey("Testing the quota of a root", t, func() {
+		dir, err := ioutil.TempDir("", "fileserver-quota")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		rt := &root{Name: "quota", Path: dir, QuotaBytes: 100}
+		a := &appContext{logger: leveledlog.Discard(), roots: []*root{rt}}
+
+		So(ioutil.WriteFile(filepath.Join(dir, "a.txt"), make([]byte, 40), 0644), ShouldBeNil)
+
+		Convey("Hard linked files should only be counted once", func() {
+			So(os.Link(filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")), ShouldBeNil)
+			So(getDirectorySize(dir), ShouldEqual, 40)
+		})
+
+		Convey("Concurrent uploads should not all fit into the same free space", func() {
+			var wg sync.WaitGroup
+			var mutex sync.Mutex
+			rejectedCount := 0
+			for i := 0; i < 5; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer func() {
+						if recover() != nil {
+							mutex.Lock()
+							rejectedCount++
+							mutex.Unlock()
+						}
+					}()
+					a.reserveQuota(rt, 20)
+				}()
+			}
+			wg.Wait()
+
+			So(rejectedCount, ShouldEqual, 2)
+			So(rt.usage.usedBytes, ShouldEqual, 100)
+		})
+
+		Convey("A permanent delete should measure the usage again", func() {
+			a.reserveQuota(rt, 60)
+			So(func() { a.reserveQuota(rt, 1) }, ShouldPanic)
+
+			So(os.Remove(filepath.Join(dir, "a.txt")), ShouldBeNil)
+			a.usageChanged(filepath.Join(dir, "a.txt"))
+			a.reserveQuota(rt, 100)
+			So(rt.usage.usedBytes, ShouldEqual, 100)
+		})
+	})
+}
@@ -0,0 +1,32 @@
+package fileserver
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/francoishill/golang-web-dry/zip/ziputils"
+)
+
+// replacedBody replaces the Reader of a request body (like with a rate limited or counting reader), Close still closes the original body
+type replacedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// applyRateLimits limits the request body and response of each request, and of all requests together. A keep-alive
+// connection gets a new limit for every request it sends.
+func (a *appContext) applyRateLimits(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	limiters := []*ziputils.RateLimiter{}
+	if a.requestRateLimit > 0 {
+		limiters = append(limiters, ziputils.NewRateLimiter(a.requestRateLimit, 0))
+	}
+	if a.globalRateLimiter != nil {
+		limiters = append(limiters, a.globalRateLimiter)
+	}
+
+	for _, limiter := range limiters {
+		r.Body = &replacedBody{ziputils.NewRateLimitedReader(r.Body, limiter), r.Body}
+		w = ziputils.NewRateLimitedResponseWriter(w, limiter)
+	}
+	return w
+}
@@ -0,0 +1,139 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path/filepath"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+	"github.com/francoishill/golang-web-dry/logging/leveledlog"
+	"github.com/francoishill/golang-web-dry/zip/examples/fileserver/storage"
+)
+
+/*
+storageFileSeeker lets http.ServeContent seek in a file of any Storage, it seeks to the end for the size and to the
+start of the requested range. The file is only opened by the first Read. A file that cannot seek itself (like an S3
+object) is read forward to the offset, or opened again if the offset is before the current position.
+*/
+type storageFileSeeker struct {
+	store  storage.Storage
+	path   string
+	size   int64
+	offset int64
+
+	reader       io.ReadCloser
+	readerOffset int64
+}
+
+func (f *storageFileSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		break
+	case io.SeekCurrent:
+		offset += f.offset
+		break
+	case io.SeekEnd:
+		offset += f.size
+		break
+	default:
+		return 0, errors.New("Invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("Cannot seek before the start of the file")
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// moveReader positions the reader at the offset
+func (f *storageFileSeeker) moveReader() error {
+	if f.reader != nil && f.readerOffset == f.offset {
+		return nil
+	}
+
+	if seeker, ok := f.reader.(io.Seeker); ok {
+		_, err := seeker.Seek(f.offset, io.SeekStart)
+		f.readerOffset = f.offset
+		return err
+	}
+
+	if f.reader != nil && f.readerOffset > f.offset {
+		f.reader.Close()
+		f.reader = nil
+	}
+	if f.reader == nil {
+		reader, err := f.store.Open(f.path)
+		if err != nil {
+			return err
+		}
+		f.reader, f.readerOffset = reader, 0
+
+		if _, ok := reader.(io.Seeker); ok {
+			return f.moveReader()
+		}
+	}
+
+	skipped, err := io.CopyN(ioutil.Discard, f.reader, f.offset-f.readerOffset)
+	f.readerOffset += skipped
+	return err
+}
+
+func (f *storageFileSeeker) Read(p []byte) (int, error) {
+	if err := f.moveReader(); err != nil {
+		return 0, err
+	}
+
+	n, err := f.reader.Read(p)
+	f.offset += int64(n)
+	f.readerOffset += int64(n)
+	return n, err
+}
+
+func (f *storageFileSeeker) Close() error {
+	if f.reader == nil {
+		return nil
+	}
+	return f.reader.Close()
+}
+
+// rawETag identifies the content of a file by its modification time and size, the same as most static file servers
+func rawETag(info *storage.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime.UnixNano(), info.Size)
+}
+
+/*
+handleRawDownload serves the content of a single file as is (with ?raw=1), instead of the tar the fileclient expects.
+This is for browsers, curl and partial reads: it has the Content-Type of the extension (or sniffed from the content),
+the ETag and Last-Modified headers, answers conditional requests with 304 and 412, and serves Range requests.
+*/
+func (a *appContext) handleRawDownload(w http.ResponseWriter, r *http.Request, path string) {
+	store := a.storageFor(path)
+	info, err := store.Stat(path)
+	CheckError(err)
+	if info.IsDir {
+		panic(badRequest("Cannot download directory '%s' raw, only files can be downloaded without the tar", path))
+	}
+
+	name := filepath.Base(path)
+	if contentType := mime.TypeByExtension(filepath.Ext(name)); contentType != "" {
+		//Set now so ServeContent does not read the start of the file to sniff it
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("ETag", rawETag(info))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": name}))
+
+	file := &storageFileSeeker{store: store, path: path, size: info.Size}
+	defer file.Close()
+
+	logger := a.logger.With(leveledlog.F("path", path))
+	if byteRange := r.Header.Get("Range"); byteRange != "" {
+		logger = logger.With(leveledlog.F("range", byteRange))
+	}
+	logger.Info("Sending raw file")
+	http.ServeContent(w, r, name, info.ModTime, file)
+}
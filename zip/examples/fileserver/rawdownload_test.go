@@ -0,0 +1,43 @@
+package fileserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/francoishill/golang-web-dry/zip/examples/fileserver/storage"
+)
+
+func TestStorageFileSeeker(t *testing.T) {
+	Convey("Ranges of a storage that cannot seek should be served", t, func() {
+		store := storage.NewMemoryStorage("/")
+		writer, err := store.Create("/data.bin", 0644)
+		So(err, ShouldBeNil)
+		_, err = writer.Write([]byte("abcdefghij"))
+		So(err, ShouldBeNil)
+		So(writer.Close(), ShouldBeNil)
+
+		serveRange := func(byteRange string) string {
+			file := &storageFileSeeker{store: store, path: "/data.bin", size: 10}
+			defer file.Close()
+
+			r := httptest.NewRequest("GET", "/data.bin", nil)
+			r.Header.Set("Range", byteRange)
+			w := httptest.NewRecorder()
+			http.ServeContent(w, r, "data.bin", time.Now(), file)
+			So(w.Code, ShouldEqual, http.StatusPartialContent)
+
+			body, err := ioutil.ReadAll(w.Body)
+			So(err, ShouldBeNil)
+			return string(body)
+		}
+
+		So(serveRange("bytes=3-5"), ShouldEqual, "def")
+		So(serveRange("bytes=-2"), ShouldEqual, "ij")
+		So(serveRange("bytes=0-0"), ShouldEqual, "a")
+	})
+}
@@ -0,0 +1,299 @@
+package fileserver
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/francoishill/golang-web-dry/zip/examples/fileserver/storage"
+)
+
+const rootMaintenanceInterval = 1 * time.Minute
+
+/*
+root is a directory the fileserver serves, configured with WithRoot (the repeatable --root flag of the binary) as comma
+separated key=value pairs of which the first is the name and path. For example:
+
+	--root builds=/srv/builds,quota=50GiB --root logs=/var/log/apps
+
+With trash=1 deleted paths are moved into the trash of the root (see trashItem) instead of being removed, they are
+purged once older than trashretention (for example 72h) or when the trash grows beyond trashmaxsize (for example 5GiB):
+
+	--root builds=/srv/builds,trash=1,trashretention=168h,trashmaxsize=20GiB
+
+With dedup=1 the content of uploaded files is stored once in a blob store and the files are hard links to it (see
+blobPath), which saves space when the same files are uploaded over and over.
+
+The files of a root are on the local disk, unless storage=memory (they are lost when the server stops) or storage=s3
+is given. An S3 root keeps its files in a bucket of an S3 compatible service, the credentials are read from the
+AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables:
+
+	--root archive=/archive,storage=s3,s3endpoint=https://s3.eu-west-1.amazonaws.com,s3bucket=backups,s3prefix=archive/,s3region=eu-west-1
+
+The path of such a root is only the name the clients use, nothing is stored at it on the local disk. Quotas, the
+trash, dedup, chmod, watch and tail need the real files, so they are only supported for local roots.
+
+Once any root is configured, all paths of all requests must be inside one of the roots.
+*/
+type root struct {
+	Name       string
+	Path       string
+	QuotaBytes int64
+
+	TrashEnabled   bool
+	TrashRetention time.Duration
+	TrashMaxBytes  int64
+
+	DedupEnabled bool
+
+	//usage is only kept for a root with a quota
+	usage rootUsage
+
+	//Storage is where the files are kept, localStorage unless the storage option is given
+	Storage storage.Storage
+}
+
+func parseRootFlag(value string) *root {
+	parts := strings.Split(value, ",")
+
+	nameAndPath := strings.SplitN(parts[0], "=", 2)
+	if len(nameAndPath) != 2 || nameAndPath[0] == "" || nameAndPath[1] == "" {
+		panic("Root '" + value + "' must start with name=path")
+	}
+
+	absPath, err := filepath.Abs(nameAndPath[1])
+	if err != nil {
+		panic("Root '" + value + "' has an invalid path: " + err.Error())
+	}
+
+	rt := &root{
+		Name:    nameAndPath[0],
+		Path:    absPath,
+		Storage: localStorage,
+	}
+
+	storageType := "local"
+	s3Config := &storage.S3Config{
+		AccessKeyId:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+	}
+
+	for _, option := range parts[1:] {
+		keyAndValue := strings.SplitN(option, "=", 2)
+		if len(keyAndValue) != 2 {
+			panic("Root option '" + option + "' of root '" + rt.Name + "' must be key=value")
+		}
+
+		switch strings.ToLower(keyAndValue[0]) {
+		case "quota":
+			quota, err := humanize.ParseBytes(keyAndValue[1])
+			if err != nil {
+				panic("Root option 'quota' of root '" + rt.Name + "' must be a byte size like 10GiB, got '" + keyAndValue[1] + "'")
+			}
+			rt.QuotaBytes = int64(quota)
+			break
+		case "trash":
+			trashEnabled, err := strconv.ParseBool(keyAndValue[1])
+			if err != nil {
+				panic("Root option 'trash' of root '" + rt.Name + "' must be 1 or 0, got '" + keyAndValue[1] + "'")
+			}
+			rt.TrashEnabled = trashEnabled
+			break
+		case "dedup":
+			dedupEnabled, err := strconv.ParseBool(keyAndValue[1])
+			if err != nil {
+				panic("Root option 'dedup' of root '" + rt.Name + "' must be 1 or 0, got '" + keyAndValue[1] + "'")
+			}
+			rt.DedupEnabled = dedupEnabled
+			break
+		case "trashretention":
+			retention, err := time.ParseDuration(keyAndValue[1])
+			if err != nil {
+				panic("Root option 'trashretention' of root '" + rt.Name + "' must be a duration like 72h, got '" + keyAndValue[1] + "'")
+			}
+			rt.TrashRetention = retention
+			break
+		case "trashmaxsize":
+			maxSize, err := humanize.ParseBytes(keyAndValue[1])
+			if err != nil {
+				panic("Root option 'trashmaxsize' of root '" + rt.Name + "' must be a byte size like 5GiB, got '" + keyAndValue[1] + "'")
+			}
+			rt.TrashMaxBytes = int64(maxSize)
+			break
+		case "storage":
+			storageType = strings.ToLower(keyAndValue[1])
+			break
+		case "s3endpoint":
+			s3Config.Endpoint = keyAndValue[1]
+			break
+		case "s3bucket":
+			s3Config.Bucket = keyAndValue[1]
+			break
+		case "s3prefix":
+			s3Config.Prefix = keyAndValue[1]
+			break
+		case "s3region":
+			s3Config.Region = keyAndValue[1]
+			break
+		default:
+			panic("Unknown root option '" + keyAndValue[0] + "' of root '" + rt.Name + "'")
+		}
+	}
+
+	switch storageType {
+	case "local":
+		break
+	case "memory":
+		rt.Storage = storage.NewMemoryStorage(rt.Path)
+		break
+	case "s3":
+		if s3Config.Endpoint == "" || s3Config.Bucket == "" {
+			panic("Root '" + rt.Name + "' with storage=s3 needs the 's3endpoint' and 's3bucket' options")
+		}
+		rt.Storage = storage.NewS3Storage(rt.Path, s3Config)
+		break
+	default:
+		panic("Root option 'storage' of root '" + rt.Name + "' must be local, memory or s3, got '" + storageType + "'")
+	}
+
+	if rt.Storage != localStorage && (rt.QuotaBytes > 0 || rt.TrashEnabled || rt.DedupEnabled) {
+		panic("Root '" + rt.Name + "' cannot use quota, trash or dedup with storage=" + storageType + ", they are only supported on the local disk")
+	}
+
+	return rt
+}
+
+func (rt *root) contains(cleanPath string) bool {
+	return cleanPath == rt.Path || strings.HasPrefix(cleanPath, strings.TrimRight(rt.Path, `/\`)+string(filepath.Separator))
+}
+
+// findRoot returns the most specific root containing path, or nil when no roots are configured
+func (a *appContext) findRoot(path string) *root {
+	if len(a.roots) == 0 {
+		return nil
+	}
+
+	cleanPath := filepath.Clean(path)
+	var found *root
+	for _, rt := range a.roots {
+		if rt.contains(cleanPath) && (found == nil || len(rt.Path) > len(found.Path)) {
+			found = rt
+		}
+	}
+
+	if found == nil {
+		panic(newApiError(http.StatusForbidden, errCodePermissionDenied, "Path '%s' is not inside any of the served roots", path))
+	}
+	return found
+}
+
+func (a *appContext) findRootByName(name string) *root {
+	for _, rt := range a.roots {
+		if rt.Name == name {
+			return rt
+		}
+	}
+	panic(newApiError(http.StatusNotFound, errCodeNotFound, "Root '%s' does not exist", name))
+}
+
+// checkPathAllowed panics if roots are configured and path is not inside any of them (or is inside a trash)
+func (a *appContext) checkPathAllowed(path string) string {
+	a.findRoot(path)
+	a.checkNotReserved(path)
+	return path
+}
+
+// checkNotReserved panics if path is inside the trash or blob store of a root, these are only changed by the server itself
+func (a *appContext) checkNotReserved(path string) {
+	cleanPath := filepath.Clean(path)
+	for _, rt := range a.roots {
+		if rt.TrashEnabled && isSameOrChildPath(rt.trashDir(), cleanPath) {
+			panic(newApiError(http.StatusForbidden, errCodePermissionDenied, "Path '%s' is inside the trash of root '%s'", path, rt.Name))
+		}
+		if rt.DedupEnabled && isSameOrChildPath(rt.blobsDir(), cleanPath) {
+			panic(newApiError(http.StatusForbidden, errCodePermissionDenied, "Path '%s' is inside the blob store of root '%s'", path, rt.Name))
+		}
+	}
+}
+
+// localStorage is shared by all local roots, so a move between two local roots is a plain rename
+var localStorage = storage.NewLocalStorage()
+
+// storageFor returns the storage of the root of path, the local disk when no roots are configured
+func (a *appContext) storageFor(path string) storage.Storage {
+	if rt := a.findRoot(path); rt != nil && rt.Storage != nil {
+		return rt.Storage
+	}
+	return localStorage
+}
+
+func (a *appContext) isLocalStorage(path string) bool {
+	return a.storageFor(path) == localStorage
+}
+
+// requireLocalStorage panics with a 400 error if path is not stored on the local disk, for the features that need the real files
+func (a *appContext) requireLocalStorage(path, feature string) {
+	if !a.isLocalStorage(path) {
+		panic(badRequest("%s is only supported for roots on the local disk, '%s' is not", feature, path))
+	}
+}
+
+// checkSameStorage panics with a 400 error if a move between the two paths would have to copy between different storages
+func (a *appContext) checkSameStorage(oldPath, newPath string) {
+	if a.storageFor(oldPath) != a.storageFor(newPath) {
+		panic(newApiError(http.StatusBadRequest, errCodeBadRequest, "Cannot move '%s' to '%s', they are in different storages (copy and delete instead)", oldPath, newPath))
+	}
+}
+
+// isReservedPath is true if path is inside the trash or blob store of a root
+func (a *appContext) isReservedPath(path string) bool {
+	cleanPath := filepath.Clean(path)
+	for _, rt := range a.roots {
+		if rt.TrashEnabled && isSameOrChildPath(rt.trashDir(), cleanPath) {
+			return true
+		}
+		if rt.DedupEnabled && isSameOrChildPath(rt.blobsDir(), cleanPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnreservedPath is the IncludeFile and IncludeDir of the ziputils walks, so they never walk into the trash or blob store of a root
+func (a *appContext) isUnreservedPath(path string, info os.FileInfo) bool {
+	return !a.isReservedPath(path)
+}
+
+/*
+maintainRootsPeriodically purges the trash and removes the unreferenced blobs of all roots until stop is closed. The
+usage of the roots with a quota is measured again, in case their files were changed outside the server.
+*/
+func (a *appContext) maintainRootsPeriodically(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		for _, rt := range a.roots {
+			if rt.TrashEnabled {
+				a.purgeTrashOfRoot(rt)
+			}
+			//After purging the trash, its items could have been the last links to some blobs
+			if rt.DedupEnabled {
+				a.collectBlobGarbageOfRoot(rt)
+			}
+			if rt.QuotaBytes > 0 {
+				rt.usage.invalidate()
+			}
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package fileserver
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/codegangsta/negroni"
+	"github.com/francoishill/golang-web-dry/logging/leveledlog"
+	"github.com/francoishill/golang-web-dry/middleware/accessloggingmiddleware"
+	"github.com/francoishill/golang-web-dry/middleware/recoverymiddleware"
+	"github.com/francoishill/golang-web-dry/requests/requesturlparams/gorillamuxurlparams"
+	"github.com/francoishill/golang-web-dry/router/negroni_mux_routers"
+	"github.com/gorilla/mux"
+)
+
+/*
+The REST routes address paths by the name of their root, for example GET /files/builds/1.2/app.zip downloads
+app.zip of the 1.2 directory of the root named 'builds'. They are translated into the query parameters of the
+legacy handler (which is still served at / for existing clients), so both behave exactly the same:
+
+	GET    /files/{root}/{path}                    download (tar), ?raw=1 for the file as is (with Range support), or ?action=list / ?action=find / ?action=watch / ?action=tail
+	HEAD   /files/{root}/{path}                    stats in the EXISTS and IS_DIR headers (and DELETE_DRY_RUN=1), or with ?raw=1 the headers of the raw download
+	POST   /files/{root}/{path}[?isdir=1]          upload (tar), or ?action=dedup
+	PUT    /files/{root}/{path}?action=...         move, copy, mkdir, chmod and touch, newpath is relative to the root (or to newroot)
+	DELETE /files/{root}/{path}                    delete, with filefilter, dryrun and removeemptydirs
+	GET    /trash/{root}                           list the trash items
+	PUT    /trash/{root}/{id}[?newpath=...]        restore a trash item
+	GET    /diskusage                              disk usage of all roots
+	POST   /batch                                  a batch of operations (with absolute paths, like the legacy API)
+	GET    /metrics                                the metrics in the Prometheus text format, see metrics.go
+
+The REST routes need roots (see WithRoot), without roots there is nothing to name in the URLs.
+*/
+
+type filesController struct {
+	a *appContext
+}
+
+func (c *filesController) RelativeURLPatterns() []string {
+	return []string{"/files/{root}", "/files/{root}/{path:.*}"}
+}
+
+func (c *filesController) Get(w http.ResponseWriter, r *http.Request) {
+	c.a.serveLegacy(w, r, map[string]string{"path": c.a.getRestPath(r)})
+}
+
+func (c *filesController) Head(w http.ResponseWriter, r *http.Request) {
+	c.a.serveLegacy(w, r, map[string]string{"path": c.a.getRestPath(r)})
+}
+
+func (c *filesController) Post(w http.ResponseWriter, r *http.Request) {
+	pathParamName := "path"
+	if c.a.getBoolQueryValue(r, "isdir") {
+		pathParamName = "dir"
+	}
+	c.a.serveLegacy(w, r, map[string]string{pathParamName: c.a.getRestPath(r)})
+}
+
+func (c *filesController) Put(w http.ResponseWriter, r *http.Request) {
+	params := map[string]string{"path": c.a.getRestPath(r)}
+	if newPath := c.a.getRestNewPath(r); newPath != "" {
+		params["newpath"] = newPath
+	}
+	c.a.serveLegacy(w, r, params)
+}
+
+func (c *filesController) Delete(w http.ResponseWriter, r *http.Request) {
+	c.a.serveLegacy(w, r, map[string]string{"path": c.a.getRestPath(r)})
+}
+
+type trashController struct {
+	a *appContext
+}
+
+func (c *trashController) RelativeURLPatterns() []string {
+	return []string{"/trash/{root}"}
+}
+
+func (c *trashController) Get(w http.ResponseWriter, r *http.Request) {
+	c.a.serveLegacy(w, r, map[string]string{
+		"action": "trash",
+		"root":   gorillamuxurlparams.MustGetUrlParamValue_String(r, "root"),
+	})
+}
+
+type trashItemController struct {
+	a *appContext
+}
+
+func (c *trashItemController) RelativeURLPatterns() []string {
+	return []string{"/trash/{root}/{id}"}
+}
+
+func (c *trashItemController) Put(w http.ResponseWriter, r *http.Request) {
+	params := map[string]string{
+		"action": "restore",
+		"root":   gorillamuxurlparams.MustGetUrlParamValue_String(r, "root"),
+		"id":     gorillamuxurlparams.MustGetUrlParamValue_String(r, "id"),
+	}
+	if newPath := c.a.getRestNewPath(r); newPath != "" {
+		params["newpath"] = newPath
+	}
+	c.a.serveLegacy(w, r, params)
+}
+
+type diskUsageController struct {
+	a *appContext
+}
+
+func (c *diskUsageController) RelativeURLPatterns() []string {
+	return []string{"/diskusage"}
+}
+
+func (c *diskUsageController) Get(w http.ResponseWriter, r *http.Request) {
+	c.a.serveLegacy(w, r, map[string]string{"action": "diskusage"})
+}
+
+type batchController struct {
+	a *appContext
+}
+
+func (c *batchController) RelativeURLPatterns() []string {
+	return []string{"/batch"}
+}
+
+func (c *batchController) Post(w http.ResponseWriter, r *http.Request) {
+	c.a.serveLegacy(w, r, map[string]string{"action": "batch"})
+}
+
+type metricsController struct {
+	a *appContext
+}
+
+func (c *metricsController) RelativeURLPatterns() []string {
+	return []string{"/metrics"}
+}
+
+func (c *metricsController) Get(w http.ResponseWriter, r *http.Request) {
+	c.a.handleMetrics(w, r)
+}
+
+// legacyController serves the original query parameter API, for example PUT /?action=move&path=...&newpath=...
+type legacyController struct {
+	a *appContext
+}
+
+func (c *legacyController) RelativeURLPatterns() []string {
+	return []string{"/"}
+}
+
+func (c *legacyController) Get(w http.ResponseWriter, r *http.Request)    { c.a.handler(w, r) }
+func (c *legacyController) Head(w http.ResponseWriter, r *http.Request)   { c.a.handler(w, r) }
+func (c *legacyController) Post(w http.ResponseWriter, r *http.Request)   { c.a.handler(w, r) }
+func (c *legacyController) Put(w http.ResponseWriter, r *http.Request)    { c.a.handler(w, r) }
+func (c *legacyController) Delete(w http.ResponseWriter, r *http.Request) { c.a.handler(w, r) }
+
+// joinRootPath returns the absolute path of the root relative relPath, it panics if relPath tries to escape the root
+func joinRootPath(rt *root, relPath string) string {
+	fullPath := filepath.Join(rt.Path, filepath.FromSlash(strings.TrimLeft(relPath, `/\`)))
+	if !rt.contains(fullPath) {
+		panic(newApiError(http.StatusForbidden, errCodePermissionDenied, "Path '%s' is not inside root '%s'", relPath, rt.Name))
+	}
+	return fullPath
+}
+
+// getRestPath returns the absolute path of the {root} and {path} of a REST route
+func (a *appContext) getRestPath(r *http.Request) string {
+	rt := a.findRootByName(gorillamuxurlparams.MustGetUrlParamValue_String(r, "root"))
+	return joinRootPath(rt, mux.Vars(r)["path"])
+}
+
+// getRestNewPath returns the absolute path of the 'newpath' query parameter (relative to 'newroot', or else to the {root} of the route), empty if not given
+func (a *appContext) getRestNewPath(r *http.Request) string {
+	newPath := r.URL.Query().Get("newpath")
+	if newPath == "" {
+		return ""
+	}
+
+	rootName := r.URL.Query().Get("newroot")
+	if rootName == "" {
+		rootName = gorillamuxurlparams.MustGetUrlParamValue_String(r, "root")
+	}
+	return joinRootPath(a.findRootByName(rootName), newPath)
+}
+
+// serveLegacy lets the legacy handler serve a REST request, params replace the query parameters with the same names
+func (a *appContext) serveLegacy(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	query := r.URL.Query()
+	query.Del("newroot")
+	query.Del("isdir")
+	for name, value := range params {
+		query.Set(name, value)
+	}
+
+	r.URL.RawQuery = query.Encode()
+	r.Form = nil //So the legacy handler parses the new query
+	a.handler(w, r)
+}
+
+// onRecoveredError writes the panics that escaped the handlers (like an unknown root in a REST route) the same way as the handler does
+func (a *appContext) onRecoveredError(errDetails *recoverymiddleware.RecoveredErrorDetails) *recoverymiddleware.RecoveryResponse {
+	apiErr := toApiError(errDetails.OriginalError)
+	a.logger.Error("ERROR in router: %s", errDetails.Error)
+	if apiErr.StatusCode == http.StatusInternalServerError {
+		a.logger.Error("Stack: %s", errDetails.StackTrace)
+	}
+	return &recoverymiddleware.RecoveryResponse{
+		StatusCode:         apiErr.StatusCode,
+		JsonResponseObject: apiErr,
+	}
+}
+
+func (a *appContext) newAccessInfoHandler() accessloggingmiddleware.AccessInfoHandler {
+	return accessloggingmiddleware.NewSimpleAccessInfoHandler(
+		func(info *accessloggingmiddleware.StartAccessInfo) {
+			a.logger.Debug("Started %s %s for %s", info.HttpMethod, info.RequestURI, info.RemoteIP)
+		},
+		func(info *accessloggingmiddleware.EndAccessInfo) {
+			a.logger.With(leveledlog.F("status", info.Status), leveledlog.F("duration", info.Duration)).Info("Completed %s %s for %s", info.HttpMethod, info.RequestURI, info.RemoteIP)
+		},
+	)
+}
+
+// newRouter returns the handler of all the REST and legacy routes, behind the recovery, access logging and authentication middlewares
+func (a *appContext) newRouter() http.Handler {
+	router := mux.NewRouter()
+
+	negroni_mux_routers.RegisterRouters(router, nil, []*negroni_mux_routers.Router{
+		negroni_mux_routers.NewRouterBuilder().SetController(&filesController{a}).Build(),
+		negroni_mux_routers.NewRouterBuilder().SetController(&trashController{a}).Build(),
+		negroni_mux_routers.NewRouterBuilder().SetController(&trashItemController{a}).Build(),
+		negroni_mux_routers.NewRouterBuilder().SetController(&diskUsageController{a}).Build(),
+		negroni_mux_routers.NewRouterBuilder().SetController(&batchController{a}).Build(),
+		negroni_mux_routers.NewRouterBuilder().SetController(&metricsController{a}).Build(),
+		negroni_mux_routers.NewRouterBuilder().SetController(&legacyController{a}).Build(),
+	})
+
+	n := negroni.New(
+		recoverymiddleware.NewRecovery(a.onRecoveredError),
+		accessloggingmiddleware.NewAccessLoggingMiddleware(a.newAccessInfoHandler()),
+		negroni.HandlerFunc(a.authenticate),
+	)
+	n.UseHandler(router)
+	return n
+}
@@ -0,0 +1,412 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+	"github.com/francoishill/golang-web-dry/errors/stacktraces/prettystacktrace"
+	"github.com/francoishill/golang-web-dry/logging/leveledlog"
+	"github.com/francoishill/golang-web-dry/zip/ziputils"
+)
+
+/*
+Server is an http.Handler serving the uploads, downloads and other operations of the fileclient package on the files
+of its roots, see routes.go for the routes. Close (or Shutdown) stops its background work once it is no longer served.
+*/
+type Server struct {
+	a            *appContext
+	handler      http.Handler
+	shutdownOnce sync.Once
+}
+
+type appContext struct {
+	logger leveledlog.Logger
+	hooks  *hookRunner
+	audit  *auditLog
+	roots  []*root
+
+	transfers *transferTracker
+	locks     *pathLocker
+	metrics   *metrics
+
+	//authTokens are the accepted bearer tokens, all requests are allowed if there are none
+	authTokens []string
+
+	trashMutex sync.Mutex
+	blobMutex  sync.Mutex
+
+	requestRateLimit  int64
+	globalRateLimiter *ziputils.RateLimiter
+}
+
+/*
+New creates a Server configured with the options, it serves all paths of the machine unless roots are given. For example
+to serve a directory to the holders of a token:
+
+	server, err := fileserver.New(logger,
+		fileserver.WithRoot("builds=/srv/builds,quota=50GiB"),
+		fileserver.WithAuthTokens(token))
+	CheckError(err)
+	defer server.Close()
+	http.ListenAndServe(":60878", server)
+
+The routes are absolute (like /files/builds/...), mount the Server under a prefix with http.StripPrefix.
+*/
+func New(logger leveledlog.Logger, options ...Option) (s *Server, returnErr error) {
+	a := &appContext{
+		logger:    logger,
+		transfers: newTransferTracker(),
+		locks:     newPathLocker(LockPolicyWait, 0),
+		metrics:   newMetrics(),
+	}
+
+	defer func() {
+		if returnErr != nil {
+			a.audit.close()
+		}
+	}()
+	defer catchPanicAsError(&returnErr)
+
+	for _, option := range options {
+		option(a)
+	}
+
+	if len(a.authTokens) > 0 {
+		logger.Info("Requiring one of %d bearer tokens for all requests", len(a.authTokens))
+	}
+	for _, rt := range a.roots {
+		logger.Info("Serving root '%s' at %s (quota %d bytes, %T)", rt.Name, rt.Path, rt.QuotaBytes, rt.Storage)
+		if rt.TrashEnabled {
+			logger.Info("Root '%s' moves deleted paths to its trash (retention %s, max size %d bytes)", rt.Name, rt.TrashRetention, rt.TrashMaxBytes)
+		}
+		if rt.DedupEnabled {
+			logger.Info("Root '%s' deduplicates file contents in %s", rt.Name, rt.blobsDir())
+		}
+	}
+	go a.maintainRootsPeriodically(rootMaintenanceInterval, a.transfers.shutdownChannel())
+
+	return &Server{
+		a:       a,
+		handler: a.transfers.trackRequests(a.newRouter()),
+	}, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Close stops the background maintenance of the roots and the running watch and tail streams, and closes the audit log
+func (s *Server) Close() {
+	s.startShutdown()
+	s.a.audit.close()
+}
+
+// startShutdown closes the shutdown channel of the transfers (see shutdownChannel), only the first call does
+func (s *Server) startShutdown() {
+	s.shutdownOnce.Do(func() {
+		close(s.a.transfers.shuttingDown)
+	})
+}
+
+func (a *appContext) recoveryFunc(w http.ResponseWriter, req *http.Request, errorMessageSinglePlaceholder string) {
+	if r := recover(); r != nil {
+		apiErr := toApiError(r)
+		a.metrics.observeError(req, r, apiErr)
+		auditRecordOf(req).fail(apiErr)
+		a.logger.Error(errorMessageSinglePlaceholder, r)
+		if apiErr.StatusCode == http.StatusInternalServerError {
+			a.logger.Error("Stack: %s", prettystacktrace.GetPrettyStackTrace())
+		}
+		writeApiError(w, apiErr)
+		req.Body.Close()
+	}
+}
+
+func (a *appContext) getPathFromRequest(r *http.Request) string {
+	err := r.ParseForm()
+	CheckError(err)
+
+	path := r.FormValue("path")
+	if path == "" {
+		panic(badRequest("Cannot find 'path' query parameter..."))
+	}
+	return a.checkPathAllowed(strings.TrimRight(path, ` /\`))
+}
+
+func (a *appContext) getNewPathFromRequest(r *http.Request) string {
+	return a.checkPathAllowed(strings.TrimRight(a.getRequiredQueryValue(r, "newpath"), ` /\`))
+}
+
+func (a *appContext) getRequiredQueryValue(r *http.Request, keyName string) string {
+	err := r.ParseForm()
+	CheckError(err)
+
+	val := r.FormValue(keyName)
+	if val == "" {
+		panic(badRequest("Cannot find '%s' query parameter...", keyName))
+	}
+	return val
+}
+
+func (a *appContext) getBoolQueryValue(r *http.Request, keyName string) bool {
+	err := r.ParseForm()
+	CheckError(err)
+
+	val := strings.ToLower(r.FormValue(keyName))
+	return val == "1" || val == "true"
+}
+
+func (a *appContext) getFileModeQueryValue(r *http.Request, keyName string, defaultMode os.FileMode) os.FileMode {
+	err := r.ParseForm()
+	CheckError(err)
+
+	val := r.FormValue(keyName)
+	if val == "" {
+		return defaultMode
+	}
+
+	mode, err := strconv.ParseUint(val, 8, 32)
+	if err != nil {
+		panic(badRequest("Query parameter '%s' must be an octal file mode, got '%s'", keyName, val))
+	}
+	return os.FileMode(mode)
+}
+
+func (a *appContext) getFileOrFolderFromRequest(r *http.Request) (path string, isDir bool) {
+	err := r.ParseForm()
+	CheckError(err)
+
+	saveFilePath := r.FormValue("path")
+	saveDirPath := r.FormValue("dir")
+	if saveFilePath == "" && saveDirPath == "" {
+		panic(badRequest("Cannot find 'file' or 'dir' query parameters..."))
+	} else if saveFilePath != "" && saveDirPath != "" {
+		panic(badRequest("Cannot specify both 'file' or 'dir' query parameters..."))
+	}
+
+	if saveFilePath != "" {
+		path = a.checkPathAllowed(saveFilePath)
+		isDir = false
+		return
+	} else {
+		path = a.checkPathAllowed(saveDirPath)
+		isDir = true
+		return
+	}
+}
+
+func (a *appContext) getDirFileFilterPatternFromRequest(r *http.Request) string {
+	err := r.ParseForm()
+	CheckError(err)
+
+	return r.FormValue("filefilter")
+}
+
+func (a *appContext) isDir(path string) bool {
+	info, err := a.storageFor(path).Stat(path)
+	CheckError(err)
+	return info.IsDir
+}
+
+func (a *appContext) handler(w http.ResponseWriter, r *http.Request) {
+	r = a.startAudit(r)
+	defer a.finishAudit(r)
+
+	metricsWriter := a.metrics.startRequest(w, r)
+	defer metricsWriter.finish()
+	w = metricsWriter
+
+	defer a.recoveryFunc(w, r, "ERROR in handler: %+v")
+
+	w = a.applyRateLimits(w, r)
+
+	if r.Method == "POST" {
+		if action := r.URL.Query().Get("action"); action != "" {
+			switch strings.ToLower(action) {
+			case "batch":
+				a.handleBatch(w, r)
+				break
+			case "dedup":
+				a.handleDedupUpload(w, r)
+				break
+			default:
+				panic(badRequest("Unsupported action '%s'", action))
+			}
+			return
+		}
+
+		path, isDir := a.getFileOrFolderFromRequest(r)
+		defer a.locks.acquire(r.Context().Done(), writeLock(path))()
+
+		preflight := a.newUploadPreflight(path)
+		if r.ContentLength > 0 {
+			preflight.require(r.ContentLength)
+		}
+
+		logger := a.logger.With(leveledlog.F("path", path))
+		body := &countingReader{reader: r.Body}
+		saveOptions := auditRecordOf(r).countFiles(preflight.saveTarOptions())
+		if isDir {
+			logger.Info("Receiving directory (zipped)")
+			ziputils.SaveTarReaderToPathWithOptions(logger, body, path, saveOptions)
+		} else {
+			logger.Info("Receiving file")
+			ziputils.SaveTarReaderToPathWithOptions(logger, body, path, saveOptions)
+		}
+		logger.With(leveledlog.F("bytes", body.count)).Info("Received")
+		a.hooks.fire(newHookEvent(r, hookEventUpload, body.count, path))
+	} else if r.Method == "GET" {
+		action := strings.ToLower(r.FormValue("action"))
+		if action == "diskusage" {
+			a.handleDiskUsage(w, r)
+			return
+		}
+		if action == "trash" {
+			a.handleListTrash(w, r)
+			return
+		}
+		if action == "archive" {
+			a.handleArchive(w, r)
+			return
+		}
+
+		path := a.getPathFromRequest(r)
+
+		switch action {
+		case "":
+			break
+		case "watch":
+			a.requireLocalStorage(path, "Watching")
+			a.handleWatch(w, r, path)
+			return
+		case "list":
+			a.handleList(w, r, path)
+			return
+		case "find":
+			a.handleFind(w, r, path)
+			return
+		case "tail":
+			a.requireLocalStorage(path, "Tailing")
+			a.handleTail(w, r, path)
+			return
+		default:
+			panic(badRequest("Unsupported action '%s'", action))
+		}
+
+		defer a.locks.acquire(r.Context().Done(), readLock(path))()
+
+		if a.getBoolQueryValue(r, "raw") {
+			a.handleRawDownload(w, r, path)
+			return
+		}
+
+		logger := a.logger.With(leveledlog.F("path", path))
+		if a.isDir(path) {
+			logger.Info("Sending directory")
+			walkContext := ziputils.NewDirWalkContext(a.getDirFileFilterPatternFromRequest(r))
+			walkContext.IncludeFile, walkContext.IncludeDir = a.isUnreservedPath, a.isUnreservedPath
+			walkContext.FileSystem = a.fileSystem()
+			ziputils.UploadDirectoryToHttpResponseWriter(logger, w, path, walkContext)
+		} else {
+			logger.Info("Sending file")
+			ziputils.UploadFileToHttpResponseWriterWithFileSystem(logger, w, a.fileSystem(), path)
+		}
+	} else if r.Method == "DELETE" {
+		path := a.getPathFromRequest(r)
+		options := &ziputils.DeleteOptions{
+			DryRun:          a.getBoolQueryValue(r, "dryrun"),
+			RemoveEmptyDirs: a.getBoolQueryValue(r, "removeemptydirs"),
+		}
+
+		if options.DryRun {
+			defer a.locks.acquire(r.Context().Done(), readLock(path))()
+		} else {
+			defer a.locks.acquire(r.Context().Done(), writeLock(path))()
+		}
+
+		result := a.deletePath(r, path, a.getDirFileFilterPatternFromRequest(r), options)
+		auditRecordOf(r).setDryRun(options.DryRun)
+		auditRecordOf(r).addFiles(len(result.DeletedPaths))
+		if !options.DryRun {
+			a.hooks.fire(newHookEvent(r, hookEventDelete, 0, path))
+		}
+
+		w.Header().Set("DELETE_DRY_RUN", "1")
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(result)
+		CheckError(err)
+	} else if r.Method == "PUT" {
+		action := a.getRequiredQueryValue(r, "action")
+		switch strings.ToLower(action) {
+		case "move":
+			oldPath := a.getPathFromRequest(r)
+			newPath := a.getNewPathFromRequest(r)
+			defer a.locks.acquire(r.Context().Done(), writeLock(oldPath), writeLock(newPath))()
+			a.movePath(oldPath, newPath)
+			a.hooks.fire(newHookEvent(r, hookEventMove, 0, oldPath, newPath))
+			break
+		case "copy":
+			srcPath := a.getPathFromRequest(r)
+			destPath := a.getNewPathFromRequest(r)
+			defer a.locks.acquire(r.Context().Done(), readLock(srcPath), writeLock(destPath))()
+			a.copyPath(srcPath, destPath, a.getDirFileFilterPatternFromRequest(r))
+			break
+		case "mkdir":
+			path := a.getPathFromRequest(r)
+			mode := a.getFileModeQueryValue(r, "mode", 0755)
+			defer a.locks.acquire(r.Context().Done(), writeLock(path))()
+			a.makeDirectory(path, a.getBoolQueryValue(r, "parents"), mode)
+			break
+		case "chmod":
+			path := a.getPathFromRequest(r)
+			a.getRequiredQueryValue(r, "mode")
+			defer a.locks.acquire(r.Context().Done(), writeLock(path))()
+			a.changeMode(path, a.getFileModeQueryValue(r, "mode", 0))
+			break
+		case "touch":
+			path := a.getPathFromRequest(r)
+			defer a.locks.acquire(r.Context().Done(), writeLock(path))()
+			a.touchFile(path)
+			break
+		case "restore":
+			a.handleRestoreTrash(w, r)
+			break
+		default:
+			panic(badRequest("Unsupported action '%s'", action))
+		}
+	} else if r.Method == "HEAD" {
+		path := a.getPathFromRequest(r)
+		if a.getBoolQueryValue(r, "raw") {
+			//The headers of the raw download, like its Content-Length, instead of the stats
+			defer a.locks.acquire(r.Context().Done(), readLock(path))()
+			a.handleRawDownload(w, r, path)
+			return
+		}
+		stats := a.getStats(path)
+
+		//Lets clients check that a DELETE with dryrun=1 will not delete anything, older servers ignore the parameter
+		w.Header().Set("DELETE_DRY_RUN", "1")
+
+		if !stats.Exists {
+			w.Header().Set("EXISTS", "0")
+			return
+		}
+
+		w.Header().Set("EXISTS", "1")
+
+		if stats.IsDir {
+			w.Header().Set("IS_DIR", "1")
+		} else {
+			w.Header().Set("IS_DIR", "0")
+		}
+	} else {
+		panic(newApiError(http.StatusMethodNotAllowed, errCodeBadRequest, "Unsupported method %s", r.Method))
+	}
+}
+
+// getLogger returns the logger and a function to flush and close its rolling log file
@@ -0,0 +1,670 @@
+package fileserver_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/francoishill/golang-web-dry/logging/leveledlog"
+	"github.com/francoishill/golang-web-dry/zip/examples/fileclient"
+	"github.com/francoishill/golang-web-dry/zip/examples/fileserver"
+	"github.com/francoishill/golang-web-dry/zip/ziputils"
+)
+
+// lockedBuffer is written by the Tail goroutine while the test reads it
+type lockedBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
+}
+
+func waitFor(condition func() bool) bool {
+	for start := time.Now(); time.Now().Sub(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if condition() {
+			return true
+		}
+	}
+	return false
+}
+
+func TestServer(t *testing.T) {
+	Convey("Testing the fileserver with fileclient", t, func() {
+		dir, err := ioutil.TempDir("", "fileserver")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		local := func(relPath string) string { return filepath.Join(dir, "local", relPath) }
+		remote := func(relPath string) string { return filepath.Join(dir, "remote", relPath) }
+		writeLocal := func(relPath, content string) {
+			So(os.MkdirAll(filepath.Dir(local(relPath)), 0755), ShouldBeNil)
+			So(ioutil.WriteFile(local(relPath), []byte(content), 0644), ShouldBeNil)
+		}
+		readFile := func(path string) string {
+			content, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			return string(content)
+		}
+		exists := func(path string) bool {
+			_, err := os.Stat(path)
+			return err == nil
+		}
+
+		So(os.MkdirAll(remote(""), 0755), ShouldBeNil)
+		So(os.MkdirAll(filepath.Join(dir, "dedup"), 0755), ShouldBeNil)
+
+		server, err := fileserver.New(leveledlog.Discard(),
+			fileserver.WithRoot("remote="+remote("")+",trash=1"),
+			fileserver.WithRoot("dedup="+filepath.Join(dir, "dedup")+",dedup=1"),
+			fileserver.WithAuthTokens("secret"))
+		So(err, ShouldBeNil)
+		defer server.Close()
+
+		httpServer := httptest.NewServer(server)
+		defer httpServer.Close()
+
+		serverUrl := httpServer.URL
+		client := fileclient.New(leveledlog.Discard(), fileclient.WithBearerToken("secret"))
+
+		Convey("Invalid options should fail New", func() {
+			_, err := fileserver.New(leveledlog.Discard(), fileserver.WithRoot("no-path"))
+			So(err, ShouldNotBeNil)
+
+			_, err = fileserver.New(leveledlog.Discard(), fileserver.WithLockPolicy("sometimes", 0))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Requests without the token should be unauthorized", func() {
+			_, err := fileclient.New(leveledlog.Discard()).Stats(serverUrl, remote("a.txt"))
+			So(fileclient.IsUnauthorized(err), ShouldBeTrue)
+		})
+
+		Convey("Paths outside the roots should be denied", func() {
+			_, err := client.Stats(serverUrl, filepath.Join(dir, "local"))
+			So(fileclient.IsPermissionDenied(err), ShouldBeTrue)
+		})
+
+		Convey("Files and directories should be uploaded and downloaded", func() {
+			writeLocal("a.txt", "hello")
+			writeLocal("src/b.log", "b")
+			writeLocal("src/c.txt", "c")
+
+			So(client.Upload(serverUrl, local("a.txt"), remote("a.txt")), ShouldBeNil)
+			So(readFile(remote("a.txt")), ShouldEqual, "hello")
+
+			So(client.UploadDirFiltered(serverUrl, local("src"), remote("src"), "*.log"), ShouldBeNil)
+			So(exists(remote("src/b.log")), ShouldBeTrue)
+			So(exists(remote("src/c.txt")), ShouldBeFalse)
+
+			So(client.Download(serverUrl, local("downloaded.txt"), remote("a.txt")), ShouldBeNil)
+			So(readFile(local("downloaded.txt")), ShouldEqual, "hello")
+
+			So(client.DownloadDirFiltered(serverUrl, local("downloaded"), remote("src"), ""), ShouldBeNil)
+			So(readFile(local("downloaded/b.log")), ShouldEqual, "b")
+
+			So(client.UploadPaths(serverUrl, []string{local("a.txt"), local("src")}, remote("many"), ""), ShouldBeNil)
+			So(exists(remote("many/a.txt")), ShouldBeTrue)
+			So(exists(remote("many/src/c.txt")), ShouldBeTrue)
+
+			So(client.DownloadPaths(serverUrl, []string{remote("a.txt"), remote("src")}, local("many"), ""), ShouldBeNil)
+			So(exists(local("many/a.txt")), ShouldBeTrue)
+			So(exists(local("many/src/b.log")), ShouldBeTrue)
+
+			err := client.Download(serverUrl, local("missing.txt"), remote("missing.txt"))
+			So(fileclient.IsNotFound(err), ShouldBeTrue)
+		})
+
+		Convey("Uploaded tar entries should not be written outside the upload path or into the trash", func() {
+			upload := func(uploadDir, name string) int {
+				buffer := &bytes.Buffer{}
+				tarWriter := tar.NewWriter(buffer)
+				for _, entryName := range []string{name, ziputils.END_OF_TAR_FILENAME} {
+					So(tarWriter.WriteHeader(&tar.Header{Name: entryName, Mode: 0644, Size: 1}), ShouldBeNil)
+					_, err := tarWriter.Write([]byte("x"))
+					So(err, ShouldBeNil)
+				}
+				So(tarWriter.Close(), ShouldBeNil)
+
+				req, err := http.NewRequest("POST", serverUrl+"/?dir="+url.QueryEscape(uploadDir), buffer)
+				So(err, ShouldBeNil)
+				req.Header.Set("Authorization", "Bearer secret")
+				resp, err := http.DefaultClient.Do(req)
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				return resp.StatusCode
+			}
+
+			So(upload(remote("up"), "../../escaped.txt"), ShouldEqual, http.StatusBadRequest)
+			So(exists(filepath.Join(dir, "escaped.txt")), ShouldBeFalse)
+
+			So(upload(remote(""), ".fileserver-trash/planted.txt"), ShouldEqual, http.StatusForbidden)
+			So(exists(remote(".fileserver-trash/planted.txt")), ShouldBeFalse)
+		})
+
+		Convey("Dedup uploads should only send the files the server does not have", func() {
+			writeLocal("src/a.txt", "same")
+			writeLocal("src/b.txt", "other")
+
+			result, err := client.UploadDedup(serverUrl, local("src"), filepath.Join(dir, "dedup", "1"), "")
+			So(err, ShouldBeNil)
+			So(result.UploadedFiles, ShouldEqual, 2)
+
+			result, err = client.UploadDedup(serverUrl, local("src"), filepath.Join(dir, "dedup", "2"), "")
+			So(err, ShouldBeNil)
+			So(result.LinkedFiles, ShouldEqual, 2)
+			So(result.UploadedFiles, ShouldEqual, 0)
+			So(readFile(filepath.Join(dir, "dedup", "2", "a.txt")), ShouldEqual, "same")
+
+			//A rebuilt file has the same content with another mtime, it is still deduplicated without changing the other links
+			linkedInfo, err := os.Stat(filepath.Join(dir, "dedup", "1", "a.txt"))
+			So(err, ShouldBeNil)
+			otherModTime := linkedInfo.ModTime().Add(-time.Hour)
+			So(os.Chtimes(local("src/a.txt"), otherModTime, otherModTime), ShouldBeNil)
+			So(client.Upload(serverUrl, local("src/a.txt"), filepath.Join(dir, "dedup", "3.txt")), ShouldBeNil)
+
+			uploadedInfo, err := os.Stat(filepath.Join(dir, "dedup", "3.txt"))
+			So(err, ShouldBeNil)
+			So(os.SameFile(uploadedInfo, linkedInfo), ShouldBeTrue)
+			afterInfo, err := os.Stat(filepath.Join(dir, "dedup", "1", "a.txt"))
+			So(err, ShouldBeNil)
+			So(afterInfo.ModTime(), ShouldResemble, linkedInfo.ModTime())
+		})
+
+		Convey("Files moved out of a dedup root should no longer share their blob, moved in they should", func() {
+			writeLocal("src/a.txt", "same")
+			for _, name := range []string{"1", "2"} {
+				_, err := client.UploadDedup(serverUrl, local("src"), filepath.Join(dir, "dedup", name), "")
+				So(err, ShouldBeNil)
+			}
+
+			So(client.Move(serverUrl, filepath.Join(dir, "dedup", "1", "a.txt"), remote("moved.txt")), ShouldBeNil)
+			writeLocal("changed.txt", "changed")
+			So(client.Upload(serverUrl, local("changed.txt"), remote("moved.txt")), ShouldBeNil)
+
+			So(readFile(remote("moved.txt")), ShouldEqual, "changed")
+			So(readFile(filepath.Join(dir, "dedup", "2", "a.txt")), ShouldEqual, "same")
+
+			So(ioutil.WriteFile(remote("in.txt"), []byte("same"), 0644), ShouldBeNil)
+			So(client.Move(serverUrl, remote("in.txt"), filepath.Join(dir, "dedup", "in.txt")), ShouldBeNil)
+
+			movedInInfo, err := os.Stat(filepath.Join(dir, "dedup", "in.txt"))
+			So(err, ShouldBeNil)
+			linkedInfo, err := os.Stat(filepath.Join(dir, "dedup", "2", "a.txt"))
+			So(err, ShouldBeNil)
+			So(os.SameFile(movedInInfo, linkedInfo), ShouldBeTrue)
+		})
+
+		Convey("Paths should be listed, globbed and statted", func() {
+			So(os.MkdirAll(remote("builds/1.0"), 0755), ShouldBeNil)
+			So(ioutil.WriteFile(remote("builds/1.0/app.zip"), []byte("zip"), 0644), ShouldBeNil)
+			So(ioutil.WriteFile(remote("builds/1.0/app.txt"), []byte("txt"), 0644), ShouldBeNil)
+
+			entries, err := client.List(serverUrl, remote("builds/1.0"))
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 2)
+
+			matches, err := client.Glob(serverUrl, remote("builds/*/*.zip"))
+			So(err, ShouldBeNil)
+			So(matches, ShouldResemble, []string{remote("builds/1.0/app.zip")})
+
+			stats, err := client.Stats(serverUrl, remote("builds"))
+			So(err, ShouldBeNil)
+			So(stats.Exists, ShouldBeTrue)
+			So(stats.IsDir, ShouldBeTrue)
+
+			stats, err = client.Stats(serverUrl, remote("nothing"))
+			So(err, ShouldBeNil)
+			So(stats.Exists, ShouldBeFalse)
+		})
+
+		Convey("Files should be downloaded raw with ranges and conditional requests", func() {
+			So(ioutil.WriteFile(remote("notes.txt"), []byte("0123456789"), 0644), ShouldBeNil)
+			rawGet := func(header, value string) *http.Response {
+				req, err := http.NewRequest("GET", serverUrl+"/files/remote/notes.txt?raw=1", nil)
+				So(err, ShouldBeNil)
+				req.Header.Set("Authorization", "Bearer secret")
+				if header != "" {
+					req.Header.Set(header, value)
+				}
+				resp, err := http.DefaultClient.Do(req)
+				So(err, ShouldBeNil)
+				return resp
+			}
+
+			resp := rawGet("", "")
+			content, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(string(content), ShouldEqual, "0123456789")
+			So(resp.Header.Get("Content-Type"), ShouldStartWith, "text/plain")
+			So(resp.Header.Get("Accept-Ranges"), ShouldEqual, "bytes")
+			So(resp.Header.Get("Last-Modified"), ShouldNotBeEmpty)
+			etag := resp.Header.Get("ETag")
+			So(etag, ShouldNotBeEmpty)
+
+			resp = rawGet("Range", "bytes=2-4")
+			content, _ = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusPartialContent)
+			So(string(content), ShouldEqual, "234")
+			So(resp.Header.Get("Content-Range"), ShouldEqual, "bytes 2-4/10")
+
+			resp = rawGet("If-None-Match", etag)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusNotModified)
+
+			buffer := &bytes.Buffer{}
+			So(client.ReadRange(serverUrl, remote("notes.txt"), 7, 0, buffer), ShouldBeNil)
+			So(buffer.String(), ShouldEqual, "789")
+
+			err = client.ReadRange(serverUrl, remote("notes.txt"), 20, 5, buffer)
+			So(err, ShouldNotBeNil)
+
+			buffer.Reset()
+			So(client.ReadRange(serverUrl, remote("notes.txt"), 0, 0, buffer), ShouldBeNil)
+			So(buffer.String(), ShouldEqual, "0123456789")
+
+			So(ioutil.WriteFile(remote("empty.txt"), []byte{}, 0644), ShouldBeNil)
+			buffer.Reset()
+			So(client.ReadRange(serverUrl, remote("empty.txt"), 0, 0, buffer), ShouldBeNil)
+			So(buffer.Len(), ShouldEqual, 0)
+
+			//A server without raw downloads ignores raw=1 and the Range, it sends the tar of the file
+			olderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("tar of the file"))
+			}))
+			defer olderServer.Close()
+			buffer.Reset()
+			So(client.ReadRange(olderServer.URL, remote("notes.txt"), 0, 0, buffer), ShouldNotBeNil)
+			So(buffer.Len(), ShouldEqual, 0)
+
+			So(client.Download(serverUrl, local("notes.txt"), remote("notes.txt")), ShouldBeNil)
+			So(readFile(local("notes.txt")), ShouldEqual, "0123456789")
+		})
+
+		Convey("Paths should be found by name, size, time and content", func() {
+			So(os.MkdirAll(remote("logs/old"), 0755), ShouldBeNil)
+			So(ioutil.WriteFile(remote("logs/app.log"), []byte("started\nfailed: disk full\n"), 0644), ShouldBeNil)
+			So(ioutil.WriteFile(remote("logs/old/app.log"), []byte("started\n"), 0644), ShouldBeNil)
+			So(ioutil.WriteFile(remote("logs/big.bin"), make([]byte, 4096), 0644), ShouldBeNil)
+			lastWeek := time.Now().Add(-7 * 24 * time.Hour)
+			So(os.Chtimes(remote("logs/old/app.log"), lastWeek, lastWeek), ShouldBeNil)
+
+			result, err := client.Find(serverUrl, remote("logs"), &fileclient.FindOptions{Names: []string{"*.log"}})
+			So(err, ShouldBeNil)
+			So(len(result.Matches), ShouldEqual, 2)
+			So(result.Truncated, ShouldBeFalse)
+
+			result, err = client.Find(serverUrl, remote("logs"), &fileclient.FindOptions{Type: fileclient.FindTypeDir})
+			So(err, ShouldBeNil)
+			So(len(result.Matches), ShouldEqual, 2)
+
+			result, err = client.Find(serverUrl, remote("logs"), &fileclient.FindOptions{MinSize: 1024})
+			So(err, ShouldBeNil)
+			So(len(result.Matches), ShouldEqual, 1)
+			So(result.Matches[0].Path, ShouldEqual, remote("logs/big.bin"))
+
+			result, err = client.Find(serverUrl, remote("logs"), &fileclient.FindOptions{Names: []string{"*.log"}, OlderThan: time.Now().Add(-24 * time.Hour)})
+			So(err, ShouldBeNil)
+			So(len(result.Matches), ShouldEqual, 1)
+			So(result.Matches[0].Path, ShouldEqual, remote("logs/old/app.log"))
+
+			result, err = client.Find(serverUrl, remote("logs"), &fileclient.FindOptions{Contains: "disk full"})
+			So(err, ShouldBeNil)
+			So(len(result.Matches), ShouldEqual, 1)
+			So(result.Matches[0].Line, ShouldEqual, 2)
+
+			result, err = client.Find(serverUrl, remote("logs"), &fileclient.FindOptions{MaxResults: 1})
+			So(err, ShouldBeNil)
+			So(len(result.Matches), ShouldEqual, 1)
+			So(result.Truncated, ShouldBeTrue)
+			So(result.Reason, ShouldEqual, "maxresults")
+
+			_, err = client.Find(serverUrl, remote("logs"), &fileclient.FindOptions{Type: "link"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Paths should be moved, copied, created and changed", func() {
+			So(ioutil.WriteFile(remote("a.txt"), []byte("a"), 0644), ShouldBeNil)
+
+			So(client.Copy(serverUrl, remote("a.txt"), remote("b.txt")), ShouldBeNil)
+			So(readFile(remote("b.txt")), ShouldEqual, "a")
+
+			So(client.Move(serverUrl, remote("b.txt"), remote("c.txt")), ShouldBeNil)
+			So(exists(remote("b.txt")), ShouldBeFalse)
+			So(exists(remote("c.txt")), ShouldBeTrue)
+
+			So(client.Mkdir(serverUrl, remote("x/y"), true, 0755), ShouldBeNil)
+			So(exists(remote("x/y")), ShouldBeTrue)
+			So(client.Mkdir(serverUrl, remote("missing/y"), false, 0755), ShouldNotBeNil)
+			So(exists(remote("missing")), ShouldBeFalse)
+
+			So(client.Touch(serverUrl, remote("x/y/touched")), ShouldBeNil)
+			So(exists(remote("x/y/touched")), ShouldBeTrue)
+
+			lastWeek := time.Now().Add(-7 * 24 * time.Hour)
+			So(os.Chtimes(remote("a.txt"), lastWeek, lastWeek), ShouldBeNil)
+			So(client.Touch(serverUrl, remote("a.txt")), ShouldBeNil)
+			info, err := os.Stat(remote("a.txt"))
+			So(err, ShouldBeNil)
+			So(info.ModTime(), ShouldHappenAfter, time.Now().Add(-time.Minute))
+			So(readFile(remote("a.txt")), ShouldEqual, "a")
+
+			So(ioutil.WriteFile(remote("x/y/skipped.tmp"), []byte("tmp"), 0644), ShouldBeNil)
+			So(client.CopyDirFiltered(serverUrl, remote("x"), remote("x-copy"), "touched"), ShouldBeNil)
+			So(exists(remote("x-copy/y/touched")), ShouldBeTrue)
+			So(exists(remote("x-copy/y/skipped.tmp")), ShouldBeFalse)
+
+			err = client.Copy(serverUrl, remote("x"), remote("x/y/z"))
+			So(err, ShouldNotBeNil)
+			So(exists(remote("x/y/z")), ShouldBeFalse)
+			So(client.Copy(serverUrl, remote("a.txt"), remote("a.txt")), ShouldNotBeNil)
+			So(readFile(remote("a.txt")), ShouldEqual, "a")
+
+			if filepath.Separator == '/' {
+				So(client.Chmod(serverUrl, remote("a.txt"), 0600), ShouldBeNil)
+				info, err := os.Stat(remote("a.txt"))
+				So(err, ShouldBeNil)
+				So(info.Mode().Perm(), ShouldEqual, os.FileMode(0600))
+			}
+		})
+
+		Convey("Roots in memory should be uploaded, downloaded, copied and deleted like the local ones", func() {
+			memory := func(relPath string) string { return filepath.Join(dir, "memory", relPath) }
+			memoryServer, err := fileserver.New(leveledlog.Discard(), fileserver.WithRoot("memory="+memory("")+",storage=memory"))
+			So(err, ShouldBeNil)
+			defer memoryServer.Close()
+
+			memoryHttpServer := httptest.NewServer(memoryServer)
+			defer memoryHttpServer.Close()
+			memoryUrl := memoryHttpServer.URL
+			memoryClient := fileclient.New(leveledlog.Discard())
+
+			writeLocal("src/b.log", "b")
+			writeLocal("src/c.txt", "c")
+			So(memoryClient.UploadDirFiltered(memoryUrl, local("src"), memory("src"), ""), ShouldBeNil)
+			So(exists(memory("src")), ShouldBeFalse)
+
+			So(memoryClient.CopyDirFiltered(memoryUrl, memory("src"), memory("copy"), "*.log"), ShouldBeNil)
+			So(memoryClient.Copy(memoryUrl, memory("src/c.txt"), memory("c.txt")), ShouldBeNil)
+			So(memoryClient.Touch(memoryUrl, memory("c.txt")), ShouldBeNil)
+
+			So(memoryClient.DeleteDirFiltered(memoryUrl, memory("src"), "*.txt"), ShouldBeNil)
+			stats, err := memoryClient.Stats(memoryUrl, memory("src/c.txt"))
+			So(err, ShouldBeNil)
+			So(stats.Exists, ShouldBeFalse)
+
+			So(memoryClient.DownloadDirFiltered(memoryUrl, local("downloaded"), memory("src"), ""), ShouldBeNil)
+			So(readFile(local("downloaded/b.log")), ShouldEqual, "b")
+			So(exists(local("downloaded/c.txt")), ShouldBeFalse)
+
+			So(memoryClient.DownloadPaths(memoryUrl, []string{memory("copy"), memory("c.txt")}, local("many"), ""), ShouldBeNil)
+			So(readFile(local("many/copy/b.log")), ShouldEqual, "b")
+			So(exists(local("many/copy/c.txt")), ShouldBeFalse)
+			So(readFile(local("many/c.txt")), ShouldEqual, "c")
+		})
+
+		Convey("Deleted paths should go to the trash and be restorable", func() {
+			So(os.MkdirAll(remote("logs"), 0755), ShouldBeNil)
+			So(ioutil.WriteFile(remote("logs/a.log"), []byte("a"), 0644), ShouldBeNil)
+			So(ioutil.WriteFile(remote("logs/b.txt"), []byte("b"), 0644), ShouldBeNil)
+
+			result, err := client.DeleteWithOptions(serverUrl, remote("logs"), &fileclient.DeleteOptions{FileFilter: "*.log", DryRun: true})
+			So(err, ShouldBeNil)
+			So(result.DeletedPaths, ShouldResemble, []string{remote("logs/a.log")})
+			So(exists(remote("logs/a.log")), ShouldBeTrue)
+
+			So(client.Delete(serverUrl, remote("logs")), ShouldBeNil)
+			So(exists(remote("logs")), ShouldBeFalse)
+
+			items, err := client.ListTrash(serverUrl, "remote")
+			So(err, ShouldBeNil)
+			So(len(items), ShouldEqual, 1)
+			So(items[0].OriginalPath, ShouldEqual, remote("logs"))
+
+			So(client.RestoreTrash(serverUrl, "remote", items[0].Id, ""), ShouldBeNil)
+			So(readFile(remote("logs/b.txt")), ShouldEqual, "b")
+		})
+
+		Convey("Trash items restored into another root should be copied there and ingested into its blob store", func() {
+			writeLocal("src/a.txt", "same")
+			_, err := client.UploadDedup(serverUrl, local("src"), filepath.Join(dir, "dedup", "1"), "")
+			So(err, ShouldBeNil)
+
+			So(ioutil.WriteFile(remote("restored.txt"), []byte("same"), 0644), ShouldBeNil)
+			So(client.Delete(serverUrl, remote("restored.txt")), ShouldBeNil)
+			items, err := client.ListTrash(serverUrl, "remote")
+			So(err, ShouldBeNil)
+			So(len(items), ShouldEqual, 1)
+
+			So(client.RestoreTrash(serverUrl, "remote", items[0].Id, filepath.Join(dir, "dedup", "restored.txt")), ShouldBeNil)
+
+			restoredInfo, err := os.Stat(filepath.Join(dir, "dedup", "restored.txt"))
+			So(err, ShouldBeNil)
+			linkedInfo, err := os.Stat(filepath.Join(dir, "dedup", "1", "a.txt"))
+			So(err, ShouldBeNil)
+			So(os.SameFile(restoredInfo, linkedInfo), ShouldBeTrue)
+
+			items, err = client.ListTrash(serverUrl, "remote")
+			So(err, ShouldBeNil)
+			So(items, ShouldBeEmpty)
+		})
+
+		Convey("Only the requests that are safe to repeat should be retried", func() {
+			requests := map[string]int{}
+			unavailableServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests[r.Method+" "+r.URL.Query().Get("action")]++
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer unavailableServer.Close()
+
+			retryingClient := fileclient.New(leveledlog.Discard(), fileclient.WithRetryPolicy(&fileclient.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Multiplier: 1}))
+			So(retryingClient.Mkdir(unavailableServer.URL, remote("x"), false, 0755), ShouldNotBeNil)
+			So(requests["PUT mkdir"], ShouldEqual, 1)
+			So(retryingClient.Mkdir(unavailableServer.URL, remote("x"), true, 0755), ShouldNotBeNil)
+			So(requests["PUT mkdir"], ShouldEqual, 4)
+			So(retryingClient.Delete(unavailableServer.URL, remote("x")), ShouldNotBeNil)
+			So(requests["DELETE "], ShouldEqual, 1)
+		})
+
+		Convey("Dry runs should not be sent to servers ignoring them", func() {
+			deleteRequests := 0
+			olderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "DELETE" {
+					deleteRequests++
+				}
+				w.Header().Set("EXISTS", "1")
+				w.Header().Set("IS_DIR", "1")
+			}))
+			defer olderServer.Close()
+
+			_, err := client.DeleteWithOptions(olderServer.URL, remote("logs"), &fileclient.DeleteOptions{DryRun: true})
+			So(err, ShouldNotBeNil)
+			So(deleteRequests, ShouldEqual, 0)
+		})
+
+		Convey("The trash should be left out of downloads, copies and deletes of the root", func() {
+			So(ioutil.WriteFile(remote("trashed.log"), []byte("t"), 0644), ShouldBeNil)
+			So(client.Delete(serverUrl, remote("trashed.log")), ShouldBeNil)
+			So(ioutil.WriteFile(remote("kept.log"), []byte("k"), 0644), ShouldBeNil)
+
+			So(client.DownloadDirFiltered(serverUrl, local("root"), remote(""), ""), ShouldBeNil)
+			So(exists(local("root/kept.log")), ShouldBeTrue)
+			So(exists(local("root/.fileserver-trash")), ShouldBeFalse)
+
+			So(client.CopyDirFiltered(serverUrl, remote(""), filepath.Join(dir, "dedup", "root-copy"), "*.log"), ShouldBeNil)
+			So(exists(filepath.Join(dir, "dedup", "root-copy", "kept.log")), ShouldBeTrue)
+			So(exists(filepath.Join(dir, "dedup", "root-copy", ".fileserver-trash")), ShouldBeFalse)
+
+			result, err := client.DeleteWithOptions(serverUrl, remote(""), &fileclient.DeleteOptions{FileFilter: "*.log"})
+			So(err, ShouldBeNil)
+			So(result.DeletedPaths, ShouldResemble, []string{remote("kept.log")})
+
+			items, err := client.ListTrash(serverUrl, "remote")
+			So(err, ShouldBeNil)
+			So(len(items), ShouldEqual, 2)
+			for _, item := range items {
+				if item.OriginalPath == remote("trashed.log") {
+					So(client.RestoreTrash(serverUrl, "remote", item.Id, ""), ShouldBeNil)
+				}
+			}
+			So(readFile(remote("trashed.log")), ShouldEqual, "t")
+		})
+
+		Convey("Batches should run all their operations", func() {
+			results, err := client.Batch(serverUrl, fileclient.NewBatch(false).
+				Upload(remote("batch/a.txt"), []byte("a"), 0644).
+				Copy(remote("batch/a.txt"), remote("batch/b.txt")).
+				Stats(remote("batch/b.txt")).
+				Delete(remote("batch/missing")))
+			So(err, ShouldBeNil)
+			So(len(results), ShouldEqual, 4)
+			So(results[0].Success, ShouldBeTrue)
+			So(results[1].Success, ShouldBeTrue)
+			So(results[2].Stats.Exists, ShouldBeTrue)
+			So(results[3].Success, ShouldBeFalse)
+			So(results[3].Code, ShouldEqual, fileclient.ErrorCode("NOT_FOUND"))
+
+			results, err = client.Batch(serverUrl, fileclient.NewBatch(false).Copy(remote("batch/a.txt"), ""))
+			So(err, ShouldBeNil)
+			So(results[0].Success, ShouldBeFalse)
+			So(results[0].Code, ShouldEqual, fileclient.ErrorCode("BAD_REQUEST"))
+		})
+
+		Convey("Watch and tail should stream the changes", func() {
+			So(os.MkdirAll(remote("watched"), 0755), ShouldBeNil)
+			So(ioutil.WriteFile(remote("watched/app.log"), []byte("first\n"), 0644), ShouldBeNil)
+
+			stop := make(chan struct{})
+			defer close(stop)
+
+			events, err := client.Watch(serverUrl, remote("watched"), stop)
+			So(err, ShouldBeNil)
+
+			tailed := &lockedBuffer{}
+			go client.Tail(serverUrl, remote("watched/app.log"), 0, tailed, stop)
+			So(waitFor(func() bool { return tailed.String() == "first\n" }), ShouldBeTrue)
+
+			file, err := os.OpenFile(remote("watched/app.log"), os.O_WRONLY|os.O_APPEND, 0644)
+			So(err, ShouldBeNil)
+			_, err = file.WriteString("second\n")
+			So(err, ShouldBeNil)
+			So(file.Close(), ShouldBeNil)
+			So(waitFor(func() bool { return tailed.String() == "first\nsecond\n" }), ShouldBeTrue)
+
+			So(ioutil.WriteFile(remote("watched/new.txt"), []byte("new"), 0644), ShouldBeNil)
+			receivedNewFile := func() bool {
+				timeout := time.After(5 * time.Second)
+				for {
+					select {
+					case event := <-events:
+						if event != nil && event.Path == remote("watched/new.txt") {
+							return true
+						}
+					case <-timeout:
+						return false
+					}
+				}
+			}
+			So(receivedNewFile(), ShouldBeTrue)
+		})
+
+		Convey("Watching a root should not report the changes inside its trash", func() {
+			So(ioutil.WriteFile(remote("trashed.txt"), []byte("trashed"), 0644), ShouldBeNil)
+
+			stop := make(chan struct{})
+			defer close(stop)
+
+			events, err := client.Watch(serverUrl, remote(""), stop)
+			So(err, ShouldBeNil)
+
+			So(client.Delete(serverUrl, remote("trashed.txt")), ShouldBeNil)
+			So(ioutil.WriteFile(remote("marker.txt"), []byte("marker"), 0644), ShouldBeNil)
+
+			trashPaths := []string{}
+			receivedMarker := func() bool {
+				timeout := time.After(5 * time.Second)
+				for {
+					select {
+					case event := <-events:
+						if event == nil {
+							continue
+						}
+						if strings.HasPrefix(event.Path, remote(".fileserver-trash")) {
+							trashPaths = append(trashPaths, event.Path)
+						}
+						if event.Path == remote("marker.txt") {
+							return true
+						}
+					case <-timeout:
+						return false
+					}
+				}
+			}
+			So(receivedMarker(), ShouldBeTrue)
+			So(trashPaths, ShouldBeEmpty)
+		})
+
+		Convey("The disk usage of the roots should be reported", func() {
+			So(ioutil.WriteFile(remote("a.txt"), []byte("12345"), 0644), ShouldBeNil)
+
+			usages, err := client.DiskUsage(serverUrl)
+			So(err, ShouldBeNil)
+			So(len(usages), ShouldEqual, 2)
+			for _, usage := range usages {
+				if usage.Name == "remote" {
+					So(usage.UsedBytes, ShouldBeGreaterThanOrEqualTo, 5)
+				}
+			}
+		})
+
+		Convey("The requests and transferred bytes should be in the metrics", func() {
+			writeLocal("a.txt", "hello")
+			So(client.Upload(serverUrl, local("a.txt"), remote("a.txt")), ShouldBeNil)
+			_, err := client.Stats(serverUrl, remote("missing.txt"))
+			So(err, ShouldBeNil)
+
+			req, err := http.NewRequest("GET", serverUrl+"/metrics", nil)
+			So(err, ShouldBeNil)
+			req.Header.Set("Authorization", "Bearer secret")
+			resp, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.Header.Get("Content-Type"), ShouldStartWith, "text/plain")
+			metrics := string(body)
+			So(metrics, ShouldContainSubstring, "# TYPE fileserver_requests_total counter\n")
+			So(metrics, ShouldContainSubstring, `fileserver_requests_total{operation="upload",status="200"} 1`+"\n")
+			So(metrics, ShouldContainSubstring, `fileserver_requests_total{operation="stats",status="200"} 1`+"\n")
+			So(metrics, ShouldContainSubstring, `fileserver_request_duration_seconds_count{operation="upload",status="200"} 1`+"\n")
+			So(metrics, ShouldContainSubstring, `fileserver_root_quota_bytes{root="remote"} 0`+"\n")
+			So(metrics, ShouldNotContainSubstring, `fileserver_uploaded_bytes_total 0`+"\n")
+			So(metrics, ShouldContainSubstring, "fileserver_active_transfers 0\n")
+		})
+	})
+}
@@ -0,0 +1,123 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const abortedTransfersCleanupTimeout = 10 * time.Second
+
+var errTransferAborted = errors.New("Transfer aborted because the server is shutting down")
+
+// transferTracker keeps track of in-flight requests so that they can be drained (or aborted) during shutdown
+type transferTracker struct {
+	inFlight        sync.WaitGroup
+	activeTransfers int64
+	shuttingDown    chan struct{}
+	aborting        chan struct{}
+}
+
+type abortableBody struct {
+	io.ReadCloser
+	aborting <-chan struct{}
+}
+
+func (a *abortableBody) Read(p []byte) (int, error) {
+	select {
+	case <-a.aborting:
+		return 0, errTransferAborted
+	default:
+		return a.ReadCloser.Read(p)
+	}
+}
+
+func newTransferTracker() *transferTracker {
+	return &transferTracker{
+		shuttingDown: make(chan struct{}),
+		aborting:     make(chan struct{}),
+	}
+}
+
+func isTransferRequest(r *http.Request) bool {
+	//The metrics scrapes would otherwise count themselves as an active transfer
+	return (r.Method == "POST" || r.Method == "GET") && r.URL.Path != "/metrics"
+}
+
+// trackRequests wraps handler so in-flight requests are counted and upload bodies fail once transfers are aborted
+func (t *transferTracker) trackRequests(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.inFlight.Add(1)
+		defer t.inFlight.Done()
+
+		if isTransferRequest(r) {
+			atomic.AddInt64(&t.activeTransfers, 1)
+			defer atomic.AddInt64(&t.activeTransfers, -1)
+		}
+
+		r.Body = &abortableBody{r.Body, t.aborting}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (t *transferTracker) getActiveTransfers() int64 {
+	return atomic.LoadInt64(&t.activeTransfers)
+}
+
+// shutdownChannel is closed as soon as shutdown starts, long running streams (watch, tail) end on it
+func (t *transferTracker) shutdownChannel() <-chan struct{} {
+	if t == nil {
+		return nil
+	}
+	return t.shuttingDown
+}
+
+func waitWithTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+/*
+Shutdown stops the server from accepting new requests and waits up to drainTimeout for in-flight transfers. Transfers
+still running after that are aborted, which makes them remove their partially written files before the connections
+are closed. The Server is closed (see Close) once it returns.
+*/
+func (s *Server) Shutdown(server *http.Server, drainTimeout time.Duration) {
+	a := s.a
+	defer s.Close()
+
+	t := a.transfers
+	a.logger.Info("Shutting down (waiting up to %s for %d active transfers)", drainTimeout, t.getActiveTransfers())
+	s.startShutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	if err == nil {
+		a.logger.Info("All in-flight requests finished")
+		return
+	}
+
+	a.logger.Error("Aborting %d transfers still active after %s: %s", t.getActiveTransfers(), drainTimeout, err.Error())
+	close(t.aborting)
+	server.Close()
+
+	if !waitWithTimeout(&t.inFlight, abortedTransfersCleanupTimeout) {
+		a.logger.Error("Aborted transfers did not finish cleaning up within %s", abortedTransfersCleanupTimeout)
+	}
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/francoishill/golang-web-dry/osutils"
+)
+
+// LocalStorage keeps the files on the local disk, the paths are used as they are
+type LocalStorage struct{}
+
+func NewLocalStorage() *LocalStorage {
+	return &LocalStorage{}
+}
+
+func (l *LocalStorage) Stat(path string) (*FileInfo, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfoFromOs(info), nil
+}
+
+func (l *LocalStorage) List(dir string) ([]*FileInfo, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*FileInfo{}
+	for _, info := range infos {
+		entries = append(entries, fileInfoFromOs(info))
+	}
+	return entries, nil
+}
+
+func (l *LocalStorage) Open(path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
+
+// Create replaces an existing file once the writer is closed, it is never truncated in place (it could be hard linked)
+func (l *LocalStorage) Create(path string, mode os.FileMode) (io.WriteCloser, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return osutils.CreateReplacingFile(path, mode)
+}
+
+func (l *LocalStorage) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (l *LocalStorage) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+func (l *LocalStorage) Rename(oldPath, newPath string) error {
+	return os.Rename(oldPath, newPath)
+}
+
+func (l *LocalStorage) Chtimes(path string, atime, mtime time.Time) error {
+	return os.Chtimes(path, atime, mtime)
+}
+
+func (l *LocalStorage) Mkdir(path string, mode os.FileMode, parents bool) error {
+	if parents {
+		return os.MkdirAll(path, mode)
+	}
+	return os.Mkdir(path, mode)
+}
@@ -0,0 +1,298 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type memoryNode struct {
+	isDir   bool
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+}
+
+/*
+MemoryStorage keeps all files in memory, it is meant for tests. The basePath (the path of the fileserver root)
+always exists as a directory, paths outside of it do not exist.
+*/
+type MemoryStorage struct {
+	basePath string
+
+	mutex sync.RWMutex
+	nodes map[string]*memoryNode
+}
+
+func NewMemoryStorage(basePath string) *MemoryStorage {
+	basePath = filepath.Clean(basePath)
+	return &MemoryStorage{
+		basePath: basePath,
+		nodes: map[string]*memoryNode{
+			basePath: {isDir: true, mode: os.ModeDir | 0755, modTime: time.Now()},
+		},
+	}
+}
+
+func (m *MemoryStorage) isInside(path string) bool {
+	return path == m.basePath || strings.HasPrefix(path, strings.TrimRight(m.basePath, `/\`)+string(filepath.Separator))
+}
+
+// getNode must be called while holding the mutex
+func (m *MemoryStorage) getNode(op, path string) (*memoryNode, error) {
+	if node, ok := m.nodes[path]; ok {
+		return node, nil
+	}
+	return nil, pathError(op, path, os.ErrNotExist)
+}
+
+func (m *MemoryStorage) infoOf(path string, node *memoryNode) *FileInfo {
+	return &FileInfo{
+		Name:    filepath.Base(path),
+		Size:    int64(len(node.data)),
+		Mode:    node.mode,
+		ModTime: node.modTime,
+		IsDir:   node.isDir,
+	}
+}
+
+// childrenOf must be called while holding the mutex, it returns the direct (or with recursive all) children of dir
+func (m *MemoryStorage) childrenOf(dir string, recursive bool) []string {
+	prefix := strings.TrimRight(dir, `/\`) + string(filepath.Separator)
+	children := []string{}
+	for path := range m.nodes {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		if recursive || !strings.ContainsRune(path[len(prefix):], filepath.Separator) {
+			children = append(children, path)
+		}
+	}
+	return children
+}
+
+// mkdirAll must be called while holding the mutex
+func (m *MemoryStorage) mkdirAll(op, path string, mode os.FileMode) error {
+	if !m.isInside(path) {
+		return pathError(op, path, os.ErrNotExist)
+	}
+
+	if node, ok := m.nodes[path]; ok {
+		if !node.isDir {
+			return pathError(op, path, syscall.ENOTDIR)
+		}
+		return nil
+	}
+
+	if err := m.mkdirAll(op, filepath.Dir(path), mode); err != nil {
+		return err
+	}
+	m.nodes[path] = &memoryNode{isDir: true, mode: os.ModeDir | mode.Perm(), modTime: time.Now()}
+	return nil
+}
+
+func (m *MemoryStorage) Stat(path string) (*FileInfo, error) {
+	path = filepath.Clean(path)
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	node, err := m.getNode("stat", path)
+	if err != nil {
+		return nil, err
+	}
+	return m.infoOf(path, node), nil
+}
+
+func (m *MemoryStorage) List(dir string) ([]*FileInfo, error) {
+	dir = filepath.Clean(dir)
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	node, err := m.getNode("open", dir)
+	if err != nil {
+		return nil, err
+	}
+	if !node.isDir {
+		return nil, pathError("readdirent", dir, syscall.ENOTDIR)
+	}
+
+	entries := []*FileInfo{}
+	for _, child := range m.childrenOf(dir, false) {
+		entries = append(entries, m.infoOf(child, m.nodes[child]))
+	}
+	sortByName(entries)
+	return entries, nil
+}
+
+func (m *MemoryStorage) Open(path string) (io.ReadCloser, error) {
+	path = filepath.Clean(path)
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	node, err := m.getNode("open", path)
+	if err != nil {
+		return nil, err
+	}
+	if node.isDir {
+		return nil, pathError("read", path, syscall.EISDIR)
+	}
+	//The data is never changed in place (Create replaces the node), so it is safe to share
+	return ioutil.NopCloser(bytes.NewReader(node.data)), nil
+}
+
+type memoryFileWriter struct {
+	bytes.Buffer
+	storage *MemoryStorage
+	path    string
+	mode    os.FileMode
+}
+
+func (w *memoryFileWriter) Close() error {
+	w.storage.mutex.Lock()
+	defer w.storage.mutex.Unlock()
+
+	if err := w.storage.mkdirAll("open", filepath.Dir(w.path), 0755); err != nil {
+		return err
+	}
+	if node, ok := w.storage.nodes[w.path]; ok && node.isDir {
+		return pathError("open", w.path, syscall.EISDIR)
+	}
+
+	w.storage.nodes[w.path] = &memoryNode{data: w.Bytes(), mode: w.mode.Perm(), modTime: time.Now()}
+	return nil
+}
+
+func (m *MemoryStorage) Create(path string, mode os.FileMode) (io.WriteCloser, error) {
+	path = filepath.Clean(path)
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if !m.isInside(path) || path == m.basePath {
+		return nil, pathError("open", path, os.ErrNotExist)
+	}
+	if node, ok := m.nodes[path]; ok && node.isDir {
+		return nil, pathError("open", path, syscall.EISDIR)
+	}
+	return &memoryFileWriter{storage: m, path: path, mode: mode}, nil
+}
+
+func (m *MemoryStorage) Remove(path string) error {
+	path = filepath.Clean(path)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	node, err := m.getNode("remove", path)
+	if err != nil {
+		return err
+	}
+	if node.isDir && len(m.childrenOf(path, false)) > 0 {
+		return pathError("remove", path, syscall.ENOTEMPTY)
+	}
+
+	delete(m.nodes, path)
+	return nil
+}
+
+func (m *MemoryStorage) RemoveAll(path string) error {
+	path = filepath.Clean(path)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, child := range m.childrenOf(path, true) {
+		delete(m.nodes, child)
+	}
+	delete(m.nodes, path)
+
+	if path == m.basePath {
+		m.nodes[path] = &memoryNode{isDir: true, mode: os.ModeDir | 0755, modTime: time.Now()}
+	}
+	return nil
+}
+
+func (m *MemoryStorage) Chtimes(path string, atime, mtime time.Time) error {
+	path = filepath.Clean(path)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	node, err := m.getNode("chtimes", path)
+	if err != nil {
+		return err
+	}
+	node.modTime = mtime
+	return nil
+}
+
+func (m *MemoryStorage) Rename(oldPath, newPath string) error {
+	oldPath = filepath.Clean(oldPath)
+	newPath = filepath.Clean(newPath)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	node, err := m.getNode("rename", oldPath)
+	if err != nil {
+		return err
+	}
+	if !m.isInside(newPath) {
+		return pathError("rename", newPath, os.ErrNotExist)
+	}
+	if parent, ok := m.nodes[filepath.Dir(newPath)]; !ok || !parent.isDir {
+		return pathError("rename", newPath, os.ErrNotExist)
+	}
+	if oldPath == newPath {
+		return nil
+	}
+	if node.isDir && strings.HasPrefix(newPath, oldPath+string(filepath.Separator)) {
+		return pathError("rename", newPath, syscall.EINVAL)
+	}
+	if existing, ok := m.nodes[newPath]; ok {
+		if existing.isDir != node.isDir {
+			return pathError("rename", newPath, syscall.EEXIST)
+		}
+		if existing.isDir && len(m.childrenOf(newPath, false)) > 0 {
+			return pathError("rename", newPath, syscall.ENOTEMPTY)
+		}
+	}
+
+	for _, child := range m.childrenOf(oldPath, true) {
+		m.nodes[newPath+child[len(oldPath):]] = m.nodes[child]
+		delete(m.nodes, child)
+	}
+	m.nodes[newPath] = node
+	delete(m.nodes, oldPath)
+	return nil
+}
+
+func (m *MemoryStorage) Mkdir(path string, mode os.FileMode, parents bool) error {
+	path = filepath.Clean(path)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if parents {
+		return m.mkdirAll("mkdir", path, mode)
+	}
+
+	if _, ok := m.nodes[path]; ok {
+		return pathError("mkdir", path, os.ErrExist)
+	}
+	if parent, ok := m.nodes[filepath.Dir(path)]; !ok || !parent.isDir || !m.isInside(path) {
+		return pathError("mkdir", path, os.ErrNotExist)
+	}
+
+	m.nodes[path] = &memoryNode{isDir: true, mode: os.ModeDir | mode.Perm(), modTime: time.Now()}
+	return nil
+}
@@ -0,0 +1,566 @@
+package storage
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type S3Config struct {
+	//Endpoint is the scheme and host of the S3 compatible service, for example https://s3.eu-west-1.amazonaws.com or http://localhost:9000
+	Endpoint string
+	Bucket   string
+	//Prefix is prepended to all keys, so a root can be a "directory" of the bucket
+	Prefix          string
+	Region          string
+	AccessKeyId     string
+	SecretAccessKey string
+	//HttpClient defaults to http.DefaultClient
+	HttpClient *http.Client
+}
+
+/*
+S3Storage keeps the files as objects in an S3 compatible bucket (path-style requests signed with SigV4). A file at
+basePath/a/b.txt is the object Prefix + "a/b.txt".
+
+Directories are implicit in S3: a directory exists while there are objects under it. Mkdir creates an empty
+"a/" marker object so that empty directories can exist too. File modes are kept in the x-amz-meta-mode metadata,
+but List does not return metadata so it reports 0644 for all files.
+*/
+type S3Storage struct {
+	basePath   string
+	config     *S3Config
+	signer     *s3Signer
+	httpClient *http.Client
+}
+
+func NewS3Storage(basePath string, config *S3Config) *S3Storage {
+	httpClient := config.HttpClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	region := config.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &S3Storage{
+		basePath: filepath.Clean(basePath),
+		config:   config,
+		signer: &s3Signer{
+			accessKeyId:     config.AccessKeyId,
+			secretAccessKey: config.SecretAccessKey,
+			region:          region,
+			service:         "s3",
+		},
+		httpClient: httpClient,
+	}
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// keyOf returns the object key of path, the key of basePath itself is the Prefix without its trailing slash
+func (s *S3Storage) keyOf(op, path string) (string, error) {
+	path = filepath.Clean(path)
+	if path == s.basePath {
+		return strings.TrimSuffix(s.config.Prefix, "/"), nil
+	}
+
+	rel, err := filepath.Rel(s.basePath, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", pathError(op, path, os.ErrNotExist)
+	}
+	return s.config.Prefix + filepath.ToSlash(rel), nil
+}
+
+func (s *S3Storage) isBaseKey(key string) bool {
+	return key == strings.TrimSuffix(s.config.Prefix, "/")
+}
+
+// dirPrefix returns the prefix of all the keys inside the directory with key
+func dirPrefix(key string) string {
+	if key == "" {
+		return ""
+	}
+	return key + "/"
+}
+
+func (s *S3Storage) do(method, key string, query url.Values, header http.Header, body io.Reader, contentLength int64) (*http.Response, error) {
+	endpoint, err := url.Parse(s.config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	objectPath := "/" + s.config.Bucket
+	if key != "" {
+		objectPath += "/" + key
+	}
+
+	req, err := http.NewRequest(method, endpoint.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.URL.Path = objectPath
+	req.URL.RawPath = s3UriEncode(objectPath, false)
+	req.URL.RawQuery = ""
+	if len(query) > 0 {
+		req.URL.RawQuery = s3CanonicalQueryString(&http.Request{URL: &url.URL{RawQuery: query.Encode()}})
+	}
+	req.ContentLength = contentLength
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	s.signer.sign(req, "", time.Now())
+	return s.httpClient.Do(req)
+}
+
+func s3ResponseError(op, key string, resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("S3 %s of '%s' failed with status %d: %s", op, key, resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+func (s *S3Storage) list(op, prefix string, delimiter bool, maxKeys int) (*s3ListResult, error) {
+	all := &s3ListResult{}
+	continuationToken := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if delimiter {
+			query.Set("delimiter", "/")
+		}
+		if maxKeys > 0 {
+			query.Set("max-keys", strconv.Itoa(maxKeys))
+		}
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+
+		resp, err := s.do("GET", "", query, nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			err = s3ResponseError(op, prefix, resp)
+			resp.Body.Close()
+			return nil, err
+		}
+
+		page := &s3ListResult{}
+		err = xml.NewDecoder(resp.Body).Decode(page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		all.Contents = append(all.Contents, page.Contents...)
+		all.CommonPrefixes = append(all.CommonPrefixes, page.CommonPrefixes...)
+		if !page.IsTruncated || maxKeys > 0 || page.NextContinuationToken == "" {
+			return all, nil
+		}
+		continuationToken = page.NextContinuationToken
+	}
+}
+
+// headObject returns nil (and no error) if the object does not exist
+func (s *S3Storage) headObject(path, key string) (*FileInfo, error) {
+	if key == "" {
+		return nil, nil
+	}
+
+	resp, err := s.do("HEAD", key, nil, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, s3ResponseError("stat", key, resp)
+	}
+
+	info := &FileInfo{
+		Name: filepath.Base(path),
+		Size: resp.ContentLength,
+		Mode: 0644,
+	}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modTime
+	}
+	if mode, err := strconv.ParseUint(resp.Header.Get("x-amz-meta-mode"), 8, 32); err == nil {
+		info.Mode = os.FileMode(mode).Perm()
+	}
+	return info, nil
+}
+
+func (s *S3Storage) dirInfo(path string) *FileInfo {
+	return &FileInfo{
+		Name:  filepath.Base(path),
+		Mode:  os.ModeDir | 0755,
+		IsDir: true,
+	}
+}
+
+func (s *S3Storage) Stat(path string) (*FileInfo, error) {
+	key, err := s.keyOf("stat", path)
+	if err != nil {
+		return nil, err
+	}
+	if s.isBaseKey(key) {
+		return s.dirInfo(path), nil
+	}
+
+	info, err := s.headObject(path, key)
+	if err != nil || info != nil {
+		return info, err
+	}
+
+	result, err := s.list("stat", dirPrefix(key), false, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Contents) == 0 {
+		return nil, pathError("stat", path, os.ErrNotExist)
+	}
+	return s.dirInfo(path), nil
+}
+
+func (s *S3Storage) List(dir string) ([]*FileInfo, error) {
+	key, err := s.keyOf("open", dir)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := dirPrefix(key)
+	result, err := s.list("list", prefix, true, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*FileInfo{}
+	foundMarker := false
+	for _, object := range result.Contents {
+		if object.Key == prefix {
+			foundMarker = true
+			continue
+		}
+		entries = append(entries, &FileInfo{
+			Name:    object.Key[len(prefix):],
+			Size:    object.Size,
+			Mode:    0644,
+			ModTime: object.LastModified,
+		})
+	}
+	for _, commonPrefix := range result.CommonPrefixes {
+		entries = append(entries, s.dirInfo(strings.TrimSuffix(commonPrefix.Prefix[len(prefix):], "/")))
+	}
+
+	if len(entries) == 0 && !foundMarker && !s.isBaseKey(key) {
+		//Either an empty listing of something that is not a directory, or it does not exist
+		if info, err := s.headObject(dir, key); err != nil {
+			return nil, err
+		} else if info != nil {
+			return nil, pathError("readdirent", dir, syscall.ENOTDIR)
+		}
+		return nil, pathError("open", dir, os.ErrNotExist)
+	}
+
+	sortByName(entries)
+	return entries, nil
+}
+
+func (s *S3Storage) Open(path string) (io.ReadCloser, error) {
+	key, err := s.keyOf("open", path)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.do("GET", key, nil, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound || s.isBaseKey(key) {
+		resp.Body.Close()
+		return nil, pathError("open", path, os.ErrNotExist)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, s3ResponseError("open", key, resp)
+	}
+	return resp.Body, nil
+}
+
+// s3FileWriter buffers the content in a temporary file, because S3 needs to know the size before the upload starts
+type s3FileWriter struct {
+	*os.File
+	storage *S3Storage
+	key     string
+	mode    os.FileMode
+}
+
+func (w *s3FileWriter) Close() error {
+	defer os.Remove(w.File.Name())
+	defer w.File.Close()
+
+	size, err := w.File.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err = w.File.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("x-amz-meta-mode", fmt.Sprintf("%o", w.mode.Perm()))
+	return w.storage.putObject(w.key, header, w.File, size)
+}
+
+func (s *S3Storage) putObject(key string, header http.Header, body io.Reader, size int64) error {
+	if size == 0 {
+		//A nil body, otherwise the http client could send a chunked request
+		body = nil
+	}
+
+	resp, err := s.do("PUT", key, nil, header, body, size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s3ResponseError("put", key, resp)
+	}
+	return nil
+}
+
+func (s *S3Storage) deleteObject(key string) error {
+	resp, err := s.do("DELETE", key, nil, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3ResponseError("delete", key, resp)
+	}
+	return nil
+}
+
+// keepParentDir creates the marker of the parent of path, so the parent keeps existing after its last object was removed
+func (s *S3Storage) keepParentDir(path string) error {
+	parentKey, err := s.keyOf("remove", filepath.Dir(path))
+	if err != nil || s.isBaseKey(parentKey) {
+		return nil
+	}
+	return s.putObject(dirPrefix(parentKey), nil, nil, 0)
+}
+
+func (s *S3Storage) copyObject(srcKey, destKey string) error {
+	header := http.Header{}
+	header.Set("x-amz-copy-source", s3UriEncode("/"+s.config.Bucket+"/"+srcKey, false))
+	return s.putObject(destKey, header, nil, 0)
+}
+
+func (s *S3Storage) Create(path string, mode os.FileMode) (io.WriteCloser, error) {
+	key, err := s.keyOf("open", path)
+	if err != nil {
+		return nil, err
+	}
+	if s.isBaseKey(key) {
+		return nil, pathError("open", path, syscall.EISDIR)
+	}
+
+	tempFile, err := ioutil.TempFile("", "s3storage-upload-")
+	if err != nil {
+		return nil, err
+	}
+	return &s3FileWriter{File: tempFile, storage: s, key: key, mode: mode}, nil
+}
+
+func (s *S3Storage) Remove(path string) error {
+	key, err := s.keyOf("remove", path)
+	if err != nil {
+		return err
+	}
+
+	if info, err := s.headObject(path, key); err != nil {
+		return err
+	} else if info != nil {
+		if err = s.deleteObject(key); err != nil {
+			return err
+		}
+		return s.keepParentDir(path)
+	}
+
+	prefix := dirPrefix(key)
+	result, err := s.list("remove", prefix, true, 2)
+	if err != nil {
+		return err
+	}
+
+	foundMarker := false
+	for _, object := range result.Contents {
+		if object.Key == prefix {
+			foundMarker = true
+		} else {
+			return pathError("remove", path, syscall.ENOTEMPTY)
+		}
+	}
+	if len(result.CommonPrefixes) > 0 {
+		return pathError("remove", path, syscall.ENOTEMPTY)
+	}
+	if !foundMarker {
+		return pathError("remove", path, os.ErrNotExist)
+	}
+	if err = s.deleteObject(prefix); err != nil {
+		return err
+	}
+	return s.keepParentDir(path)
+}
+
+func (s *S3Storage) RemoveAll(path string) error {
+	key, err := s.keyOf("remove", path)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.list("remove", dirPrefix(key), false, 0)
+	if err != nil {
+		return err
+	}
+	for _, object := range result.Contents {
+		if err = s.deleteObject(object.Key); err != nil {
+			return err
+		}
+	}
+
+	if s.isBaseKey(key) {
+		return nil
+	}
+
+	info, err := s.headObject(path, key)
+	if err != nil {
+		return err
+	}
+	if info != nil {
+		if err = s.deleteObject(key); err != nil {
+			return err
+		}
+	} else if len(result.Contents) == 0 {
+		return nil
+	}
+	return s.keepParentDir(path)
+}
+
+// Chtimes is not supported, the modification time of an object is the time it was written
+func (s *S3Storage) Chtimes(path string, atime, mtime time.Time) error {
+	return pathError("chtimes", path, errors.ErrUnsupported)
+}
+
+func (s *S3Storage) Rename(oldPath, newPath string) error {
+	oldKey, err := s.keyOf("rename", oldPath)
+	if err != nil {
+		return err
+	}
+	newKey, err := s.keyOf("rename", newPath)
+	if err != nil {
+		return err
+	}
+	if oldKey == newKey {
+		return nil
+	}
+
+	info, err := s.Stat(oldPath)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir {
+		if err = s.copyObject(oldKey, newKey); err != nil {
+			return err
+		}
+		if err = s.deleteObject(oldKey); err != nil {
+			return err
+		}
+		return s.keepParentDir(oldPath)
+	}
+
+	if s.isBaseKey(oldKey) || strings.HasPrefix(newKey, dirPrefix(oldKey)) {
+		return pathError("rename", newPath, syscall.EINVAL)
+	}
+
+	oldPrefix := dirPrefix(oldKey)
+	result, err := s.list("rename", oldPrefix, false, 0)
+	if err != nil {
+		return err
+	}
+	for _, object := range result.Contents {
+		if err = s.copyObject(object.Key, dirPrefix(newKey)+object.Key[len(oldPrefix):]); err != nil {
+			return err
+		}
+		if err = s.deleteObject(object.Key); err != nil {
+			return err
+		}
+	}
+	return s.keepParentDir(oldPath)
+}
+
+func (s *S3Storage) Mkdir(path string, mode os.FileMode, parents bool) error {
+	key, err := s.keyOf("mkdir", path)
+	if err != nil {
+		return err
+	}
+
+	info, err := s.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if info != nil {
+		if parents && info.IsDir {
+			return nil
+		} else if parents {
+			return pathError("mkdir", path, syscall.ENOTDIR)
+		}
+		return pathError("mkdir", path, os.ErrExist)
+	}
+
+	if !parents {
+		parentInfo, err := s.Stat(filepath.Dir(path))
+		if err != nil {
+			return pathError("mkdir", path, os.ErrNotExist)
+		}
+		if !parentInfo.IsDir {
+			return pathError("mkdir", path, syscall.ENOTDIR)
+		}
+	}
+
+	return s.putObject(dirPrefix(key), nil, nil, 0)
+}
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type fakeS3Object struct {
+	data    []byte
+	mode    string
+	modTime time.Time
+}
+
+// fakeS3 is a minimal in-memory stand-in for the S3 API used by S3Storage, it verifies the signature of every request
+type fakeS3 struct {
+	bucket string
+	signer *s3Signer
+
+	mutex   sync.Mutex
+	objects map[string]*fakeS3Object
+}
+
+func newFakeS3Server(bucket string, signer *s3Signer) (*httptest.Server, *fakeS3) {
+	fake := &fakeS3{bucket: bucket, signer: signer, objects: map[string]*fakeS3Object{}}
+	return httptest.NewServer(fake), fake
+}
+
+func (f *fakeS3) verifySignature(r *http.Request) bool {
+	authorization := r.Header.Get("Authorization")
+	signedHeadersStart := strings.Index(authorization, "SignedHeaders=")
+	if signedHeadersStart == -1 {
+		return false
+	}
+	signedHeaders := strings.Split(strings.SplitN(authorization[signedHeadersStart+len("SignedHeaders="):], ",", 2)[0], ";")
+
+	amzDate, err := time.Parse(s3AmzDateFormat, r.Header.Get("x-amz-date"))
+	if err != nil {
+		return false
+	}
+
+	resigned, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	for _, name := range signedHeaders {
+		if name != "host" && name != "x-amz-date" && name != "x-amz-content-sha256" {
+			resigned.Header[http.CanonicalHeaderKey(name)] = r.Header[http.CanonicalHeaderKey(name)]
+		}
+	}
+	f.signer.sign(resigned, r.Header.Get("x-amz-content-sha256"), amzDate)
+	return resigned.Header.Get("Authorization") == authorization
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !f.verifySignature(r) {
+		http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+		return
+	}
+
+	bucketPrefix := "/" + f.bucket
+	if r.URL.Path != bucketPrefix && !strings.HasPrefix(r.URL.Path, bucketPrefix+"/") {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, bucketPrefix), "/")
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if key == "" && r.Method == "GET" {
+		f.list(w, r.URL.Query())
+		return
+	}
+
+	switch r.Method {
+	case "HEAD", "GET":
+		object, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+		w.Header().Set("Last-Modified", object.modTime.UTC().Format(http.TimeFormat))
+		w.Header().Set("x-amz-meta-mode", object.mode)
+		if r.Method == "GET" {
+			w.Write(object.data)
+		}
+		break
+	case "PUT":
+		object := &fakeS3Object{mode: r.Header.Get("x-amz-meta-mode"), modTime: time.Now()}
+		if copySource := r.Header.Get("x-amz-copy-source"); copySource != "" {
+			sourceKey, _ := url.PathUnescape(strings.TrimPrefix(copySource, bucketPrefix+"/"))
+			source, ok := f.objects[sourceKey]
+			if !ok {
+				http.Error(w, "NoSuchKey", http.StatusNotFound)
+				return
+			}
+			object.data, object.mode = source.data, source.mode
+		} else {
+			object.data, _ = ioutil.ReadAll(r.Body)
+		}
+		f.objects[key] = object
+		break
+	case "DELETE":
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+		break
+	default:
+		http.Error(w, "MethodNotAllowed", http.StatusMethodNotAllowed)
+		break
+	}
+}
+
+type fakeS3ListContents struct {
+	Key          string
+	Size         int64
+	LastModified string
+}
+
+type fakeS3ListResult struct {
+	XMLName        xml.Name `xml:"ListBucketResult"`
+	Contents       []fakeS3ListContents
+	CommonPrefixes []struct{ Prefix string }
+	IsTruncated    bool
+	//Pages of 2 keys, to test the pagination of S3Storage
+	NextContinuationToken string `xml:",omitempty"`
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, query url.Values) {
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+
+	keys := []string{}
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	//Each entry is either a key or a common prefix, in order
+	entries := []string{}
+	seenPrefixes := map[string]bool{}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if delimiter != "" {
+			if index := strings.Index(key[len(prefix):], delimiter); index != -1 {
+				commonPrefix := key[:len(prefix)+index+len(delimiter)]
+				if !seenPrefixes[commonPrefix] {
+					seenPrefixes[commonPrefix] = true
+					entries = append(entries, commonPrefix)
+				}
+				continue
+			}
+		}
+		entries = append(entries, key)
+	}
+
+	start, _ := strconv.Atoi(query.Get("continuation-token"))
+	pageSize := 2
+	if maxKeys, err := strconv.Atoi(query.Get("max-keys")); err == nil && maxKeys < pageSize {
+		pageSize = maxKeys
+	}
+
+	result := &fakeS3ListResult{}
+	end := start + pageSize
+	if end < len(entries) {
+		result.IsTruncated = true
+		result.NextContinuationToken = fmt.Sprintf("%d", end)
+	} else {
+		end = len(entries)
+	}
+
+	for _, entry := range entries[start:end] {
+		if seenPrefixes[entry] {
+			result.CommonPrefixes = append(result.CommonPrefixes, struct{ Prefix string }{entry})
+		} else {
+			object := f.objects[entry]
+			result.Contents = append(result.Contents, fakeS3ListContents{
+				Key:          entry,
+				Size:         int64(len(object.data)),
+				LastModified: object.modTime.UTC().Format("2006-01-02T15:04:05.000Z"),
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
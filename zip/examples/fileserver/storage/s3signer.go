@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	s3SigningAlgorithm = "AWS4-HMAC-SHA256"
+	s3UnsignedPayload  = "UNSIGNED-PAYLOAD"
+	s3AmzDateFormat    = "20060102T150405Z"
+)
+
+// s3Signer signs requests with AWS Signature Version 4, see https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html
+type s3Signer struct {
+	accessKeyId     string
+	secretAccessKey string
+	region          string
+	service         string
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// s3UriEncode encodes everything except the unreserved characters (and '/' if encodeSlash is false) as required by SigV4
+func s3UriEncode(value string, encodeSlash bool) string {
+	encoded := &strings.Builder{}
+	for _, b := range []byte(value) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') ||
+			b == '-' || b == '_' || b == '.' || b == '~' || (b == '/' && !encodeSlash) {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(encoded, "%%%02X", b)
+		}
+	}
+	return encoded.String()
+}
+
+func s3CanonicalQueryString(req *http.Request) string {
+	query := req.URL.Query()
+	pairs := []string{}
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, s3UriEncode(key, true)+"="+s3UriEncode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// s3CanonicalHeaders returns the canonical headers and the signed header names, all headers of req (and the host) are signed
+func s3CanonicalHeaders(req *http.Request) (canonicalHeaders, signedHeaders string) {
+	headers := map[string]string{
+		"host": req.URL.Host,
+	}
+	for name, values := range req.Header {
+		trimmed := []string{}
+		for _, value := range values {
+			trimmed = append(trimmed, strings.Join(strings.Fields(value), " "))
+		}
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	builder := &strings.Builder{}
+	for _, name := range names {
+		builder.WriteString(name + ":" + headers[name] + "\n")
+	}
+	return builder.String(), strings.Join(names, ";")
+}
+
+func (s *s3Signer) canonicalRequest(req *http.Request, payloadHash string) (canonicalRequest, signedHeaders string) {
+	canonicalHeaders, signedHeaders := s3CanonicalHeaders(req)
+
+	uri := s3UriEncode(req.URL.Path, false)
+	if uri == "" {
+		uri = "/"
+	}
+
+	return strings.Join([]string{
+		req.Method,
+		uri,
+		s3CanonicalQueryString(req),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n"), signedHeaders
+}
+
+func (s *s3Signer) signingKey(date string) []byte {
+	key := hmacSha256([]byte("AWS4"+s.secretAccessKey), date)
+	key = hmacSha256(key, s.region)
+	key = hmacSha256(key, s.service)
+	return hmacSha256(key, "aws4_request")
+}
+
+/*
+sign adds the x-amz-date, x-amz-content-sha256 and Authorization headers to req. It must be called after all
+other headers are set, because all of them are signed. The payload is not signed when payloadHash is empty.
+*/
+func (s *s3Signer) sign(req *http.Request, payloadHash string, now time.Time) {
+	if payloadHash == "" {
+		payloadHash = s3UnsignedPayload
+	}
+
+	amzDate := now.UTC().Format(s3AmzDateFormat)
+	date := amzDate[:8]
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	canonicalRequest, signedHeaders := s.canonicalRequest(req, payloadHash)
+
+	scope := strings.Join([]string{date, s.region, s.service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		s3SigningAlgorithm,
+		amzDate,
+		scope,
+		sha256Hex(canonicalRequest),
+	}, "\n")
+
+	signature := hex.EncodeToString(hmacSha256(s.signingKey(date), stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3SigningAlgorithm, s.accessKeyId, scope, signedHeaders, signature))
+}
@@ -0,0 +1,138 @@
+/*
+Package storage is where the fileserver keeps the files of a root. The paths given to a Storage are the (cleaned)
+paths of the fileserver requests, it is up to each Storage to map them onto its own layout.
+
+Errors are returned as *os.PathError wrapping os.ErrNotExist, os.ErrExist and syscall.ENOTEMPTY (and so on) like
+the os package does, so callers can keep using os.IsNotExist and friends regardless of the Storage.
+*/
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// FileInfo describes a file or directory of a Storage
+type FileInfo struct {
+	Name    string
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+	IsDir   bool
+}
+
+type Storage interface {
+	//Stat returns the info of path, an error satisfying os.IsNotExist if it does not exist
+	Stat(path string) (*FileInfo, error)
+	//List returns the entries of directory dir sorted by name
+	List(dir string) ([]*FileInfo, error)
+	Open(path string) (io.ReadCloser, error)
+	//Create creates (or replaces) the file at path and its missing parent directories, the content is only guaranteed to be stored once the writer is closed
+	Create(path string, mode os.FileMode) (io.WriteCloser, error)
+	//Remove removes a file or an empty directory
+	Remove(path string) error
+	//RemoveAll removes path and everything inside it, it is not an error if path does not exist
+	RemoveAll(path string) error
+	Rename(oldPath, newPath string) error
+	Mkdir(path string, mode os.FileMode, parents bool) error
+	//Chtimes changes the access and modification time, an error satisfying errors.Is(err, errors.ErrUnsupported) if the storage cannot
+	Chtimes(path string, atime, mtime time.Time) error
+}
+
+func pathError(op, path string, err error) error {
+	return &os.PathError{Op: op, Path: path, Err: err}
+}
+
+func fileInfoFromOs(info os.FileInfo) *FileInfo {
+	return &FileInfo{
+		Name:    info.Name(),
+		Size:    info.Size(),
+		Mode:    info.Mode(),
+		ModTime: info.ModTime(),
+		IsDir:   info.IsDir(),
+	}
+}
+
+func sortByName(infos []*FileInfo) {
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+}
+
+// WalkFunc is called by Walk for every file and directory, like filepath.WalkFunc
+type WalkFunc func(path string, info *FileInfo, err error) error
+
+// Walk walks the tree of root in lexical order like filepath.Walk, returning filepath.SkipDir from walkFn skips a directory
+func Walk(s Storage, root string, walkFn WalkFunc) error {
+	info, err := s.Stat(root)
+	if err != nil {
+		err = walkFn(root, nil, err)
+	} else {
+		err = walk(s, root, info, walkFn)
+	}
+
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func walk(s Storage, path string, info *FileInfo, walkFn WalkFunc) error {
+	if !info.IsDir {
+		return walkFn(path, info, nil)
+	}
+
+	entries, err := s.List(path)
+	err1 := walkFn(path, info, err)
+	if err != nil || err1 != nil {
+		//The same as filepath.Walk, a failed List is reported to walkFn a second time
+		return err1
+	}
+
+	for _, entry := range entries {
+		err = walk(s, filepath.Join(path, entry.Name), entry, walkFn)
+		if err != nil {
+			if !entry.IsDir || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteFile writes data to path in s, replacing the file if it exists
+func WriteFile(s Storage, path string, data []byte, mode os.FileMode) error {
+	writer, err := s.Create(path, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err = writer.Write(data); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// CopyFile copies the content of srcPath to destPath, both in s
+func CopyFile(s Storage, srcPath, destPath string, mode os.FileMode) error {
+	reader, err := s.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	writer, err := s.Create(destPath, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(writer, reader); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func readString(s Storage, path string) string {
+	reader, err := s.Open(path)
+	So(err, ShouldBeNil)
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	So(err, ShouldBeNil)
+	return string(data)
+}
+
+func listNames(s Storage, dir string) []string {
+	entries, err := s.List(dir)
+	So(err, ShouldBeNil)
+
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	return names
+}
+
+// testStorage is the behaviour every Storage must have, basePath must be an existing empty directory of s
+func testStorage(s Storage, basePath string) {
+	join := func(relPath string) string {
+		return filepath.Join(basePath, filepath.FromSlash(relPath))
+	}
+
+	So(WriteFile(s, join("a.txt"), []byte("hello"), 0644), ShouldBeNil)
+	So(WriteFile(s, join("sub/deeper/b.txt"), []byte("world!"), 0600), ShouldBeNil)
+
+	Convey("Stat should describe files and directories", func() {
+		info, err := s.Stat(join("a.txt"))
+		So(err, ShouldBeNil)
+		So(info.Name, ShouldEqual, "a.txt")
+		So(info.Size, ShouldEqual, 5)
+		So(info.IsDir, ShouldBeFalse)
+
+		info, err = s.Stat(join("sub/deeper/b.txt"))
+		So(err, ShouldBeNil)
+		So(info.Mode.Perm(), ShouldEqual, os.FileMode(0600))
+
+		info, err = s.Stat(join("sub"))
+		So(err, ShouldBeNil)
+		So(info.IsDir, ShouldBeTrue)
+
+		info, err = s.Stat(basePath)
+		So(err, ShouldBeNil)
+		So(info.IsDir, ShouldBeTrue)
+
+		_, err = s.Stat(join("missing"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("List should return the direct children sorted by name", func() {
+		So(listNames(s, basePath), ShouldResemble, []string{"a.txt", "sub"})
+		So(listNames(s, join("sub")), ShouldResemble, []string{"deeper"})
+
+		_, err := s.List(join("missing"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+		_, err = s.List(join("a.txt"))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Open should return the content", func() {
+		So(readString(s, join("sub/deeper/b.txt")), ShouldEqual, "world!")
+
+		_, err := s.Open(join("missing.txt"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("Create should replace existing files", func() {
+		So(WriteFile(s, join("a.txt"), []byte("replaced"), 0644), ShouldBeNil)
+		So(readString(s, join("a.txt")), ShouldEqual, "replaced")
+	})
+
+	Convey("Mkdir should create (empty) directories", func() {
+		So(s.Mkdir(join("empty"), 0755, false), ShouldBeNil)
+		So(listNames(s, join("empty")), ShouldResemble, []string{})
+
+		So(os.IsExist(s.Mkdir(join("empty"), 0755, false)), ShouldBeTrue)
+		So(s.Mkdir(join("empty"), 0755, true), ShouldBeNil)
+		So(os.IsNotExist(s.Mkdir(join("x/y"), 0755, false)), ShouldBeTrue)
+		So(s.Mkdir(join("x/y"), 0755, true), ShouldBeNil)
+
+		info, err := s.Stat(join("x"))
+		So(err, ShouldBeNil)
+		So(info.IsDir, ShouldBeTrue)
+	})
+
+	Convey("Remove should only remove files and empty directories", func() {
+		So(s.Remove(join("sub")), ShouldNotBeNil)
+		So(s.Remove(join("sub/deeper/b.txt")), ShouldBeNil)
+		So(s.Remove(join("sub/deeper")), ShouldBeNil)
+		So(listNames(s, join("sub")), ShouldResemble, []string{})
+
+		So(os.IsNotExist(s.Remove(join("missing"))), ShouldBeTrue)
+	})
+
+	Convey("RemoveAll should remove a whole tree", func() {
+		So(s.RemoveAll(join("sub")), ShouldBeNil)
+		So(listNames(s, basePath), ShouldResemble, []string{"a.txt"})
+		So(s.RemoveAll(join("missing")), ShouldBeNil)
+	})
+
+	Convey("Rename should move files and directories", func() {
+		So(s.Rename(join("a.txt"), join("sub/c.txt")), ShouldBeNil)
+		So(readString(s, join("sub/c.txt")), ShouldEqual, "hello")
+
+		So(s.Rename(join("sub"), join("moved")), ShouldBeNil)
+		So(listNames(s, basePath), ShouldResemble, []string{"moved"})
+		So(readString(s, join("moved/deeper/b.txt")), ShouldEqual, "world!")
+	})
+
+	Convey("Walk should visit everything in lexical order", func() {
+		visited := []string{}
+		err := Walk(s, basePath, func(path string, info *FileInfo, err error) error {
+			So(err, ShouldBeNil)
+			relPath, _ := filepath.Rel(basePath, path)
+			visited = append(visited, filepath.ToSlash(relPath))
+			return nil
+		})
+		So(err, ShouldBeNil)
+		So(visited, ShouldResemble, []string{".", "a.txt", "sub", "sub/deeper", "sub/deeper/b.txt"})
+	})
+
+	Convey("Chtimes should change the modification time if the storage supports it", func() {
+		mtime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+		err := s.Chtimes(join("a.txt"), mtime, mtime)
+		if errors.Is(err, errors.ErrUnsupported) {
+			return
+		}
+		So(err, ShouldBeNil)
+		info, err := s.Stat(join("a.txt"))
+		So(err, ShouldBeNil)
+		So(info.ModTime.Equal(mtime), ShouldBeTrue)
+
+		err = s.Chtimes(join("missing"), mtime, mtime)
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("CopyFile should copy the content", func() {
+		So(CopyFile(s, join("a.txt"), join("copy/a.txt"), 0644), ShouldBeNil)
+		So(readString(s, join("copy/a.txt")), ShouldEqual, "hello")
+		So(readString(s, join("a.txt")), ShouldEqual, "hello")
+	})
+}
+
+func TestLocalStorage(t *testing.T) {
+	Convey("Testing LocalStorage", t, func() {
+		dir, err := ioutil.TempDir("", "storage-local")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		testStorage(NewLocalStorage(), dir)
+	})
+}
+
+func TestMemoryStorage(t *testing.T) {
+	Convey("Testing MemoryStorage", t, func() {
+		basePath := filepath.Join(os.TempDir(), "storage-memory")
+		s := NewMemoryStorage(basePath)
+		testStorage(s, basePath)
+
+		Convey("Paths outside of the basePath should not exist", func() {
+			_, err := s.Stat(filepath.Dir(basePath))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
+
+func TestS3Storage(t *testing.T) {
+	Convey("Testing S3Storage", t, func() {
+		config := &S3Config{
+			Bucket:          "files",
+			Prefix:          "roots/test/",
+			Region:          "eu-west-1",
+			AccessKeyId:     "AKIDTEST",
+			SecretAccessKey: "secret",
+		}
+		basePath := filepath.Join(os.TempDir(), "storage-s3")
+		s := NewS3Storage(basePath, config)
+
+		server, fake := newFakeS3Server(config.Bucket, s.signer)
+		defer server.Close()
+		config.Endpoint = server.URL
+
+		testStorage(s, basePath)
+
+		Convey("Objects should be stored under the prefix", func() {
+			fake.mutex.Lock()
+			defer fake.mutex.Unlock()
+			So(string(fake.objects["roots/test/a.txt"].data), ShouldEqual, "hello")
+		})
+
+		Convey("Requests with a wrong secret should be refused", func() {
+			wrongConfig := *config
+			wrongConfig.SecretAccessKey = "wrong"
+			_, err := NewS3Storage(basePath, &wrongConfig).Stat(filepath.Join(basePath, "a.txt"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "403")
+		})
+	})
+}
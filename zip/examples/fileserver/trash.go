@@ -0,0 +1,342 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+	"github.com/francoishill/golang-web-dry/requests/requestproxyutils"
+	Tokens "github.com/francoishill/golang-web-dry/tokens/randomtokens"
+	"github.com/francoishill/golang-web-dry/zip/ziputils"
+)
+
+const (
+	trashDirName      = ".fileserver-trash"
+	trashMetaFileName = "meta.json"
+	trashDataName     = "data"
+)
+
+/*
+trashItem is one deleted path (or the files a filtered DELETE matched) inside the trash of a root. Each item
+is a directory in the trash containing the meta.json file and the deleted data:
+
+	<root>/.fileserver-trash/<Id>/meta.json
+	<root>/.fileserver-trash/<Id>/data
+*/
+type trashItem struct {
+	Id           string
+	OriginalPath string
+	IsDir        bool
+	FileFilter   string `json:",omitempty"`
+	DeletedTime  time.Time
+	ClientIP     string
+	UserAgent    string
+	SizeBytes    int64
+}
+
+func (rt *root) trashDir() string {
+	return filepath.Join(rt.Path, trashDirName)
+}
+
+func (rt *root) trashItemDir(id string) string {
+	return filepath.Join(rt.trashDir(), id)
+}
+
+// findTrashRoot returns the root of path if it has a trash, otherwise nil (the delete must be permanent)
+func (a *appContext) findTrashRoot(path string) *root {
+	rt := a.findRoot(path)
+	if rt == nil || !rt.TrashEnabled {
+		return nil
+	}
+	return rt
+}
+
+func newTrashId() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + Tokens.GenerateRandomAlphaNumericString(6)
+}
+
+/*
+trashPath moves path (or only the files matching dirFileFilterPattern inside it) into the trash of rt. Directories
+left empty by a filtered delete are removed permanently when removeEmptyDirs is true, they are not worth restoring.
+*/
+func (a *appContext) trashPath(r *http.Request, rt *root, path, dirFileFilterPattern string, removeEmptyDirs bool) *deleteResult {
+	cleanPath := filepath.Clean(path)
+	if cleanPath == rt.Path && dirFileFilterPattern == "" {
+		panic(newApiError(http.StatusConflict, errCodeConflict, "Cannot move root '%s' into its own trash", rt.Name))
+	}
+
+	info, err := os.Stat(cleanPath)
+	CheckError(err)
+
+	a.trashMutex.Lock()
+	defer a.trashMutex.Unlock()
+
+	item := &trashItem{
+		Id:           newTrashId(),
+		OriginalPath: cleanPath,
+		IsDir:        info.IsDir(),
+		DeletedTime:  time.Now(),
+		ClientIP:     requestproxyutils.IP(r),
+		UserAgent:    r.UserAgent(),
+	}
+
+	itemDir := rt.trashItemDir(item.Id)
+	dataPath := filepath.Join(itemDir, trashDataName)
+	err = os.MkdirAll(itemDir, 0755)
+	CheckError(err)
+
+	result := &deleteResult{DeletedPaths: []string{cleanPath}}
+	walkContext := ziputils.NewDirWalkContext(dirFileFilterPattern)
+	if dirFileFilterPattern != "" {
+		//Only the filtered walks can reach the trash itself, when deleting from the root
+		walkContext.IncludeFile, walkContext.IncludeDir = a.isUnreservedPath, a.isUnreservedPath
+	}
+	if !info.IsDir() || dirFileFilterPattern == "" {
+		if info.IsDir() {
+			result = newDeleteResult(false, walkContext.DeleteDirectoryWithOptions(cleanPath, &ziputils.DeleteOptions{DryRun: true}))
+		}
+
+		a.logger.Info("Moving %s to trash item %s of root '%s'", cleanPath, item.Id, rt.Name)
+		err = os.Rename(cleanPath, dataPath)
+		CheckError(err)
+	} else {
+		a.logger.Info("Moving files matching '%s' in %s to trash item %s of root '%s'", dirFileFilterPattern, cleanPath, item.Id, rt.Name)
+		item.FileFilter = dirFileFilterPattern
+		result = newDeleteResult(false, walkContext.MoveFiles(cleanPath, dataPath))
+
+		if removeEmptyDirs {
+			//Only the directories left empty, the files that could not be moved must not be deleted permanently instead
+			emptyDirsContext := ziputils.NewDirWalkContext(dirFileFilterPattern)
+			emptyDirsContext.IncludeFile = func(path string, info os.FileInfo) bool { return false }
+			emptyDirsContext.IncludeDir = a.isUnreservedPath
+			result.add(emptyDirsContext.DeleteDirectoryWithOptions(cleanPath, &ziputils.DeleteOptions{RemoveEmptyDirs: true}))
+		}
+	}
+
+	item.SizeBytes = getDirectorySize(dataPath)
+	writeTrashItemMeta(itemDir, item)
+
+	a.purgeTrash(rt)
+	return result
+}
+
+func writeTrashItemMeta(itemDir string, item *trashItem) {
+	metaJson, err := json.MarshalIndent(item, "", "  ")
+	CheckError(err)
+	err = ioutil.WriteFile(filepath.Join(itemDir, trashMetaFileName), metaJson, 0644)
+	CheckError(err)
+}
+
+// listTrashItems returns the items of the trash of rt, oldest first. Items without (valid) metadata are skipped.
+func (a *appContext) listTrashItems(rt *root) []*trashItem {
+	items := []*trashItem{}
+
+	entries, err := ioutil.ReadDir(rt.trashDir())
+	if os.IsNotExist(err) {
+		return items
+	}
+	CheckError(err)
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		metaJson, err := ioutil.ReadFile(filepath.Join(rt.trashItemDir(entry.Name()), trashMetaFileName))
+		if err != nil {
+			a.logger.Error("Skipping trash item %s of root '%s', cannot read metadata: %s", entry.Name(), rt.Name, err.Error())
+			continue
+		}
+
+		item := &trashItem{}
+		if err = json.Unmarshal(metaJson, item); err != nil || item.Id != entry.Name() {
+			a.logger.Error("Skipping trash item %s of root '%s', invalid metadata", entry.Name(), rt.Name)
+			continue
+		}
+		items = append(items, item)
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].DeletedTime.Before(items[j].DeletedTime)
+	})
+	return items
+}
+
+func (a *appContext) removeTrashItem(rt *root, item *trashItem, reason string) {
+	a.logger.Info("Purging trash item %s (%s) of root '%s' because %s", item.Id, item.OriginalPath, rt.Name, reason)
+	err := os.RemoveAll(rt.trashItemDir(item.Id))
+	CheckError(err)
+	a.usageChanged(rt.Path)
+}
+
+// purgeTrash permanently removes the items older than the retention and then the oldest items until the trash fits its max size
+func (a *appContext) purgeTrash(rt *root) {
+	items := a.listTrashItems(rt)
+
+	totalBytes := int64(0)
+	remaining := []*trashItem{}
+	for _, item := range items {
+		if rt.TrashRetention > 0 && time.Since(item.DeletedTime) > rt.TrashRetention {
+			a.removeTrashItem(rt, item, "it is older than "+rt.TrashRetention.String())
+			continue
+		}
+		totalBytes += item.SizeBytes
+		remaining = append(remaining, item)
+	}
+
+	if rt.TrashMaxBytes <= 0 {
+		return
+	}
+
+	for _, item := range remaining {
+		if totalBytes <= rt.TrashMaxBytes {
+			break
+		}
+		a.removeTrashItem(rt, item, "the trash is larger than "+strconv.FormatInt(rt.TrashMaxBytes, 10)+" bytes")
+		totalBytes -= item.SizeBytes
+	}
+}
+
+func (a *appContext) purgeTrashOfRoot(rt *root) {
+	defer func() {
+		if r := recover(); r != nil {
+			a.logger.Error("Unable to purge trash of root '%s': %+v", rt.Name, r)
+		}
+	}()
+
+	a.trashMutex.Lock()
+	defer a.trashMutex.Unlock()
+	a.purgeTrash(rt)
+}
+
+func (a *appContext) getTrashRootFromRequest(r *http.Request) *root {
+	rt := a.findRootByName(a.getRequiredQueryValue(r, "root"))
+	if !rt.TrashEnabled {
+		panic(badRequest("Root '%s' does not have a trash", rt.Name))
+	}
+	return rt
+}
+
+func (a *appContext) handleListTrash(w http.ResponseWriter, r *http.Request) {
+	rt := a.getTrashRootFromRequest(r)
+
+	items := func() []*trashItem {
+		a.trashMutex.Lock()
+		defer a.trashMutex.Unlock()
+		return a.listTrashItems(rt)
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(items)
+	CheckError(err)
+}
+
+func (a *appContext) findTrashItem(rt *root, id string) *trashItem {
+	a.trashMutex.Lock()
+	defer a.trashMutex.Unlock()
+	return a.findTrashItemLocked(rt, id)
+}
+
+// findTrashItemLocked must be called while holding the trash mutex
+func (a *appContext) findTrashItemLocked(rt *root, id string) *trashItem {
+	for _, item := range a.listTrashItems(rt) {
+		if item.Id == id {
+			return item
+		}
+	}
+	panic(newApiError(http.StatusNotFound, errCodeNotFound, "Trash item '%s' does not exist in root '%s'", id, rt.Name))
+}
+
+/*
+restoreFromTrashData moves dataPath out of the trash of rt to destPath. Into another root it is copied and then removed
+from the trash instead, the other root could be on another disk.
+*/
+func (a *appContext) restoreFromTrashData(rt *root, dataPath, destPath string) {
+	if _, err := os.Lstat(destPath); err == nil {
+		panic(newApiError(http.StatusConflict, errCodeAlreadyExists, "Cannot restore to '%s', it already exists", destPath))
+	}
+
+	fileSystem := a.fileSystem()
+	err := fileSystem.MkdirAll(filepath.Dir(destPath), 0755)
+	CheckError(err)
+
+	if a.findRoot(destPath) == rt {
+		err = fileSystem.Rename(dataPath, destPath)
+		CheckError(err)
+		return
+	}
+
+	//The copies are new files, so they are never linked to the blobs of rt
+	walkContext := ziputils.NewDirWalkContext("")
+	walkContext.FileSystem = fileSystem
+	walkContext.CopyDirectory(dataPath, destPath)
+	if destRoot := a.findDedupRoot(destPath); destRoot != nil {
+		a.ingestDirectory(destRoot, destPath)
+	}
+	err = fileSystem.RemoveAll(dataPath)
+	CheckError(err)
+}
+
+/*
+handleRestoreTrash moves a trash item back to its original path (or to 'newpath' if given, which must be in a root on the
+same storage as the trash). A filtered delete is restored file by file into the (possibly already recreated) original
+directory. Restoring into another root needs the size of the item within the quota of that root.
+*/
+func (a *appContext) handleRestoreTrash(w http.ResponseWriter, r *http.Request) {
+	rt := a.getTrashRootFromRequest(r)
+	id := a.getRequiredQueryValue(r, "id")
+
+	item := a.findTrashItem(rt, id)
+
+	destPath := item.OriginalPath
+	if r.FormValue("newpath") != "" {
+		destPath = a.getNewPathFromRequest(r)
+		if a.storageFor(destPath) != rt.Storage {
+			panic(badRequest("Cannot restore into '%s', it is not on the same storage as the trash of root '%s'", destPath, rt.Name))
+		}
+	}
+	a.checkNotReserved(destPath)
+
+	//The path lock must be taken before the trash mutex, the same order as a DELETE takes them
+	defer a.locks.acquire(r.Context().Done(), writeLock(destPath))()
+
+	a.trashMutex.Lock()
+	defer a.trashMutex.Unlock()
+
+	//The item could have been purged or restored while waiting for the lock
+	item = a.findTrashItemLocked(rt, id)
+
+	itemDir := rt.trashItemDir(item.Id)
+	dataPath := filepath.Join(itemDir, trashDataName)
+
+	if a.findRoot(destPath) != rt {
+		//The trash is already counted in the usage of rt, so only another root needs the space
+		a.newUploadPreflight(destPath).requireFile(destPath, item.SizeBytes)
+	}
+
+	a.logger.Info("Restoring trash item %s of root '%s' to %s", item.Id, rt.Name, destPath)
+	if item.FileFilter == "" {
+		a.restoreFromTrashData(rt, dataPath, destPath)
+	} else {
+		err := filepath.Walk(dataPath, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				a.restoreFromTrashData(rt, path, filepath.Join(destPath, path[len(dataPath):]))
+			}
+			return nil
+		})
+		CheckError(err)
+	}
+
+	err := os.RemoveAll(itemDir)
+	CheckError(err)
+	a.usageChanged(rt.Path, destPath)
+}
@@ -0,0 +1,254 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+)
+
+const (
+	watchEventCreated  = "created"
+	watchEventModified = "modified"
+	watchEventDeleted  = "deleted"
+	watchEventRenamed  = "renamed"
+
+	watchHeartbeatInterval = 15 * time.Second
+	tailPollInterval       = 250 * time.Millisecond
+)
+
+type watchEvent struct {
+	Type  string
+	Path  string
+	IsDir bool
+	Time  time.Time
+}
+
+func getFlusher(w http.ResponseWriter) http.Flusher {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		panic("Streaming is not supported by the ResponseWriter")
+	}
+	return flusher
+}
+
+// addRecursiveWatch watches dir and all its sub directories, except the trash and blob store of a root
+func (a *appContext) addRecursiveWatch(watcher *fsnotify.Watcher, dir string) {
+	e := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if a.isReservedPath(path) {
+				return filepath.SkipDir
+			}
+			return watcher.Add(path)
+		}
+		return nil
+	})
+	CheckError(e)
+}
+
+func toWatchEvent(event fsnotify.Event) *watchEvent {
+	we := &watchEvent{
+		Path: event.Name,
+		Time: time.Now(),
+	}
+
+	switch {
+	case event.Op&fsnotify.Create == fsnotify.Create:
+		we.Type = watchEventCreated
+	case event.Op&fsnotify.Remove == fsnotify.Remove:
+		we.Type = watchEventDeleted
+	case event.Op&fsnotify.Rename == fsnotify.Rename:
+		we.Type = watchEventRenamed
+	case event.Op&(fsnotify.Write|fsnotify.Chmod) != 0:
+		we.Type = watchEventModified
+	default:
+		return nil
+	}
+
+	if info, err := os.Stat(event.Name); err == nil {
+		we.IsDir = info.IsDir()
+	}
+	return we
+}
+
+/*
+handleWatch streams change notifications under path as Server-Sent Events until the client disconnects.
+
+Each event is written as:
+
+	event: change
+	data: {"Type":"created","Path":"/builds/latest/app.zip","IsDir":false,"Time":"..."}
+
+A renamed item is reported as 'renamed' for its old path followed by 'created' for its new path.
+*/
+func (a *appContext) handleWatch(w http.ResponseWriter, r *http.Request, path string) {
+	flusher := getFlusher(w)
+
+	watcher, err := fsnotify.NewWatcher()
+	CheckError(err)
+	defer watcher.Close()
+
+	isDir := a.isDir(path)
+	if isDir {
+		a.addRecursiveWatch(watcher, path)
+	} else {
+		err = watcher.Add(path)
+		CheckError(err)
+	}
+
+	a.logger.Info("Watching %s for changes", path)
+	defer a.logger.Info("Stopped watching %s", path)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(watchHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-a.transfers.shutdownChannel():
+			return
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			a.logger.Error("Watch error for %s: %s", path, err.Error())
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+
+			we := toWatchEvent(event)
+			if we == nil || a.isReservedPath(we.Path) {
+				//The trash and blob store are only changed by the server itself
+				continue
+			}
+
+			pending := []*watchEvent{we}
+			if isDir && we.Type == watchEventCreated && we.IsDir {
+				//Newly created sub directories must also be watched. Items created inside them before the watch was added are
+				//reported as created too. Errors are ignored since the directory could already be gone again.
+				filepath.Walk(we.Path, func(subPath string, info os.FileInfo, err error) error {
+					if err != nil {
+						return nil
+					}
+					if info.IsDir() {
+						if a.isReservedPath(subPath) {
+							return filepath.SkipDir
+						}
+						watcher.Add(subPath)
+					}
+					if subPath != we.Path {
+						pending = append(pending, &watchEvent{Type: watchEventCreated, Path: subPath, IsDir: info.IsDir(), Time: we.Time})
+					}
+					return nil
+				})
+			}
+
+			for _, pendingEvent := range pending {
+				data, err := json.Marshal(pendingEvent)
+				CheckError(err)
+				if _, err := fmt.Fprintf(w, "event: change\ndata: %s\n\n", data); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+/*
+handleTail streams the content of a (growing) file, similar to 'tail -f'. It starts at the 'offset'
+query value (a negative offset is relative to the end of the file) and keeps on sending new content
+until the client disconnects. If the file is truncated it starts again from the beginning.
+*/
+func (a *appContext) handleTail(w http.ResponseWriter, r *http.Request, path string) {
+	flusher := getFlusher(w)
+
+	file, err := os.Open(path)
+	CheckError(err)
+	defer file.Close()
+
+	info, err := file.Stat()
+	CheckError(err)
+	if info.IsDir() {
+		panic(badRequest("Cannot tail directory '%s'", path))
+	}
+
+	var offset int64 = 0
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		offset, err = strconv.ParseInt(offsetStr, 10, 64)
+		if err != nil {
+			panic(badRequest("Query parameter 'offset' must be an integer, got '%s'", offsetStr))
+		}
+	}
+	if offset < 0 {
+		offset = info.Size() + offset
+	}
+	if offset < 0 || offset > info.Size() {
+		offset = 0
+	}
+
+	_, err = file.Seek(offset, os.SEEK_SET)
+	CheckError(err)
+
+	a.logger.Info("Tailing %s from offset %d", path, offset)
+	defer a.logger.Info("Stopped tailing %s", path)
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(tailPollInterval)
+	defer ticker.Stop()
+
+	position := offset
+	for {
+		written, err := io.Copy(w, file)
+		if err != nil {
+			a.logger.Error("Unable to continue tailing %s: %s", path, err.Error())
+			return
+		}
+		position += written
+		if written > 0 {
+			flusher.Flush()
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-a.transfers.shutdownChannel():
+			return
+		case <-ticker.C:
+		}
+
+		if info, err := os.Stat(path); err == nil && info.Size() < position {
+			a.logger.Info("File %s was truncated, tailing from the beginning", path)
+			_, err = file.Seek(0, os.SEEK_SET)
+			CheckError(err)
+			position = 0
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package ziputils
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/francoishill/golang-web-dry/osutils"
+)
+
+/*
+FileSystem is where the dirWalkContext functions and SaveTarReaderToPathWithOptions read and write the files, the
+local disk unless another one is set. They call it with the same paths they were called with.
+*/
+type FileSystem interface {
+	Stat(path string) (os.FileInfo, error)
+	//Walk is like filepath.Walk, the entries of a directory are visited in lexical order
+	Walk(root string, walkFn filepath.WalkFunc) error
+	ReadDirNames(dir string) ([]string, error)
+	Open(path string) (io.ReadCloser, error)
+	//Create writes a new file, an existing file may only be replaced once the writer is closed
+	Create(path string, mode os.FileMode) (io.WriteCloser, error)
+	MkdirAll(path string, mode os.FileMode) error
+	Remove(path string) error
+	RemoveAll(path string) error
+	Rename(oldPath, newPath string) error
+	Chtimes(path string, atime, mtime time.Time) error
+}
+
+type localFileSystem struct{}
+
+func (localFileSystem) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (localFileSystem) Walk(root string, walkFn filepath.WalkFunc) error {
+	return filepath.Walk(root, walkFn)
+}
+
+func (localFileSystem) ReadDirNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return f.Readdirnames(-1)
+}
+
+func (localFileSystem) Open(path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
+
+// Create never truncates an existing file in place, other hard links to it keep their content
+func (localFileSystem) Create(path string, mode os.FileMode) (io.WriteCloser, error) {
+	return osutils.CreateReplacingFile(path, mode)
+}
+
+func (localFileSystem) MkdirAll(path string, mode os.FileMode) error {
+	return os.MkdirAll(path, mode)
+}
+
+func (localFileSystem) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (localFileSystem) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+func (localFileSystem) Rename(oldPath, newPath string) error {
+	return os.Rename(oldPath, newPath)
+}
+
+func (localFileSystem) Chtimes(path string, atime, mtime time.Time) error {
+	return os.Chtimes(path, atime, mtime)
+}
+
+// fileSystemOrLocal returns fileSystem, or the local disk if it is nil
+func fileSystemOrLocal(fileSystem FileSystem) FileSystem {
+	if fileSystem == nil {
+		return localFileSystem{}
+	}
+	return fileSystem
+}
+
+// abortWrite discards a partially written file, a writer with an Abort method (like an osutils.ReplacingFile) keeps the file it would have replaced
+func abortWrite(fileSystem FileSystem, writer io.WriteCloser, path string) {
+	if aborter, ok := writer.(interface{ Abort() }); ok {
+		aborter.Abort()
+		return
+	}
+	writer.Close()
+	fileSystem.Remove(path)
+}
+
+// copyFile copies the content, mode and modification time of srcPath to destPath
+func copyFile(fileSystem FileSystem, srcPath, destPath string, info os.FileInfo) error {
+	reader, err := fileSystem.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	writer, err := fileSystem.Create(destPath, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(writer, reader); err != nil {
+		abortWrite(fileSystem, writer, destPath)
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+
+	//Not all file systems can change the modification time, the copy is still complete without it
+	fileSystem.Chtimes(destPath, info.ModTime(), info.ModTime())
+	return nil
+}
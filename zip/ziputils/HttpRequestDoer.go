@@ -0,0 +1,31 @@
+package ziputils
+
+import (
+	"io"
+	"net/http"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+)
+
+// HttpRequestDoer sends http requests, it is implemented by *http.Client and lets callers control timeouts, headers, etc.
+type HttpRequestDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+func postPipeToUrl(doer HttpRequestDoer, url, bodyType string, pipeReader *io.PipeReader, checkResponse func(resp *http.Response) error) {
+	//Closing the reader unblocks the goroutine writing the tar stream if the request fails early
+	defer pipeReader.Close()
+
+	req, err := http.NewRequest("POST", url, pipeReader)
+	CheckError(err)
+	req.Header.Set("Content-Type", bodyType)
+
+	resp, err := doer.Do(req)
+	CheckError(err)
+	defer resp.Body.Close()
+
+	if checkResponse != nil {
+		err := checkResponse(resp)
+		CheckError(err)
+	}
+}
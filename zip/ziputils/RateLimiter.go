@@ -0,0 +1,149 @@
+package ziputils
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+RateLimiter limits throughput to a number of bytes per second (a token bucket which allows bursts of up to
+burstBytes). It is safe for concurrent use, so one RateLimiter can be shared to limit many transfers globally.
+
+For example to limit reading a request body to 1 MiB/s:
+
+	limiter := ziputils.NewRateLimiter(1024*1024, 0)
+	SaveTarReaderToPath(logger, ziputils.NewRateLimitedReader(r.Body, limiter), savePath)
+*/
+type RateLimiter struct {
+	mutex          sync.Mutex
+	bytesPerSecond float64
+	burstBytes     int64
+	tokens         float64
+	lastRefill     time.Time
+}
+
+// NewRateLimiter creates a RateLimiter, a burstBytes of zero or less defaults to one second worth of bytes
+func NewRateLimiter(bytesPerSecond, burstBytes int64) *RateLimiter {
+	if bytesPerSecond <= 0 {
+		panic("Rate limit must be more than 0 bytes per second")
+	}
+	if burstBytes <= 0 {
+		burstBytes = bytesPerSecond
+	}
+
+	return &RateLimiter{
+		bytesPerSecond: float64(bytesPerSecond),
+		burstBytes:     burstBytes,
+		tokens:         float64(burstBytes),
+		lastRefill:     time.Now(),
+	}
+}
+
+// Wait blocks until byteCount bytes may be transferred. Bytes are taken up front, so a large count puts the limiter in debt for the next callers.
+func (l *RateLimiter) Wait(byteCount int) {
+	l.mutex.Lock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.lastRefill).Seconds() * l.bytesPerSecond
+	if l.tokens > float64(l.burstBytes) {
+		l.tokens = float64(l.burstBytes)
+	}
+	l.lastRefill = now
+
+	l.tokens -= float64(byteCount)
+	var waitDuration time.Duration
+	if l.tokens < 0 {
+		waitDuration = time.Duration(-l.tokens / l.bytesPerSecond * float64(time.Second))
+	}
+
+	l.mutex.Unlock()
+
+	if waitDuration > 0 {
+		time.Sleep(waitDuration)
+	}
+}
+
+func (l *RateLimiter) maxChunkSize(requested int) int {
+	if int64(requested) > l.burstBytes {
+		return int(l.burstBytes)
+	}
+	return requested
+}
+
+type rateLimitedReader struct {
+	reader  io.Reader
+	limiter *RateLimiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p[:r.limiter.maxChunkSize(len(p))])
+	if n > 0 {
+		r.limiter.Wait(n)
+	}
+	return n, err
+}
+
+type rateLimitedReadCloser struct {
+	*rateLimitedReader
+	closer io.Closer
+}
+
+func (r *rateLimitedReadCloser) Close() error {
+	return r.closer.Close()
+}
+
+type rateLimitedWriter struct {
+	writer  io.Writer
+	limiter *RateLimiter
+}
+
+func (w *rateLimitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		chunk := p[written:]
+		chunk = chunk[:w.limiter.maxChunkSize(len(chunk))]
+
+		w.limiter.Wait(len(chunk))
+		n, err := w.writer.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+type rateLimitedResponseWriter struct {
+	http.ResponseWriter
+	limitedWriter *rateLimitedWriter
+}
+
+func (w *rateLimitedResponseWriter) Write(p []byte) (int, error) {
+	return w.limitedWriter.Write(p)
+}
+
+func (w *rateLimitedResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func NewRateLimitedReader(reader io.Reader, limiter *RateLimiter) io.Reader {
+	return &rateLimitedReader{reader, limiter}
+}
+
+// NewRateLimitedReadCloser is like NewRateLimitedReader but keeps the Close of readCloser, for example to wrap a http body
+func NewRateLimitedReadCloser(readCloser io.ReadCloser, limiter *RateLimiter) io.ReadCloser {
+	return &rateLimitedReadCloser{&rateLimitedReader{readCloser, limiter}, readCloser}
+}
+
+func NewRateLimitedWriter(writer io.Writer, limiter *RateLimiter) io.Writer {
+	return &rateLimitedWriter{writer, limiter}
+}
+
+// NewRateLimitedResponseWriter limits the body written to writer, as used by UploadDirectoryToHttpResponseWriter and UploadFileToHttpResponseWriter
+func NewRateLimitedResponseWriter(writer http.ResponseWriter, limiter *RateLimiter) http.ResponseWriter {
+	return &rateLimitedResponseWriter{writer, &rateLimitedWriter{writer, limiter}}
+}
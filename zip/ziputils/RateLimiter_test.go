@@ -0,0 +1,55 @@
+package ziputils
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRateLimiter(t *testing.T) {
+	Convey("Testing RateLimiter", t, func() {
+		data := make([]byte, 60*1024)
+
+		Convey("Reading is limited after the burst is used up", func() {
+			limiter := NewRateLimiter(100*1024, 10*1024)
+
+			startTime := time.Now()
+			n, err := io.Copy(ioutil.Discard, NewRateLimitedReader(bytes.NewReader(data), limiter))
+			duration := time.Now().Sub(startTime)
+
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, len(data))
+			So(duration, ShouldBeGreaterThanOrEqualTo, 400*time.Millisecond)
+			So(duration, ShouldBeLessThan, 1500*time.Millisecond)
+		})
+
+		Convey("Writing is limited after the burst is used up", func() {
+			limiter := NewRateLimiter(100*1024, 10*1024)
+			buf := &bytes.Buffer{}
+
+			startTime := time.Now()
+			n, err := NewRateLimitedWriter(buf, limiter).Write(data)
+			duration := time.Now().Sub(startTime)
+
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, len(data))
+			So(buf.Bytes(), ShouldResemble, data)
+			So(duration, ShouldBeGreaterThanOrEqualTo, 400*time.Millisecond)
+			So(duration, ShouldBeLessThan, 1500*time.Millisecond)
+		})
+
+		Convey("A burst is not limited", func() {
+			limiter := NewRateLimiter(1024, 64*1024)
+
+			startTime := time.Now()
+			_, err := io.Copy(ioutil.Discard, NewRateLimitedReader(bytes.NewReader(data), limiter))
+
+			So(err, ShouldBeNil)
+			So(time.Now().Sub(startTime), ShouldBeLessThan, 200*time.Millisecond)
+		})
+	})
+}
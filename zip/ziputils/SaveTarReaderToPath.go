@@ -2,14 +2,75 @@ package ziputils
 
 import (
 	"archive/tar"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+	"github.com/francoishill/golang-web-dry/logging/leveledlog"
 )
 
+// ErrTarValidationFailed is panicked when a tar stream ends without the END_OF_TAR marker
+var ErrTarValidationFailed = errors.New("TAR stream validation failed, something has gone wrong during the transfer.")
+
+// SaveTarOptions lets the caller inspect (and abort by panicking) the files of a tar stream while they are being saved
+type SaveTarOptions struct {
+	//BeforeFile is called before a file is written, declaredSize is the SIZE sent along by the sender (or the tar header size)
+	BeforeFile func(destinationPath string, declaredSize int64)
+	//AfterFile is called once a file has been completely written and closed, with its mode and modification time already set
+	AfterFile func(destinationPath string, writtenSize int64)
+	//FileSystem (if set) is where the files are saved instead of the local disk
+	FileSystem FileSystem
+}
+
+// InvalidTarEntryError is panicked when the name of a tar entry would save it outside the save path, like "../x"
+type InvalidTarEntryError struct {
+	Name     string
+	SavePath string
+}
+
+func (e *InvalidTarEntryError) Error() string {
+	return fmt.Sprintf("The TAR entry '%s' is outside of the save path '%s'", e.Name, e.SavePath)
+}
+
+/*
+TarEntryPath returns the path inside savePath where the tar entry with this name is saved. The sender of a tar cannot
+be trusted, so it panics with an *InvalidTarEntryError if the name would escape savePath.
+*/
+func TarEntryPath(savePath, name string) string {
+	cleanSavePath := filepath.Clean(savePath)
+	entryPath := filepath.Join(cleanSavePath, filepath.FromSlash(name))
+	if entryPath != cleanSavePath && !strings.HasPrefix(entryPath, strings.TrimRight(cleanSavePath, `/\`)+string(filepath.Separator)) {
+		panic(&InvalidTarEntryError{Name: name, SavePath: savePath})
+	}
+	return entryPath
+}
+
+// GetDeclaredSize is the SIZE sent along by the sender of the tar entry, or the size in its header
+func GetDeclaredSize(hdr *tar.Header) int64 {
+	if sizeStr, ok := hdr.Xattrs["SIZE"]; ok {
+		if size, err := strconv.ParseInt(sizeStr, 10, 64); err == nil {
+			return size
+		}
+	}
+	return hdr.Size
+}
+
 func SaveTarReaderToPath(logger SimpleLogger, bodyReader io.Reader, savePath string) {
+	SaveTarReaderToPathWithOptions(logger, bodyReader, savePath, nil)
+}
+
+func SaveTarReaderToPathWithOptions(logger SimpleLogger, bodyReader io.Reader, savePath string, options *SaveTarOptions) {
+	if options == nil {
+		options = &SaveTarOptions{}
+	}
+	leveledLogger := leveledlog.FromDebugLogger(logger)
+	fileSystem := fileSystemOrLocal(options.FileSystem)
+
 	tarReader := tar.NewReader(bodyReader)
 
 	foundEndOfTar := false
@@ -26,37 +87,56 @@ func SaveTarReaderToPath(logger SimpleLogger, bodyReader io.Reader, savePath str
 			continue
 		}
 
-		relativePath := hdr.Name
-
 		if hdr.FileInfo().IsDir() {
-			fullDestinationDirPath := filepath.Join(savePath, relativePath)
-			logger.Debug("(TAR) Creating directory %s", fullDestinationDirPath)
-			os.MkdirAll(fullDestinationDirPath, os.FileMode(hdr.Mode))
-			defer os.Chtimes(fullDestinationDirPath, hdr.AccessTime, hdr.ModTime)
+			fullDestinationDirPath := TarEntryPath(savePath, hdr.Name)
+			leveledLogger.With(leveledlog.F("path", fullDestinationDirPath)).Debug("(TAR) Creating directory")
+			fileSystem.MkdirAll(fullDestinationDirPath, os.FileMode(hdr.Mode))
+			defer fileSystem.Chtimes(fullDestinationDirPath, hdr.AccessTime, hdr.ModTime)
 		} else {
-			fullDestinationFilePath := filepath.Join(savePath, relativePath)
-			if val, ok := hdr.Xattrs["SINGLE_FILE_ONLY"]; ok && val == "1" {
-				fullDestinationFilePath = savePath
+			fullDestinationFilePath := savePath
+			if val, ok := hdr.Xattrs["SINGLE_FILE_ONLY"]; !ok || val != "1" {
+				fullDestinationFilePath = TarEntryPath(savePath, hdr.Name)
 			}
 
-			err = os.MkdirAll(filepath.Dir(fullDestinationFilePath), os.FileMode(hdr.Mode))
+			err = fileSystem.MkdirAll(filepath.Dir(fullDestinationFilePath), os.FileMode(hdr.Mode))
 			CheckError(err)
 
-			logger.Debug("(TAR) Saving file %s", fullDestinationFilePath)
-			file, err := os.OpenFile(fullDestinationFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(hdr.Mode))
-			CheckError(err)
+			if options.BeforeFile != nil {
+				options.BeforeFile(fullDestinationFilePath, GetDeclaredSize(hdr))
+			}
 
-			defer func() {
-				file.Close()
-				os.Chtimes(fullDestinationFilePath, hdr.AccessTime, hdr.ModTime)
-			}()
+			leveledLogger.With(leveledlog.F("path", fullDestinationFilePath), leveledlog.F("bytes", GetDeclaredSize(hdr))).Debug("(TAR) Saving file")
+			written := saveTarEntryToFile(tarReader, fileSystem, fullDestinationFilePath, os.FileMode(hdr.Mode))
+			fileSystem.Chtimes(fullDestinationFilePath, hdr.AccessTime, hdr.ModTime)
 
-			_, err = io.Copy(file, tarReader)
-			CheckError(err)
+			if options.AfterFile != nil {
+				options.AfterFile(fullDestinationFilePath, written)
+			}
 		}
 	}
 
 	if !foundEndOfTar {
-		panic("TAR stream validation failed, something has gone wrong during the transfer.")
+		panic(ErrTarValidationFailed)
 	}
 }
+
+func saveTarEntryToFile(tarReader *tar.Reader, fileSystem FileSystem, filePath string, mode os.FileMode) int64 {
+	file, err := fileSystem.Create(filePath, mode)
+	CheckError(err)
+
+	defer func() {
+		if r := recover(); r != nil {
+			//Do not leave a partially written file behind if the transfer was interrupted
+			abortWrite(fileSystem, file, filePath)
+			panic(r)
+		}
+	}()
+
+	written, err := io.Copy(file, tarReader)
+	CheckError(err)
+
+	err = file.Close()
+	CheckError(err)
+
+	return written
+}
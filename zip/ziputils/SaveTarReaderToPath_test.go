@@ -0,0 +1,61 @@
+package ziputils
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/francoishill/golang-web-dry/logging/leveledlog"
+)
+
+func TestSaveTarReaderToPath(t *testing.T) {
+	Convey("Testing SaveTarReaderToPath", t, func() {
+		dir, err := ioutil.TempDir("", "ziputils-save")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		tarOf := func(names ...string) *bytes.Buffer {
+			buffer := &bytes.Buffer{}
+			tarWriter := tar.NewWriter(buffer)
+			for _, name := range append(names, END_OF_TAR_FILENAME) {
+				So(tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(name))}), ShouldBeNil)
+				_, err := tarWriter.Write([]byte(name))
+				So(err, ShouldBeNil)
+			}
+			So(tarWriter.Close(), ShouldBeNil)
+			return buffer
+		}
+		savePath := filepath.Join(dir, "save")
+
+		Convey("The entries should be saved inside the save path", func() {
+			SaveTarReaderToPath(leveledlog.Discard(), tarOf("a.txt", "sub/b.txt"), savePath)
+
+			content, err := ioutil.ReadFile(filepath.Join(savePath, "sub", "b.txt"))
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "sub/b.txt")
+		})
+
+		Convey("Entries escaping the save path should be rejected before they are written", func() {
+			for _, name := range []string{"../outside.txt", "sub/../../outside.txt"} {
+				var recovered interface{}
+				func() {
+					defer func() { recovered = recover() }()
+					SaveTarReaderToPath(leveledlog.Discard(), tarOf(name), savePath)
+				}()
+				So(recovered, ShouldResemble, &InvalidTarEntryError{Name: name, SavePath: savePath})
+			}
+			_, err := os.Stat(filepath.Join(dir, "outside.txt"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("TarEntryPath should keep absolute names inside the save path", func() {
+			So(TarEntryPath(savePath, "/etc/passwd"), ShouldEqual, filepath.Join(savePath, "etc", "passwd"))
+			So(TarEntryPath(savePath+"/", "a/./b"), ShouldEqual, filepath.Join(savePath, "a", "b"))
+		})
+	})
+}
@@ -1,5 +1,7 @@
 package ziputils
 
+// SimpleLogger is the logger of the functions of this package, a leveledlog.Logger is one too. The functions log
+// through leveledlog.FromDebugLogger, so a logger with only a Debug method still gets all the messages.
 type SimpleLogger interface {
 	Debug(msg string, msgArgs ...interface{})
 }
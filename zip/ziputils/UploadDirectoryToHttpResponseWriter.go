@@ -2,12 +2,12 @@ package ziputils
 
 import (
 	"archive/tar"
-	"github.com/francoishill/golang-web-dry/osutils"
 	"net/http"
 )
 
 func UploadDirectoryToHttpResponseWriter(logger SimpleLogger, writer http.ResponseWriter, directoryPath string, walkContext *dirWalkContext) {
-	if !osutils.DirectoryExists(directoryPath) {
+	info, err := walkContext.fileSystem().Stat(directoryPath)
+	if err != nil || !info.IsDir() {
 		panic("Directory does not exist: " + directoryPath)
 	}
 
@@ -16,3 +16,11 @@ func UploadDirectoryToHttpResponseWriter(logger SimpleLogger, writer http.Respon
 
 	addDirectoryToTarStream(tarWriter, directoryPath, walkContext, true)
 }
+
+// UploadPathsToHttpResponseWriter sends all paths in one tar stream, each of them under its base name (see UploadPathsToUrlWithDoer)
+func UploadPathsToHttpResponseWriter(logger SimpleLogger, writer http.ResponseWriter, paths []string, walkContext *dirWalkContext) {
+	tarWriter := tar.NewWriter(writer)
+	defer tarWriter.Close()
+
+	addPathsToTarStream(tarWriter, paths, walkContext)
+}
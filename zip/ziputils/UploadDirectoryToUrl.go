@@ -12,6 +12,10 @@ import (
 )
 
 func UploadDirectoryToUrl(logger SimpleLogger, url, bodyType, directoryPath string, walkContext *dirWalkContext, checkResponse func(resp *http.Response) error) {
+	UploadDirectoryToUrlWithDoer(logger, http.DefaultClient, url, bodyType, directoryPath, walkContext, checkResponse)
+}
+
+func UploadDirectoryToUrlWithDoer(logger SimpleLogger, doer HttpRequestDoer, url, bodyType, directoryPath string, walkContext *dirWalkContext, checkResponse func(resp *http.Response) error) {
 	if !osutils.DirectoryExists(directoryPath) {
 		panic("Directory does not exist: " + directoryPath)
 	}
@@ -24,6 +28,7 @@ func UploadDirectoryToUrl(logger SimpleLogger, url, bodyType, directoryPath stri
 
 	var goroutineErr error
 	go func() {
+		defer wg.Done()
 		defer func() {
 			if r := recover(); r != nil {
 				goroutineErr = fmt.Errorf("Cannot add directory to tar stream, error: %+v", r)
@@ -33,18 +38,9 @@ func UploadDirectoryToUrl(logger SimpleLogger, url, bodyType, directoryPath stri
 		addDirectoryToTarStream(tarWriter, directoryPath, walkContext, true)
 		tarWriter.Close()
 		pipeWriter.Close()
-
-		wg.Done()
 	}()
 
-	resp, err := http.Post(url, bodyType, pipeReader)
-	CheckError(err)
-	resp.Body.Close()
-
-	if checkResponse != nil {
-		err := checkResponse(resp)
-		CheckError(err)
-	}
+	postPipeToUrl(doer, url, bodyType, pipeReader, checkResponse)
 
 	wg.Wait()
 	CheckError(goroutineErr)
@@ -2,28 +2,26 @@ package ziputils
 
 import (
 	"archive/tar"
-	. "github.com/francoishill/golang-web-dry/errors/checkerror"
-	"github.com/francoishill/golang-web-dry/osutils"
 	"net/http"
-	"os"
 )
 
 func UploadFileToHttpResponseWriter(logger SimpleLogger, writer http.ResponseWriter, filePath string) {
-	if !osutils.FileExists(filePath) {
+	UploadFileToHttpResponseWriterWithFileSystem(logger, writer, nil, filePath)
+}
+
+// UploadFileToHttpResponseWriterWithFileSystem sends the file at filePath of fileSystem (the local disk if nil)
+func UploadFileToHttpResponseWriterWithFileSystem(logger SimpleLogger, writer http.ResponseWriter, fileSystem FileSystem, filePath string) {
+	fileSystem = fileSystemOrLocal(fileSystem)
+
+	info, err := fileSystem.Stat(filePath)
+	if err != nil || info.IsDir() {
 		panic("File does not exist: " + filePath)
 	}
 
 	tarWriter := tar.NewWriter(writer)
 	defer tarWriter.Close()
 
-	file, err := os.OpenFile(filePath, 0, 0600)
-	CheckError(err)
-	defer file.Close()
-
-	info, err := file.Stat()
-	CheckError(err)
-
-	writeFileToTarWriter(tarWriter, info, filePath, "", true)
+	writeFileToTarWriter(tarWriter, fileSystem, info, filePath, "", true)
 
 	writeEndOfTarStreamHeader(tarWriter)
 }
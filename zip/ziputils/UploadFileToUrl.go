@@ -13,6 +13,10 @@ import (
 )
 
 func UploadFileToUrl(logger SimpleLogger, url, bodyType, filePath string, checkResponse func(resp *http.Response) error) {
+	UploadFileToUrlWithDoer(logger, http.DefaultClient, url, bodyType, filePath, checkResponse)
+}
+
+func UploadFileToUrlWithDoer(logger SimpleLogger, doer HttpRequestDoer, url, bodyType, filePath string, checkResponse func(resp *http.Response) error) {
 	if !osutils.FileExists(filePath) {
 		panic("File does not exist: " + filePath)
 	}
@@ -32,29 +36,21 @@ func UploadFileToUrl(logger SimpleLogger, url, bodyType, filePath string, checkR
 
 	var goroutineErr error
 	go func() {
+		defer wg.Done()
 		defer func() {
 			if r := recover(); r != nil {
 				goroutineErr = fmt.Errorf("Cannot add directory to tar stream, error: %+v", r)
 			}
 		}()
 
-		writeFileToTarWriter(tarWriter, info, filePath, "", true)
+		writeFileToTarWriter(tarWriter, localFileSystem{}, info, filePath, "", true)
 		writeEndOfTarStreamHeader(tarWriter)
 
 		tarWriter.Close()
 		pipeWriter.Close()
-
-		wg.Done()
 	}()
 
-	resp, err := http.Post(url, bodyType, pipeReader)
-	CheckError(err)
-	resp.Body.Close()
-
-	if checkResponse != nil {
-		err := checkResponse(resp)
-		CheckError(err)
-	}
+	postPipeToUrl(doer, url, bodyType, pipeReader, checkResponse)
 
 	wg.Wait()
 	CheckError(goroutineErr)
@@ -69,7 +65,7 @@ func UploadFileToUrl(logger SimpleLogger, url, bodyType, filePath string, checkR
 	info, err := file.Stat()
 	CheckError(err)
 
-	writeFileToTarWriter(tarWriter, info, filePath, "", true)
+	writeFileToTarWriter(tarWriter, localFileSystem{}, info, filePath, "", true)
 
 	writeEndOfTarStreamHeader(tarWriter)*/
 }
@@ -0,0 +1,51 @@
+package ziputils
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+)
+
+/*
+Uploads multiple files and directories in a single tar stream, the url must save it into a directory where each of the paths
+ends up under its base name. For example to upload two files and a directory into the remote directory /srv/builds:
+
+	UploadPathsToUrlWithDoer(logger, http.DefaultClient, serverUrl+"?dir=/srv/builds", "application/octet-stream",
+		[]string{"app.zip", "app.sha256", "docs"}, NewDirWalkContext(""), nil)
+*/
+func UploadPathsToUrlWithDoer(logger SimpleLogger, doer HttpRequestDoer, url, bodyType string, paths []string, walkContext *dirWalkContext, checkResponse func(resp *http.Response) error) {
+	for _, path := range paths {
+		_, err := walkContext.fileSystem().Stat(path)
+		CheckError(err)
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	tarWriter := tar.NewWriter(pipeWriter)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+
+	var goroutineErr error
+	go func() {
+		defer wg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				goroutineErr = fmt.Errorf("Cannot add paths to tar stream, error: %+v", r)
+				pipeWriter.CloseWithError(goroutineErr)
+			}
+		}()
+
+		addPathsToTarStream(tarWriter, paths, walkContext)
+		tarWriter.Close()
+		pipeWriter.Close()
+	}()
+
+	postPipeToUrl(doer, url, bodyType, pipeReader, checkResponse)
+
+	wg.Wait()
+	CheckError(goroutineErr)
+}
@@ -4,17 +4,17 @@ import (
 	"archive/tar"
 	. "github.com/francoishill/golang-web-dry/errors/checkerror"
 	"os"
-	"path/filepath"
 )
 
 func addDirectoryToTarStream(tarWriter *tar.Writer, dir string, walkContext *dirWalkContext, writeEndHeader bool) {
-	e := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+	fileSystem := walkContext.fileSystem()
+	e := fileSystem.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
-		if !walkContext.isMatch(info) {
-			return nil
+		if !walkContext.isMatch(path, info) {
+			return skipUnmatched(info)
 		}
 
 		relPath := path[len(dir):]
@@ -24,7 +24,7 @@ func addDirectoryToTarStream(tarWriter *tar.Writer, dir string, walkContext *dir
 
 		relPath = relPath[1:]
 
-		writeFileToTarWriter(tarWriter, info, path, relPath, false)
+		writeFileToTarWriter(tarWriter, fileSystem, info, path, relPath, false)
 
 		return nil
 	})
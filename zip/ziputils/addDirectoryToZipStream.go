@@ -14,11 +14,11 @@ func addDirectoryToZipStream(w *zip.Writer, dir string, walkContext *dirWalkCont
 			return err
 		}
 
-		if info.IsDir() {
-			return nil
+		if !walkContext.isMatch(path, info) {
+			return skipUnmatched(info)
 		}
 
-		if !walkContext.isMatch(info) {
+		if info.IsDir() {
 			return nil
 		}
 
@@ -0,0 +1,35 @@
+package ziputils
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+
+	. "github.com/francoishill/golang-web-dry/errors/checkerror"
+)
+
+// addPathsToTarStream adds each file or directory of paths to the tar stream under its base name, so they are all saved inside the same destination directory
+func addPathsToTarStream(tarWriter *tar.Writer, paths []string, walkContext *dirWalkContext) {
+	fileSystem := walkContext.fileSystem()
+	for _, rootPath := range paths {
+		baseName := filepath.Base(rootPath)
+
+		e := fileSystem.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			//The given files are always added, the filter only applies to the files inside the given directories
+			if path != rootPath && !walkContext.isMatch(path, info) {
+				return skipUnmatched(info)
+			}
+
+			relPath := filepath.ToSlash(filepath.Join(baseName, path[len(rootPath):]))
+			writeFileToTarWriter(tarWriter, fileSystem, info, path, relPath, false)
+			return nil
+		})
+		CheckError(e)
+	}
+
+	writeEndOfTarStreamHeader(tarWriter)
+}
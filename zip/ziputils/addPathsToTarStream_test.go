@@ -0,0 +1,50 @@
+package ziputils
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/francoishill/golang-web-dry/logging/leveledlog"
+)
+
+func TestAddPathsToTarStream(t *testing.T) {
+	Convey("Testing addPathsToTarStream", t, func() {
+		dir, err := ioutil.TempDir("", "ziputils-paths")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		for _, relPath := range []string{"src/a.log", "src/b.txt", "src/logs/c.log", "src/logs/d.txt"} {
+			fullPath := filepath.Join(dir, relPath)
+			So(os.MkdirAll(filepath.Dir(fullPath), 0755), ShouldBeNil)
+			So(ioutil.WriteFile(fullPath, []byte(relPath), 0644), ShouldBeNil)
+		}
+
+		exists := func(relPath string) bool {
+			_, err := os.Stat(filepath.Join(dir, relPath))
+			return err == nil
+		}
+
+		Convey("The files and directories should be saved under their base names", func() {
+			buffer := &bytes.Buffer{}
+			tarWriter := tar.NewWriter(buffer)
+			paths := []string{filepath.Join(dir, "src", "b.txt"), filepath.Join(dir, "src", "logs")}
+			addPathsToTarStream(tarWriter, paths, NewDirWalkContext("*.log"))
+			So(tarWriter.Close(), ShouldBeNil)
+
+			SaveTarReaderToPath(leveledlog.Discard(), buffer, filepath.Join(dir, "dest"))
+
+			content, err := ioutil.ReadFile(filepath.Join(dir, "dest", "b.txt"))
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "src/b.txt")
+			So(exists("dest/logs/c.log"), ShouldBeTrue)
+			So(exists("dest/logs/d.txt"), ShouldBeFalse)
+			So(exists("dest/a.log"), ShouldBeFalse)
+		})
+	})
+}
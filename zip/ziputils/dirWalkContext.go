@@ -8,42 +8,257 @@ import (
 
 type dirWalkContext struct {
 	FileFilterPattern string
+	//IncludeFile (if set) is called for the files matching FileFilterPattern, it can exclude more files by returning false
+	IncludeFile func(path string, info os.FileInfo) bool
+	//IncludeDir (if set) is called for the directories, the ones it returns false for are skipped along with everything inside them
+	IncludeDir func(path string, info os.FileInfo) bool
+	//FileSystem (if set) is walked instead of the local disk
+	FileSystem FileSystem
 }
 
-func (d *dirWalkContext) isMatch(info os.FileInfo) bool {
-	if d.FileFilterPattern == "" {
-		//No filter
-		return true
+func (d *dirWalkContext) fileSystem() FileSystem {
+	if d == nil {
+		return localFileSystem{}
 	}
+	return fileSystemOrLocal(d.FileSystem)
+}
+
+func (d *dirWalkContext) isMatch(path string, info os.FileInfo) bool {
 	if info.IsDir() {
-		//Always let
-		return true
+		//Always let, unless excluded by IncludeDir
+		return d.IncludeDir == nil || d.IncludeDir(path, info)
+	}
+
+	if d.FileFilterPattern != "" {
+		matched, err := filepath.Match(d.FileFilterPattern, info.Name())
+		CheckError(err)
+		if !matched {
+			return false
+		}
 	}
 
-	matched, err := filepath.Match(d.FileFilterPattern, info.Name())
-	CheckError(err)
-	return matched
+	return d.IncludeFile == nil || d.IncludeFile(path, info)
 }
 
-func (d *dirWalkContext) DeleteDirectory(dir string) {
-	if d.FileFilterPattern == "" {
-		err := os.RemoveAll(dir)
-		CheckError(err)
-	} else {
-		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+// skipUnmatched is the result for the filepath.Walk callbacks of a path that is not a match, the contents of an excluded directory are skipped too
+func skipUnmatched(info os.FileInfo) error {
+	if info.IsDir() {
+		return filepath.SkipDir
+	}
+	return nil
+}
+
+// DeleteOptions changes what DeleteDirectoryWithOptions does, nil means the defaults (all false)
+type DeleteOptions struct {
+	//DryRun only lists the paths that would be deleted, nothing is removed
+	DryRun bool
+	//RemoveEmptyDirs also removes the subdirectories that are empty after the matching files were deleted (only applies with a FileFilterPattern)
+	RemoveEmptyDirs bool
+}
+
+type DeleteFileError struct {
+	Path string
+	Err  error
+}
+
+// DeleteResult lists the deleted (or with DryRun the would-be deleted) paths and the files and directories that could not be deleted
+type DeleteResult struct {
+	DeletedPaths []string
+	Errors       []*DeleteFileError
+}
+
+func (r *DeleteResult) addError(path string, err error) {
+	r.Errors = append(r.Errors, &DeleteFileError{Path: path, Err: err})
+}
+
+func (d *dirWalkContext) DeleteDirectory(dir string) *DeleteResult {
+	return d.DeleteDirectoryWithOptions(dir, nil)
+}
+
+/*
+Deletes dir, or only the files matching FileFilterPattern inside it. Errors while deleting the matching files
+do not stop the walk, they are returned in the DeleteResult instead.
+
+For example to see which .tmp files would be deleted, without deleting them:
+
+	result := NewDirWalkContext("*.tmp").DeleteDirectoryWithOptions(dir, &DeleteOptions{DryRun: true})
+*/
+func (d *dirWalkContext) DeleteDirectoryWithOptions(dir string, options *DeleteOptions) *DeleteResult {
+	if options == nil {
+		options = &DeleteOptions{}
+	}
+
+	result := &DeleteResult{}
+	fileSystem := d.fileSystem()
+
+	if d.FileFilterPattern == "" && d.IncludeFile == nil && d.IncludeDir == nil {
+		e := fileSystem.Walk(dir, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
 				return err
 			}
+			result.DeletedPaths = append(result.DeletedPaths, path)
+			return nil
+		})
+		CheckError(e)
+
+		if !options.DryRun {
+			err := fileSystem.RemoveAll(dir)
+			CheckError(err)
+		}
+		return result
+	}
 
-			if !info.IsDir() &&
-				d.isMatch(info) {
-				//Skip directories if we are filtering for files
-				return os.Remove(path)
+	deleted := map[string]bool{}
+	subDirs := []string{}
+	fileSystem.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			result.addError(path, err)
+			return nil
+		}
+
+		if info.IsDir() {
+			if !d.isMatch(path, info) {
+				return filepath.SkipDir
 			}
 
+			//Skip directories if we are filtering for files
+			if path != dir {
+				subDirs = append(subDirs, path)
+			}
 			return nil
-		})
+		}
+
+		if !d.isMatch(path, info) {
+			return nil
+		}
+
+		if !options.DryRun {
+			if err = fileSystem.Remove(path); err != nil {
+				result.addError(path, err)
+				return nil
+			}
+		}
+		deleted[path] = true
+		result.DeletedPaths = append(result.DeletedPaths, path)
+		return nil
+	})
+
+	if options.RemoveEmptyDirs {
+		//Deepest first, so a parent only containing (now deleted) empty directories is also removed
+		for i := len(subDirs) - 1; i >= 0; i-- {
+			subDir := subDirs[i]
+			if !isDirEmptyExcept(fileSystem, subDir, deleted) {
+				continue
+			}
+
+			if !options.DryRun {
+				if err := fileSystem.Remove(subDir); err != nil {
+					result.addError(subDir, err)
+					continue
+				}
+			}
+			deleted[subDir] = true
+			result.DeletedPaths = append(result.DeletedPaths, subDir)
+		}
+	}
+
+	return result
+}
+
+// isDirEmptyExcept is true if all entries of dir are in the deleted paths (needed to know which directories a DryRun would remove)
+func isDirEmptyExcept(fileSystem FileSystem, dir string, deleted map[string]bool) bool {
+	names, err := fileSystem.ReadDirNames(dir)
+	if err != nil {
+		return false
+	}
+
+	for _, name := range names {
+		if !deleted[filepath.Join(dir, name)] {
+			return false
+		}
 	}
+	return true
+}
+
+/*
+CopyDirectory copies the matching files (and all directories) of srcDir to destDir. If destDir is inside srcDir it is
+left out of the walk, so the copies are never copied again.
+*/
+func (d *dirWalkContext) CopyDirectory(srcDir, destDir string) {
+	cleanSrcDir, cleanDestDir := filepath.Clean(srcDir), filepath.Clean(destDir)
+	fileSystem := d.fileSystem()
+
+	e := fileSystem.Walk(cleanSrcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() && path == cleanDestDir {
+			return filepath.SkipDir
+		}
+		if !d.isMatch(path, info) {
+			return skipUnmatched(info)
+		}
+
+		relPath, err := filepath.Rel(cleanSrcDir, path)
+		if err != nil {
+			return err
+		}
+		destPath := filepath.Join(cleanDestDir, relPath)
+		if info.IsDir() {
+			return fileSystem.MkdirAll(destPath, info.Mode().Perm())
+		}
+
+		return copyFile(fileSystem, path, destPath, info)
+	})
+
+	CheckError(e)
+}
+
+/*
+MoveFiles moves the matching files inside srcDir to the same relative paths inside destDir, directories are left behind.
+Like DeleteDirectoryWithOptions the files that could not be moved do not stop the walk, the moved files are the
+DeletedPaths of the result (they are gone from srcDir) and the others are its Errors.
+*/
+func (d *dirWalkContext) MoveFiles(srcDir, destDir string) *DeleteResult {
+	result := &DeleteResult{}
+	cleanSrcDir, cleanDestDir := filepath.Clean(srcDir), filepath.Clean(destDir)
+	fileSystem := d.fileSystem()
+	fileSystem.Walk(cleanSrcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			result.addError(path, err)
+			return nil
+		}
+
+		if info.IsDir() && path == cleanDestDir {
+			return filepath.SkipDir
+		}
+		if !d.isMatch(path, info) {
+			return skipUnmatched(info)
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(cleanSrcDir, path)
+		if err != nil {
+			result.addError(path, err)
+			return nil
+		}
+		destPath := filepath.Join(cleanDestDir, relPath)
+		if err = fileSystem.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+			result.addError(path, err)
+			return nil
+		}
+		if err = fileSystem.Rename(path, destPath); err != nil {
+			result.addError(path, err)
+			return nil
+		}
+		result.DeletedPaths = append(result.DeletedPaths, path)
+		return nil
+	})
+
+	return result
 }
 
 /*
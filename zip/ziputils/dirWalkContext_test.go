@@ -0,0 +1,137 @@
+package ziputils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDeleteDirectoryWithOptions(t *testing.T) {
+	Convey("Testing DeleteDirectoryWithOptions", t, func() {
+		dir, err := ioutil.TempDir("", "ziputils-delete")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		for _, relPath := range []string{"a.tmp", "keep.txt", "sub/b.tmp", "sub/deeper/c.tmp", "other/d.tmp", "other/keep.txt"} {
+			fullPath := filepath.Join(dir, relPath)
+			So(os.MkdirAll(filepath.Dir(fullPath), 0755), ShouldBeNil)
+			So(ioutil.WriteFile(fullPath, []byte(relPath), 0644), ShouldBeNil)
+		}
+
+		exists := func(relPath string) bool {
+			_, err := os.Stat(filepath.Join(dir, relPath))
+			return err == nil
+		}
+		relPaths := func(result *DeleteResult) []string {
+			paths := []string{}
+			for _, path := range result.DeletedPaths {
+				relPath, _ := filepath.Rel(dir, path)
+				paths = append(paths, filepath.ToSlash(relPath))
+			}
+			sort.Strings(paths)
+			return paths
+		}
+
+		Convey("A dry run lists the matching files and empty directories without deleting anything", func() {
+			result := NewDirWalkContext("*.tmp").DeleteDirectoryWithOptions(dir, &DeleteOptions{DryRun: true, RemoveEmptyDirs: true})
+
+			So(result.Errors, ShouldBeEmpty)
+			So(relPaths(result), ShouldResemble, []string{"a.tmp", "other/d.tmp", "sub", "sub/b.tmp", "sub/deeper", "sub/deeper/c.tmp"})
+			So(exists("a.tmp"), ShouldBeTrue)
+			So(exists("sub/deeper/c.tmp"), ShouldBeTrue)
+		})
+
+		Convey("Filtered deletion only removes the matching files", func() {
+			result := NewDirWalkContext("*.tmp").DeleteDirectory(dir)
+
+			So(result.Errors, ShouldBeEmpty)
+			So(relPaths(result), ShouldResemble, []string{"a.tmp", "other/d.tmp", "sub/b.tmp", "sub/deeper/c.tmp"})
+			So(exists("a.tmp"), ShouldBeFalse)
+			So(exists("keep.txt"), ShouldBeTrue)
+			So(exists("sub/deeper"), ShouldBeTrue)
+		})
+
+		Convey("Directories left empty are removed with RemoveEmptyDirs", func() {
+			result := NewDirWalkContext("*.tmp").DeleteDirectoryWithOptions(dir, &DeleteOptions{RemoveEmptyDirs: true})
+
+			So(result.Errors, ShouldBeEmpty)
+			So(exists("sub"), ShouldBeFalse)
+			So(exists("other/keep.txt"), ShouldBeTrue)
+			So(exists(""), ShouldBeTrue)
+		})
+
+		Convey("Without a filter the whole directory is deleted", func() {
+			result := NewDirWalkContext("").DeleteDirectory(dir)
+
+			So(result.DeletedPaths, ShouldContain, dir)
+			So(len(result.DeletedPaths), ShouldEqual, 10)
+			So(exists(""), ShouldBeFalse)
+		})
+	})
+}
+
+func TestCopyDirectory(t *testing.T) {
+	Convey("Testing CopyDirectory", t, func() {
+		dir, err := ioutil.TempDir("", "ziputils-copy")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		for _, relPath := range []string{"src/a.txt", "src/b.tmp", "src/sub/c.txt"} {
+			fullPath := filepath.Join(dir, relPath)
+			So(os.MkdirAll(filepath.Dir(fullPath), 0755), ShouldBeNil)
+			So(ioutil.WriteFile(fullPath, []byte(relPath), 0644), ShouldBeNil)
+		}
+		exists := func(relPath string) bool {
+			_, err := os.Stat(filepath.Join(dir, relPath))
+			return err == nil
+		}
+
+		Convey("The matching files should be copied", func() {
+			NewDirWalkContext("*.txt").CopyDirectory(filepath.Join(dir, "src"), filepath.Join(dir, "dest"))
+
+			So(exists("dest/a.txt"), ShouldBeTrue)
+			So(exists("dest/sub/c.txt"), ShouldBeTrue)
+			So(exists("dest/b.tmp"), ShouldBeFalse)
+		})
+
+		Convey("The directories excluded by IncludeDir should not be copied", func() {
+			walkContext := NewDirWalkContext("")
+			walkContext.IncludeDir = func(path string, info os.FileInfo) bool { return info.Name() != "sub" }
+			walkContext.CopyDirectory(filepath.Join(dir, "src"), filepath.Join(dir, "dest"))
+
+			So(exists("dest/a.txt"), ShouldBeTrue)
+			So(exists("dest/sub"), ShouldBeFalse)
+		})
+
+		Convey("Files that cannot be moved should be returned as errors without stopping the move", func() {
+			So(os.MkdirAll(filepath.Join(dir, "dest", "a.txt", "taken"), 0755), ShouldBeNil)
+
+			result := NewDirWalkContext("*.txt").MoveFiles(filepath.Join(dir, "src"), filepath.Join(dir, "dest"))
+
+			So(len(result.Errors), ShouldEqual, 1)
+			So(result.Errors[0].Path, ShouldEqual, filepath.Join(dir, "src", "a.txt"))
+			So(result.DeletedPaths, ShouldResemble, []string{filepath.Join(dir, "src", "sub", "c.txt")})
+			So(exists("src/a.txt"), ShouldBeTrue)
+			So(exists("dest/sub/c.txt"), ShouldBeTrue)
+		})
+
+		Convey("Copying a directory into its own subtree should not copy the copies again", func() {
+			NewDirWalkContext("").CopyDirectory(filepath.Join(dir, "src"), filepath.Join(dir, "src", "sub", "copy"))
+
+			So(exists("src/sub/copy/a.txt"), ShouldBeTrue)
+			So(exists("src/sub/copy/sub/c.txt"), ShouldBeTrue)
+			So(exists("src/sub/copy/sub/copy"), ShouldBeFalse)
+		})
+
+		Convey("Unclean paths and trailing separators should still copy to the right relative paths", func() {
+			NewDirWalkContext("").CopyDirectory(filepath.Join(dir, "other", "..", "src")+string(filepath.Separator), filepath.Join(dir, "dest")+string(filepath.Separator))
+
+			So(exists("dest/a.txt"), ShouldBeTrue)
+			So(exists("dest/sub/c.txt"), ShouldBeTrue)
+		})
+	})
+}
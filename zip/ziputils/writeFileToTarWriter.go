@@ -8,7 +8,7 @@ import (
 	"os"
 )
 
-func writeFileToTarWriter(tarWriter *tar.Writer, info os.FileInfo, absoluteFilePath, overwriteFileName string, isOnlyFile bool) {
+func writeFileToTarWriter(tarWriter *tar.Writer, fileSystem FileSystem, info os.FileInfo, absoluteFilePath, overwriteFileName string, isOnlyFile bool) {
 	hdr, err := tar.FileInfoHeader(info, "")
 	CheckError(err)
 	if overwriteFileName != "" {
@@ -27,7 +27,7 @@ func writeFileToTarWriter(tarWriter *tar.Writer, info os.FileInfo, absoluteFileP
 	CheckError(err)
 
 	if !info.IsDir() {
-		file, err := os.Open(absoluteFilePath)
+		file, err := fileSystem.Open(absoluteFilePath)
 		CheckError(err)
 		defer file.Close()
 